@@ -0,0 +1,119 @@
+// debildb - утилита обслуживания файлов бд.
+//
+//	debildb migrate [-o out.data] [-wait 5s] file.data
+//	debildb layout [-format dot|json] [-keys] [-wait 5s] file.data
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"debildb/internal/store"
+)
+
+var errUsage = errors.New("usage")
+
+// Команда утилиты
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+func main() {
+	commands := []command{
+		{name: "migrate", usage: "migrate [-o out.data] [-wait 5s] file.data", run: migrate},
+		{name: "layout", usage: "layout [-format dot|json] [-keys] [-wait 5s] file.data", run: layout},
+	}
+
+	if len(os.Args) < 2 {
+		printUsage(commands)
+		os.Exit(2)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != os.Args[1] {
+			continue
+		}
+
+		err := cmd.run(os.Args[2:])
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(os.Stderr, "usage: debildb %s\n", cmd.usage)
+			os.Exit(2)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "debildb: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "debildb: unknown command %q\n", os.Args[1])
+	printUsage(commands)
+	os.Exit(2)
+}
+
+// Функция вывода списка команд
+func printUsage(commands []command) {
+	fmt.Fprintln(os.Stderr, "usage:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  debildb %s\n", cmd.usage)
+	}
+}
+
+// Команда перевода файла бд в текущий формат: на месте или в новый файл
+func migrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	out := fs.String("o", "", "записать результат в новый файл вместо обновления на месте")
+	wait := fs.Duration("wait", 0, "сколько ждать блокировку файла, занятую другим процессом")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+
+	res, err := store.Migrate(fs.Arg(0), *out, store.Options{LockTimeout: *wait})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: format %d -> %d, %d records verified\n", fs.Arg(0), res.FromFormat, res.ToFormat, res.Records)
+
+	return nil
+}
+
+// Команда вывода раскладки директорий и бакетов: DOT для Graphviz или JSON
+func layout(args []string) error {
+	fs := flag.NewFlagSet("layout", flag.ContinueOnError)
+	format := fs.String("format", "dot", "формат вывода: dot или json")
+	keys := fs.Bool("keys", false, "выводить ключи каждого бакета")
+	wait := fs.Duration("wait", 0, "сколько ждать блокировку файла, занятую другим процессом")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+
+	var lf store.LayoutFormat
+	switch *format {
+	case "dot":
+		lf = store.LayoutDOT
+	case "json":
+		lf = store.LayoutJSON
+	default:
+		return errUsage
+	}
+	if *keys {
+		lf |= store.LayoutKeys
+	}
+
+	if _, err := os.Stat(fs.Arg(0)); err != nil { // пустая бд на месте отсутствующего файла не нужна
+		return err
+	}
+
+	stor, err := store.OpenStore(fs.Arg(0), store.Options{ReadOnly: true, LockTimeout: *wait})
+	if err != nil {
+		return err
+	}
+	defer stor.Close()
+
+	return stor.DumpLayout(os.Stdout, lf)
+}
@@ -0,0 +1,395 @@
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	bkt "debildb/internal/bucket"
+	"debildb/internal/pagestore"
+	"debildb/internal/parser"
+)
+
+const (
+	// Заголовок узла: [0:2] количество ключей, [2] признак листа, [3] тип страницы, [8:16] смещение следующего листа
+	headerLen = 16
+	childLen  = 8 // смещение дочернего узла
+)
+
+var (
+	ErrNodeTooSmall = errors.New("index node too small")
+	ErrNotIndexNode = errors.New("page is not an index node")
+)
+
+// Allocator - выделяет в файле бд место под новый узел и возвращает его смещение
+type Allocator func() (int64, error)
+
+// Tree - B+дерево ключей на страницах хранилища.
+// Листья содержат только ключи и связаны в список для последовательного обхода, значения хранятся в бакетах.
+// Удаление не перебалансирует дерево: ключ просто убирается из листа.
+type Tree struct {
+	pages    pagestore.PageStore
+	nodeSize int
+	layout   parser.Layout
+	alloc    Allocator
+	sync     bool
+	root     int64
+}
+
+// Узел дерева в памяти
+type node struct {
+	offset   int64
+	leaf     bool
+	keys     []string
+	children []int64 // у внутреннего узла детей на один больше чем ключей
+	next     int64   // следующий лист, 0 - последний лист
+}
+
+// Create - создает пустое дерево из одного листа
+func Create(pages pagestore.PageStore, nodeSize int, layout parser.Layout, alloc Allocator, sync bool) (*Tree, error) {
+	t := &Tree{pages: pages, nodeSize: nodeSize, layout: layout, alloc: alloc, sync: sync}
+	if t.internalCapacity() < 3 {
+		return nil, fmt.Errorf("create index: %w: %d bytes", ErrNodeTooSmall, nodeSize)
+	}
+
+	root, err := t.newNode(true)
+	if err != nil {
+		return nil, fmt.Errorf("create index: %w", err)
+	}
+	if err = t.writeNode(root); err != nil {
+		return nil, fmt.Errorf("create index: %w", err)
+	}
+	t.root = root.offset
+
+	return t, nil
+}
+
+// Open - открывает дерево с корнем по смещению root
+func Open(pages pagestore.PageStore, nodeSize int, layout parser.Layout, alloc Allocator, sync bool, root int64) *Tree {
+	return &Tree{pages: pages, nodeSize: nodeSize, layout: layout, alloc: alloc, sync: sync, root: root}
+}
+
+// Root - смещение корня, меняется при расщеплении корня и должно сохраняться вызывающим
+func (t *Tree) Root() int64 {
+	return t.root
+}
+
+// Insert - добавляет ключ, повторное добавление ничего не меняет
+func (t *Tree) Insert(key string) error {
+	splitKey, right, err := t.insert(t.root, key)
+	if err != nil {
+		return fmt.Errorf("index insert: %w", err)
+	}
+
+	if right != 0 { // корень расщепился - дерево растет вверх
+		root, err := t.newNode(false)
+		if err != nil {
+			return fmt.Errorf("index insert: %w", err)
+		}
+		root.keys = []string{splitKey}
+		root.children = []int64{t.root, right}
+		if err = t.writeNode(root); err != nil {
+			return fmt.Errorf("index insert: %w", err)
+		}
+		t.root = root.offset
+	}
+
+	return t.flush()
+}
+
+// InsertNodes - количество узлов, которые выделит Insert(key): по одному на каждое расщепление и еще один,
+// если расщепится корень. Позволяет проверить, что места хватит, до того как дерево начнет меняться.
+func (t *Tree) InsertNodes(key string) (int, error) {
+	n, err := t.readNode(t.root)
+	if err != nil {
+		return 0, fmt.Errorf("index insert nodes: %w", err)
+	}
+
+	var path []*node
+	for !n.leaf {
+		path = append(path, n)
+		if n, err = t.readNode(n.children[childIndex(n.keys, key)]); err != nil {
+			return 0, fmt.Errorf("index insert nodes: %w", err)
+		}
+	}
+
+	i := sort.SearchStrings(n.keys, key)
+	if (i < len(n.keys) && n.keys[i] == key) || len(n.keys) < t.leafCapacity() {
+		return 0, nil
+	}
+
+	nodes := 1
+	for j := len(path) - 1; j >= 0; j-- { // расщепление поднимается, пока не встретит неполный узел
+		if len(path[j].keys) < t.internalCapacity() {
+			return nodes, nil
+		}
+		nodes++
+	}
+
+	return nodes + 1, nil
+}
+
+// Delete - удаляет ключ, отсутствие ключа не считается ошибкой
+func (t *Tree) Delete(key string) error {
+	leaf, err := t.findLeaf(key)
+	if err != nil {
+		return fmt.Errorf("index delete: %w", err)
+	}
+
+	i := sort.SearchStrings(leaf.keys, key)
+	if i == len(leaf.keys) || leaf.keys[i] != key {
+		return nil
+	}
+	leaf.keys = append(leaf.keys[:i], leaf.keys[i+1:]...)
+
+	if err = t.writeNode(leaf); err != nil {
+		return fmt.Errorf("index delete: %w", err)
+	}
+
+	return t.flush()
+}
+
+// Scan - обходит ключи не меньше start в порядке возрастания, пока fn возвращает true
+func (t *Tree) Scan(start string, fn func(key string) bool) error {
+	leaf, err := t.findLeaf(start)
+	if err != nil {
+		return fmt.Errorf("index scan: %w", err)
+	}
+
+	i := sort.SearchStrings(leaf.keys, start)
+	for {
+		for ; i < len(leaf.keys); i++ {
+			if !fn(leaf.keys[i]) {
+				return nil
+			}
+		}
+
+		if leaf.next == 0 {
+			return nil
+		}
+		if leaf, err = t.readNode(leaf.next); err != nil {
+			return fmt.Errorf("index scan: %w", err)
+		}
+		i = 0
+	}
+}
+
+// Функция рекурсивной вставки. Если узел расщепился, возвращает первый ключ и смещение правой половины.
+func (t *Tree) insert(offset int64, key string) (string, int64, error) {
+	n, err := t.readNode(offset)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if n.leaf {
+		i := sort.SearchStrings(n.keys, key)
+		if i < len(n.keys) && n.keys[i] == key {
+			return "", 0, nil
+		}
+		n.keys = insertAt(n.keys, i, key)
+
+		if len(n.keys) <= t.leafCapacity() {
+			return "", 0, t.writeNode(n)
+		}
+		return t.splitLeaf(n)
+	}
+
+	i := childIndex(n.keys, key)
+	splitKey, right, err := t.insert(n.children[i], key)
+	if err != nil || right == 0 {
+		return "", 0, err
+	}
+
+	n.keys = insertAt(n.keys, i, splitKey)
+	n.children = insertAt(n.children, i+1, right)
+
+	if len(n.keys) <= t.internalCapacity() {
+		return "", 0, t.writeNode(n)
+	}
+	return t.splitInternal(n)
+}
+
+// Функция расщепления переполненного листа пополам
+func (t *Tree) splitLeaf(n *node) (string, int64, error) {
+	right, err := t.newNode(true)
+	if err != nil {
+		return "", 0, err
+	}
+
+	mid := len(n.keys) / 2
+	right.keys = append([]string(nil), n.keys[mid:]...)
+	n.keys = n.keys[:mid]
+	right.next, n.next = n.next, right.offset // правый лист встает в список сразу за левым
+
+	if err = t.writeNode(right); err != nil {
+		return "", 0, err
+	}
+	if err = t.writeNode(n); err != nil {
+		return "", 0, err
+	}
+
+	return right.keys[0], right.offset, nil
+}
+
+// Функция расщепления переполненного внутреннего узла, средний ключ поднимается в родителя
+func (t *Tree) splitInternal(n *node) (string, int64, error) {
+	right, err := t.newNode(false)
+	if err != nil {
+		return "", 0, err
+	}
+
+	mid := len(n.keys) / 2
+	splitKey := n.keys[mid]
+	right.keys = append([]string(nil), n.keys[mid+1:]...)
+	right.children = append([]int64(nil), n.children[mid+1:]...)
+	n.keys = n.keys[:mid]
+	n.children = n.children[:mid+1]
+
+	if err = t.writeNode(right); err != nil {
+		return "", 0, err
+	}
+	if err = t.writeNode(n); err != nil {
+		return "", 0, err
+	}
+
+	return splitKey, right.offset, nil
+}
+
+// Функция поиска листа, в котором должен находиться ключ
+func (t *Tree) findLeaf(key string) (*node, error) {
+	n, err := t.readNode(t.root)
+	if err != nil {
+		return nil, err
+	}
+
+	for !n.leaf {
+		if n, err = t.readNode(n.children[childIndex(n.keys, key)]); err != nil {
+			return nil, err
+		}
+	}
+
+	return n, nil
+}
+
+// Функция выделения места под новый узел
+func (t *Tree) newNode(leaf bool) (*node, error) {
+	offset, err := t.alloc()
+	if err != nil {
+		return nil, err
+	}
+
+	return &node{offset: offset, leaf: leaf}, nil
+}
+
+// Функция чтения узла
+func (t *Tree) readNode(offset int64) (*node, error) {
+	data := make([]byte, t.nodeSize)
+	if _, err := t.pages.ReadAt(data, offset); err != nil {
+		return nil, fmt.Errorf("read node - read at: %w", err)
+	}
+	if data[bkt.KindOffset] != bkt.KindIndex {
+		return nil, fmt.Errorf("read node at %d: %w", offset, ErrNotIndexNode)
+	}
+
+	n := &node{
+		offset: offset,
+		leaf:   data[2] == 1,
+		keys:   make([]string, binary.LittleEndian.Uint16(data[0:2])),
+		next:   int64(binary.LittleEndian.Uint64(data[8:16])),
+	}
+
+	pos := headerLen
+	if !n.leaf {
+		n.children = make([]int64, 0, len(n.keys)+1)
+		n.children = append(n.children, int64(binary.LittleEndian.Uint64(data[pos:])))
+		pos += childLen
+	}
+
+	for i := range n.keys {
+		key, err := t.layout.UnmarshalKey(data[pos:])
+		if err != nil {
+			return nil, fmt.Errorf("read node at %d: %w", offset, err)
+		}
+		n.keys[i] = key
+		pos += t.layout.KeyLen()
+
+		if !n.leaf {
+			n.children = append(n.children, int64(binary.LittleEndian.Uint64(data[pos:])))
+			pos += childLen
+		}
+	}
+
+	return n, nil
+}
+
+// Функция записи узла. Внутренний узел: [ребенок 0][ключ 1][ребенок 1]...; лист: [ключ 1][ключ 2]...
+func (t *Tree) writeNode(n *node) error {
+	data := make([]byte, t.nodeSize)
+	binary.LittleEndian.PutUint16(data[0:2], uint16(len(n.keys)))
+	if n.leaf {
+		data[2] = 1
+	}
+	data[bkt.KindOffset] = bkt.KindIndex
+	binary.LittleEndian.PutUint64(data[8:16], uint64(n.next))
+
+	pos := headerLen
+	if !n.leaf {
+		binary.LittleEndian.PutUint64(data[pos:], uint64(n.children[0]))
+		pos += childLen
+	}
+
+	for i, key := range n.keys {
+		keyData, err := t.layout.MarshalKey(key)
+		if err != nil {
+			return fmt.Errorf("write node: %w", err)
+		}
+		copy(data[pos:], keyData)
+		pos += t.layout.KeyLen()
+
+		if !n.leaf {
+			binary.LittleEndian.PutUint64(data[pos:], uint64(n.children[i+1]))
+			pos += childLen
+		}
+	}
+
+	if _, err := t.pages.WriteAt(data, n.offset); err != nil {
+		return fmt.Errorf("write node - write at: %w", err)
+	}
+
+	return nil
+}
+
+// Функция сброса изменений на диск, если этого требует политика хранилища
+func (t *Tree) flush() error {
+	if !t.sync {
+		return nil
+	}
+
+	return t.pages.Sync()
+}
+
+// Максимальное количество ключей в листе
+func (t *Tree) leafCapacity() int {
+	return (t.nodeSize - headerLen) / t.layout.KeyLen()
+}
+
+// Максимальное количество ключей во внутреннем узле
+func (t *Tree) internalCapacity() int {
+	return (t.nodeSize - headerLen - childLen) / (t.layout.KeyLen() + childLen)
+}
+
+// Номер ребенка, в поддереве которого находится key: количество ключей узла, не больших key
+func childIndex(keys []string, key string) int {
+	return sort.Search(len(keys), func(i int) bool { return keys[i] > key })
+}
+
+// Функция вставки элемента в слайс на позицию i
+func insertAt[T any](items []T, i int, item T) []T {
+	var zero T
+	items = append(items, zero)
+	copy(items[i+1:], items[i:])
+	items[i] = item
+
+	return items
+}
@@ -0,0 +1,82 @@
+package btree
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"debildb/internal/pagestore"
+	"debildb/internal/parser"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Функция тестирования вставки, удаления и упорядоченного обхода с расщеплением узлов
+func TestTree(t *testing.T) {
+	const nodeSize = 256 // маленькие узлы, что бы дерево выросло на несколько уровней
+
+	pages := pagestore.NewMemory()
+	var end int64
+	alloc := func() (int64, error) {
+		off := end
+		end += nodeSize
+		return off, nil
+	}
+	layout := parser.Layout{MaxKeySize: 16, MaxValueSize: 16}
+
+	tree, err := Create(pages, nodeSize, layout, alloc, false)
+	require.NoError(t, err)
+
+	keys := make([]string, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, fmt.Sprintf("key-%04d", i))
+	}
+	rand.New(rand.NewSource(1)).Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+
+	for _, key := range keys {
+		nodes, err := tree.InsertNodes(key)
+		require.NoError(t, err)
+		before := end
+		require.NoError(t, tree.Insert(key))
+		require.Equal(t, int64(nodes)*nodeSize, end-before, key) // InsertNodes заранее знает, сколько узлов выделится
+	}
+	nodes, err := tree.InsertNodes(keys[0])
+	require.NoError(t, err)
+	require.Zero(t, nodes)
+	require.NoError(t, tree.Insert(keys[0])) // повторная вставка ничего не меняет
+
+	for i := 0; i < 1000; i += 3 {
+		require.NoError(t, tree.Delete(fmt.Sprintf("key-%04d", i)))
+	}
+	require.NoError(t, tree.Delete("missing"))
+
+	var want []string
+	for i := 0; i < 1000; i++ {
+		if i%3 != 0 {
+			want = append(want, fmt.Sprintf("key-%04d", i))
+		}
+	}
+
+	reopened := Open(pages, nodeSize, layout, alloc, false, tree.Root())
+	var got []string
+	require.NoError(t, reopened.Scan("", func(key string) bool {
+		got = append(got, key)
+		return true
+	}))
+	require.Equal(t, want, got)
+
+	got = got[:0]
+	require.NoError(t, reopened.Scan("key-0500", func(key string) bool { // обход с середины и остановка
+		if len(got) == 5 {
+			return false
+		}
+		got = append(got, key)
+		return true
+	}))
+	require.Equal(t, []string{"key-0500", "key-0502", "key-0503", "key-0505", "key-0506"}, got)
+	require.True(t, sort.StringsAreSorted(got))
+
+	_, err = Create(pages, 32, layout, alloc, false)
+	require.ErrorIs(t, err, ErrNodeTooSmall)
+}
@@ -1,29 +1,115 @@
 package bucket
 
 import (
+	"debildb/internal/pagestore"
 	"debildb/internal/parser"
+	"encoding/binary"
 	"errors"
 	"fmt"
-	"log"
+	"hash/fnv"
+	"math"
 	"os"
+)
 
-	"github.com/edsrzf/mmap-go"
+const (
+	// Заголовок бакета: [0:2] количество записей, [2] локальная глубина, [3] тип страницы, [4:8] биты хэша, общие для ключей бакета.
+	// Если включены отпечатки, за заголовком лежит по одному байту отпечатка ключа на каждое место под запись.
+	headerLen = 8
+
+	KindOffset = 3 // смещение байта с типом страницы, общее для всех страниц после заголовка бд
+
+	// DefaultMaxValueSize - максимальный размер значения по умолчанию. На 4 байта меньше parser.DefaultMaxValueSize:
+	// в формате без заголовка бакета страница 4096 байт держала 3 записи по 1365 байт и байт счетчика,
+	// заголовок и отпечатки ключей занимают еще 11 байт, и с прежним размером записи помещалось бы только 2.
+	// Несовместимое изменение: новые бд по умолчанию не принимают значения длиной 1232-1235 байт
+	// (parser.ErrValueTooLong). Бд, созданные раньше, хранят размер в заголовке и его не меняют,
+	// а прежний предел для новой бд задается явно через MaxValueSize = parser.DefaultMaxValueSize ценой 2 записей в бакете.
+	DefaultMaxValueSize = parser.DefaultMaxValueSize - 4
 )
 
+// Типы страниц в файле бд
 const (
-	pageSize = 4096
+	KindBucket   byte = iota // бакет основного пространства ключей
+	KindIndex                // узел упорядоченного индекса
+	KindKeyspace             // бакет именованного пространства ключей с наименьшим id, id следующих пространств идут подряд
 
-	lenKV = 1365
+	KindBucketAlt byte = 0xfe // бакет основного пространства ключей: массовая загрузка чередует KindBucket и KindBucketAlt
+	KindFree      byte = 0xff // освобожденная страница, может быть занята заново
 )
 
 var (
-	ErrBucketIsFull = errors.New("bucket is full, need resize") 
-	ErrKeyNotFound  = errors.New("key not found")
+	ErrBucketIsFull    = errors.New("bucket is full, need resize")
+	ErrKeyNotFound     = errors.New("key not found")
+	ErrInvalidGeometry = errors.New("invalid bucket geometry")
+	ErrNotBucket       = errors.New("page is not a bucket")
 )
 
+// Geometry - геометрия бакета: размер страницы, количество страниц на бакет и раскладка записи.
+// Единый источник правды о размерах для пакетов bucket и store.
+type Geometry struct {
+	PageSize int           // размер страницы, должен быть кратен системному размеру страницы (требование mmap)
+	Pages    int           // количество страниц в одном бакете
+	Layout   parser.Layout // размеры ключа и значения
+
+	// Fingerprints - хранить в заголовке бакета отпечатки ключей.
+	// Поиск сравнивает отпечатки и декодирует только записи с совпавшим отпечатком,
+	// поэтому промах почти никогда не требует разбора записей.
+	Fingerprints bool
+}
+
+// DefaultGeometry - геометрия по умолчанию: бакет размером в одну системную страницу с отпечатками ключей,
+// как у бд текущего формата
+func DefaultGeometry() Geometry {
+	return Geometry{
+		PageSize:     os.Getpagesize(),
+		Pages:        1,
+		Layout:       parser.Layout{MaxKeySize: parser.DefaultMaxKeySize, MaxValueSize: DefaultMaxValueSize},
+		Fingerprints: true,
+	}
+}
+
+// Size - размер бакета в байтах
+func (g Geometry) Size() int {
+	return g.PageSize * g.Pages
+}
+
+// Capacity - максимальное количество записей в бакете
+func (g Geometry) Capacity() int {
+	if g.Fingerprints { // каждая запись занимает еще байт отпечатка
+		return (g.Size() - headerLen) / (g.Layout.RecordLen() + 1)
+	}
+	return (g.Size() - headerLen) / g.Layout.RecordLen()
+}
+
+// Validate - проверка что геометрия корректна и хотя бы одна запись помещается в бакет
+func (g Geometry) Validate() error {
+	switch {
+	case g.PageSize <= 0 || g.PageSize%os.Getpagesize() != 0:
+		return fmt.Errorf("%w: page size %d is not a multiple of %d", ErrInvalidGeometry, g.PageSize, os.Getpagesize())
+	case g.Pages <= 0:
+		return fmt.Errorf("%w: bucket pages %d", ErrInvalidGeometry, g.Pages)
+	case g.Layout.MaxKeySize <= 0 || g.Layout.MaxValueSize < 0:
+		return fmt.Errorf("%w: key size %d, value size %d", ErrInvalidGeometry, g.Layout.MaxKeySize, g.Layout.MaxValueSize)
+	case g.Capacity() < 1:
+		return fmt.Errorf("%w: record of %d bytes does not fit into bucket of %d bytes", ErrInvalidGeometry, g.Layout.RecordLen(), g.Size())
+	case g.Capacity() > math.MaxUint16:
+		return fmt.Errorf("%w: bucket capacity %d", ErrInvalidGeometry, g.Capacity())
+	}
+
+	return nil
+}
+
+// Options - параметры, с которыми работают бакеты хранилища
+type Options struct {
+	Geometry
+	Sync bool // сбрасывать страницы на диск после каждого изменения бакета
+	Kind byte // тип страниц бакетов: KindBucket или id именованного пространства ключей
+}
+
 type Bucket struct {
 	offset int
-	pathDB string
+	pages  pagestore.PageStore
+	opts   Options
 }
 
 type KV struct {
@@ -31,26 +117,84 @@ type KV struct {
 	Val string
 }
 
+// Page - содержимое бакета, которое записывается на диск целиком
+type Page struct {
+	Records    []KV
+	LocalDepth int
+	Pattern    int
+}
+
 // Функция создания бакета.
-func CreateBucket(pathToDB string, endOffset int) (*Bucket, int, error) {
-	file, err := os.OpenFile(pathToDB, os.O_RDWR|os.O_CREATE, 0755) // открытие файла
-	if err != nil {
-		log.Fatalf("CreateBucket open file: %s", err.Error())
+// localDepth и pattern сохраняются в заголовке бакета, что бы при открытии бд можно было восстановить список директорий.
+func CreateBucket(pages pagestore.PageStore, endOffset int, opts Options, localDepth, pattern int) (*Bucket, int, error) {
+	bucket := &Bucket{
+		offset: endOffset,
+		pages:  pages,
+		opts:   opts,
 	}
-	defer file.Close()
 
-	reserv := make([]byte, os.Getpagesize())
-	_, err = file.WriteAt(reserv, int64(endOffset)) // запись нулевых байтов (резервируем бакет)
-	if err != nil {
-		return nil, -1, fmt.Errorf("create bucket - write at: %w", err)
+	reserv := make([]byte, opts.Size())
+	putMeta(reserv, opts.Kind, localDepth, pattern)
+	if err := bucket.putBucket(reserv); err != nil { // запись нулевых байтов (резервируем бакет)
+		return nil, -1, fmt.Errorf("create bucket: %w", err)
 	}
 
-	newEndOffset := endOffset + pageSize // считаем новый указатель на конец бд
+	newEndOffset := endOffset + opts.Size() // считаем новый указатель на конец бд
+
+	return bucket, newEndOffset, nil
+}
 
+// Функция открытия уже существующего бакета по смещению
+func OpenBucket(pages pagestore.PageStore, offset int, opts Options) *Bucket {
 	return &Bucket{
-		offset: endOffset,
-		pathDB: pathToDB,
-	}, newEndOffset, nil
+		offset: offset,
+		pages:  pages,
+		opts:   opts,
+	}
+}
+
+// Функция создания сразу нескольких заполненных бакетов начиная с endOffset.
+// Каждая страница формируется в памяти и записывается на диск один раз, используется при массовой загрузке.
+func WriteBuckets(pages pagestore.PageStore, endOffset int, opts Options, content []Page) ([]*Bucket, int, error) {
+	buckets := make([]*Bucket, 0, len(content))
+	data := make([]byte, opts.Size())
+	for _, page := range content {
+		if len(page.Records) > opts.Capacity() { // проверяем что записи помещаются в один бакет
+			return nil, -1, fmt.Errorf("write buckets: %w", ErrBucketIsFull)
+		}
+
+		clear(data)
+		putMeta(data, opts.Kind, page.LocalDepth, page.Pattern)
+		setCount(data, len(page.Records)) // количество элементов в бакете
+		for i, kv := range page.Records {
+			opts.setFingerprint(data, i, kv.Key)
+			kvData, err := opts.Layout.Marshal(kv.Key, kv.Val) // маршалим запись
+			if err != nil {
+				return nil, -1, fmt.Errorf("write buckets: %w", err)
+			}
+			offset := opts.recordOffset(i)
+			copy(data[offset:], kvData)
+		}
+
+		if _, err := pages.WriteAt(data, int64(endOffset)); err != nil { // пишем страницу целиком
+			return nil, -1, fmt.Errorf("write buckets - write at: %w", err)
+		}
+
+		buckets = append(buckets, &Bucket{
+			offset: endOffset,
+			pages:  pages,
+			opts:   opts,
+		})
+		endOffset += opts.Size() // сдвигаем указатель на конец бд
+	}
+
+	if opts.Sync {
+		if err := pages.Sync(); err != nil { // один раз сбрасываем все страницы на диск
+			return nil, -1, fmt.Errorf("write buckets - sync: %w", err)
+		}
+	}
+
+	return buckets, endOffset, nil
 }
 
 // Функция получения значения из бакета по ключу
@@ -60,11 +204,15 @@ func (b *Bucket) GetValue(key string) (*KV, error) {
 		return nil, fmt.Errorf("error bucket Get Value: %w", err)
 	}
 
-	for i := 0; i < int(bktData[0]); i++ {	// начинаем итерироваться по бакту. Первый байт бакета содержит количество элементов в бакете на данный момент
-		offset := 1 + i*lenKV				// считаем смещение
-		curKV := bktData[offset : offset+lenKV] // получаем текущий слайс бакт относящийся к нужной записи
+	fp := fingerprint(key)
+	for i := 0; i < getCount(bktData); i++ {	// начинаем итерироваться по бакту. Заголовок бакета содержит количество элементов в бакете на данный момент
+		if !b.opts.mayContain(bktData, i, fp) { // отпечаток не совпал - запись точно с другим ключом
+			continue
+		}
+
+		curKV := b.opts.record(bktData, i) // получаем текущий слайс бакт относящийся к нужной записи
 
-		curKey, curVal, err := parser.UnmarshalKV(curKV) // анмаршалим его
+		curKey, curVal, err := b.opts.Layout.Unmarshal(curKV) // анмаршалим его
 		if err != nil {
 			return nil, fmt.Errorf("error bucket Get Value: %w", err)
 		}
@@ -80,23 +228,40 @@ func (b *Bucket) GetValue(key string) (*KV, error) {
 	return nil, fmt.Errorf("error bucket Get Value: %w", ErrKeyNotFound)
 }
 
-// Функция на загрузку значения в бакет
+// Функция на загрузку значения в бакет.
+// Если ключ уже есть в бакете, его запись перезаписывается на месте и счетчик записей не меняется,
+// поэтому в бакете нет дубликатов ключа и счетчик равен количеству разных ключей.
+// ErrBucketIsFull возвращается только для нового ключа: перезапись в заполненном бакете сплита не требует.
 func (b *Bucket) PutValue(kv *KV) error {
 	bktData, err := b.getBucket()	// Получаем бакет
 	if err != nil {
 		return fmt.Errorf("error bucket Put Value: %w", err)
 	}
 
-	if bktData[0] > 2 {	// Проверяем что в бакете есть место
-		return ErrBucketIsFull
+	kvData, err := b.opts.Layout.Marshal(kv.Key, kv.Val)	// маршалим запись
+	if err != nil {
+		return fmt.Errorf("error bucket Put Value: %w", err)
 	}
 
-	kvData, err := parser.MarshalKV(kv.Key, kv.Val)	// маршалим запись
+	index, err := b.findKey(bktData, kv.Key) // если ключ уже есть в бакете - перезаписываем его запись
 	if err != nil {
 		return fmt.Errorf("error bucket Put Value: %w", err)
 	}
 
-	err = b.setBucket(int(bktData[0]), kvData) // кладем в бакет
+	if index == -1 {
+		if getCount(bktData) >= b.opts.Capacity() {	// Проверяем что в бакете есть место
+			return ErrBucketIsFull
+		}
+		index = getCount(bktData)
+	}
+
+	copy(b.opts.record(bktData, index), kvData) // кладем в бакет
+	b.opts.setFingerprint(bktData, index, kv.Key)
+	if index == getCount(bktData) {
+		setCount(bktData, index+1) // увеличиваем счетчик количества элементов в бакете
+	}
+
+	err = b.putBucket(bktData)
 	if err != nil {
 		return fmt.Errorf("error bucket Put Value: %w", err)
 	}
@@ -104,6 +269,35 @@ func (b *Bucket) PutValue(kv *KV) error {
 	return nil
 }
 
+// Функция удаления значения из бакета по ключу.
+// На место удаляемой записи переносится последняя запись бакета.
+func (b *Bucket) DeleteValue(key string) error {
+	bktData, err := b.getBucket() // Получаем бакет
+	if err != nil {
+		return fmt.Errorf("error bucket Delete Value: %w", err)
+	}
+
+	index, err := b.findKey(bktData, key)
+	if err != nil {
+		return fmt.Errorf("error bucket Delete Value: %w", err)
+	}
+	if index == -1 {
+		return fmt.Errorf("error bucket Delete Value: %w", ErrKeyNotFound)
+	}
+
+	last := getCount(bktData) - 1
+	copy(b.opts.record(bktData, index), b.opts.record(bktData, last)) // переносим последнюю запись на место удаляемой
+	clear(b.opts.record(bktData, last))
+	b.opts.moveFingerprint(bktData, index, last)
+	setCount(bktData, last)
+
+	if err = b.putBucket(bktData); err != nil {
+		return fmt.Errorf("error bucket Delete Value: %w", err)
+	}
+
+	return nil
+}
+
 // Функция получения всех значений внутри бакета. Используется при сплите бакета, когда нужно перераспределить значнеия между двумя бакетами после разделения.
 func (b *Bucket) GetBucketValues() ([]KV, error) {
 	bktData, err := b.getBucket() // Получаем бакет
@@ -111,12 +305,11 @@ func (b *Bucket) GetBucketValues() ([]KV, error) {
 		return nil, fmt.Errorf("error bucket Get Bucket Values: %w", err)
 	}
 
-	result := make([]KV, 0, int(bktData[0]))
-	for i := 0; i < int(bktData[0]); i++ { // начинаем итерироваться по каждому элементу
-		offset := 1 + i*lenKV // считаем смещение
-		curKV := bktData[offset : offset+lenKV] // достаем слайс байт опередленной записи
+	result := make([]KV, 0, getCount(bktData))
+	for i := 0; i < getCount(bktData); i++ { // начинаем итерироваться по каждому элементу
+		curKV := b.opts.record(bktData, i) // достаем слайс байт опередленной записи
 
-		curKey, curVal, err := parser.UnmarshalKV(curKV) // анмаршаллим запись
+		curKey, curVal, err := b.opts.Layout.Unmarshal(curKV) // анмаршаллим запись
 		if err != nil {
 			return nil, fmt.Errorf("error bucket Get Value: %w", err)
 		}
@@ -128,83 +321,161 @@ func (b *Bucket) GetBucketValues() ([]KV, error) {
 }
 
 // Функция обнуления бакета (нужно при сплите бакета), когда после того как достали элементы нужно его почистить.
-func (b *Bucket) SetBucketIsEmpty() error {
-	db, err := os.OpenFile(b.pathDB, os.O_RDWR|os.O_CREATE, 0755)	// Открываем файл
-	if err != nil {
-		return fmt.Errorf("get bucket - open file: %w", err)
+// В заголовок записываются новые локальная глубина и биты хэша бакета.
+func (b *Bucket) SetBucketIsEmpty(localDepth, pattern int) error {
+	data := make([]byte, b.opts.Size()) // заполняем слайс байт нулевыми байтами
+	putMeta(data, b.opts.Kind, localDepth, pattern)
+
+	if err := b.putBucket(data); err != nil {
+		return fmt.Errorf("set bucket is empty: %w", err)
 	}
-	defer db.Close()
 
-	data, err := mmap.MapRegion(db, pageSize, mmap.RDWR, 0, int64(b.offset)) // мапим нужную страницу (страницу - потому что размер бакета равен размеру страницы)
-	if err != nil {
-		return fmt.Errorf("create bucket - map region: %w", err)
+	return nil
+}
+
+// Функция получения байт бакета
+func (b *Bucket) getBucket() ([]byte, error) {
+	data := make([]byte, b.opts.Size())
+	if _, err := b.pages.ReadAt(data, int64(b.offset)); err != nil { // читаем страницы бакета к себе в буфер
+		return nil, fmt.Errorf("get bucket - read at: %w", err)
 	}
 
-	copy(data, make([]byte, 4096))	// заполняем слайс байт нулевыми байтами
+	return data, nil
+}
+
+// Функция поиска номера записи с ключом key, -1 если ключа в бакете нет
+func (b *Bucket) findKey(bktData []byte, key string) (int, error) {
+	fp := fingerprint(key)
+	for i := 0; i < getCount(bktData); i++ {
+		if !b.opts.mayContain(bktData, i, fp) {
+			continue
+		}
+		curKey, _, err := b.opts.Layout.Unmarshal(b.opts.record(bktData, i))
+		if err != nil {
+			return -1, err
+		}
+		if curKey == key {
+			return i, nil
+		}
+	}
 
-	if err = data.Flush(); err != nil { // флашим все на диск
-		return fmt.Errorf("create bucket - flush: %w", err)
+	return -1, nil
+}
+
+// Функция сохранения бакета на диск (обновленного)
+func (b *Bucket) putBucket(data []byte) error {
+	if _, err := b.pages.WriteAt(data, int64(b.offset)); err != nil { // пишем страницы бакета целиком
+		return fmt.Errorf("put bucket - write at: %w", err)
 	}
 
-	if err := data.Unmap(); err != nil { // размапливаем страницу
-		log.Fatalf("CreateBucket Map: %s", err.Error())
+	if b.opts.Sync {
+		if err := b.pages.Sync(); err != nil { // флашим данные на диск
+			return fmt.Errorf("put bucket - sync: %w", err)
+		}
 	}
 
 	return nil
 }
 
-// Функция получения байт бакета
-func (b *Bucket) getBucket() ([]byte, error) {
-	db, err := os.OpenFile(b.pathDB, os.O_RDWR|os.O_CREATE, 0755) // Открываем файл
+// Функция получения смещения бакета в файле бд
+func (b *Bucket) Offset() int {
+	return b.offset
+}
+
+// Функция расчета бакет ID (по факту индекс бакета)
+func (b *Bucket) GetBucketID() int {
+	return b.offset / b.opts.Size()
+}
+
+// Функция чтения локальной глубины и битов хэша бакета из его заголовка
+func (b *Bucket) GetMeta() (int, int, error) {
+	bktData, err := b.getBucket()
 	if err != nil {
-		return nil, fmt.Errorf("get bucket - open file: %w", err)
+		return 0, 0, fmt.Errorf("error bucket Get Meta: %w", err)
 	}
-	defer db.Close()
 
-	data, err := mmap.MapRegion(db, os.Getpagesize(), mmap.RDWR, 0, int64(b.offset)) // мапим нужную страницу (страницу - потому что размер бакета равен размеру страницы)
-	if err != nil {
-		return nil, fmt.Errorf("get bucket - map region: %w", err)
+	if bktData[KindOffset] != b.opts.Kind { // страница занята другой структурой
+		return 0, 0, fmt.Errorf("error bucket Get Meta: %w", ErrNotBucket)
 	}
 
-	resultData := make([]byte, len(data))
-	copy(resultData, data) // копируем данные к себе в буфер
+	return int(bktData[2]), int(binary.LittleEndian.Uint32(bktData[4:8])), nil
+}
 
-	if err := data.Unmap(); err != nil { // размапливаем страницу
-		return nil, fmt.Errorf("get bucket - unmap: %w", err)
+// Смещение записи с номером index внутри бакета
+func (g Geometry) recordOffset(index int) int {
+	offset := headerLen + index*g.Layout.RecordLen()
+	if g.Fingerprints { // записи идут после массива отпечатков
+		offset += g.Capacity()
 	}
+	return offset
+}
 
-	return resultData, nil
+// Функция вычисления отпечатка ключа
+func fingerprint(key string) byte {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	sum := h.Sum32()
+	return byte(sum ^ sum>>8 ^ sum>>16 ^ sum>>24)
 }
 
-// Функция сохранения бакета на диск (обновленного)
-func (b *Bucket) setBucket(index int, dataKV []byte) error {
-	db, err := os.OpenFile(b.pathDB, os.O_RDWR|os.O_CREATE, 0755) // открываем файл
-	if err != nil {
-		return fmt.Errorf("get bucket - open file: %w", err)
+// Функция проверки, может ли запись с номером index иметь ключ с отпечатком fp. Без отпечатков проверяется каждая запись.
+func (g Geometry) mayContain(data []byte, index int, fp byte) bool {
+	return !g.Fingerprints || data[headerLen+index] == fp
+}
+
+// Функция записи отпечатка ключа записи с номером index
+func (g Geometry) setFingerprint(data []byte, index int, key string) {
+	if g.Fingerprints {
+		data[headerLen+index] = fingerprint(key)
 	}
-	defer db.Close()
+}
 
-	data, err := mmap.MapRegion(db, pageSize, mmap.RDWR, 0, int64(b.offset)) // мапим нужную страницу (страницу - потому что размер бакета равен размеру страницы)
-	if err != nil {
-		return fmt.Errorf("create bucket - map region: %w", err)
+// Функция переноса отпечатка последней записи last на место записи index
+func (g Geometry) moveFingerprint(data []byte, index, last int) {
+	if g.Fingerprints {
+		data[headerLen+index] = data[headerLen+last]
+		data[headerLen+last] = 0
 	}
+}
 
-	offset := 1 + index*lenKV // считаем смещение для обновления определенного элемента
-	copy(data[offset:offset+lenKV], dataKV) // переносим значения
-	data[0] = data[0] + 1 // увеличиваем счетчик количества элементов в бакете
+// Слайс байт записи с номером index
+func (g Geometry) record(data []byte, index int) []byte {
+	offset := g.recordOffset(index)
+	return data[offset : offset+g.Layout.RecordLen()]
+}
 
-	if err = data.Flush(); err != nil { // флашим данные на диск
-		return fmt.Errorf("create bucket - flush: %w", err)
-	}
+// Функция чтения количества записей (разных ключей) из заголовка бакета
+func getCount(data []byte) int {
+	return int(binary.LittleEndian.Uint16(data[0:2]))
+}
+
+// Функция записи количества записей в заголовок бакета
+func setCount(data []byte, count int) {
+	binary.LittleEndian.PutUint16(data[0:2], uint16(count))
+}
+
+// Функция записи типа страницы, локальной глубины и битов хэша в заголовок бакета
+func putMeta(data []byte, kind byte, localDepth, pattern int) {
+	data[2] = byte(localDepth)
+	data[KindOffset] = kind
+	binary.LittleEndian.PutUint32(data[4:8], uint32(pattern))
+}
 
-	if err := data.Unmap(); err != nil { // размапливаем память
-		log.Fatalf("CreateBucket Map: %s", err.Error())
+// PageKind - тип страницы по смещению offset
+func PageKind(pages pagestore.PageStore, offset int) (byte, error) {
+	var kind [1]byte
+	if _, err := pages.ReadAt(kind[:], int64(offset+KindOffset)); err != nil {
+		return 0, fmt.Errorf("page kind - read at: %w", err)
 	}
 
-	return nil
+	return kind[0], nil
 }
 
-// Функция расчета бакет ID (по факту индекс бакета)
-func (b *Bucket) GetBucketID() int {
-	return b.offset / pageSize
+// FreePage - помечает страницу по смещению offset как освобожденную
+func FreePage(pages pagestore.PageStore, offset int) error {
+	if _, err := pages.WriteAt([]byte{KindFree}, int64(offset+KindOffset)); err != nil {
+		return fmt.Errorf("free page - write at: %w", err)
+	}
+
+	return nil
 }
\ No newline at end of file
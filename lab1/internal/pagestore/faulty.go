@@ -0,0 +1,261 @@
+package pagestore
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"syscall"
+)
+
+var (
+	ErrCrashed = errors.New("page store crashed")
+	ErrNoSpace = syscall.ENOSPC // ошибка переполнения диска, совпадает с системной
+)
+
+// Faulty - обертка над хранилищем страниц для тестирования устойчивости к сбоям.
+// Умеет имитировать короткие записи, переполнение диска и падение процесса в выбранный момент.
+// Номера записей считаются с единицы по всем вызовам WriteAt.
+type Faulty struct {
+	mu    sync.Mutex
+	inner PageStore
+
+	writes int // количество вызовов WriteAt
+
+	failFrom int   // начиная с этой записи WriteAt возвращает failErr
+	failErr  error // ошибка, например ErrNoSpace
+
+	reserveErr error // ошибка, которую возвращает Reserve
+
+	shortAt int // номер записи, которая будет выполнена частично
+	shortN  int // сколько байт будет записано
+
+	crashAt      int  // номер записи, на которой происходит падение
+	dropUnsynced bool // при падении откатить записи, не сброшенные через Sync
+	crashed      bool
+
+	undo []undoRecord // старое содержимое участков, измененных после последнего Sync
+}
+
+// Старое содержимое участка хранилища
+type undoRecord struct {
+	off  int64
+	data []byte
+	size int64 // размер хранилища до записи
+}
+
+// NewFaulty - оборачивает хранилище страниц, по умолчанию без сбоев
+func NewFaulty(inner PageStore) *Faulty {
+	return &Faulty{inner: inner}
+}
+
+// FailWrites - начиная с записи номер n каждая запись возвращает err, данные не пишутся
+func (f *Faulty) FailWrites(n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.failFrom, f.failErr = n, err
+}
+
+// FailReserve - Reserve будет возвращать err, nil - снова делегировать обернутому хранилищу
+func (f *Faulty) FailReserve(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.reserveErr = err
+}
+
+// ShortWrite - запись номер n запишет только первые size байт и вернет io.ErrShortWrite
+func (f *Faulty) ShortWrite(n, size int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.shortAt, f.shortN = n, size
+}
+
+// CrashAt - на записи номер n хранилище "падает": запись обрывается на середине,
+// а все последующие операции возвращают ErrCrashed.
+// Если dropUnsynced, то записи после последнего Sync откатываются, как при потере питания.
+func (f *Faulty) CrashAt(n int, dropUnsynced bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.crashAt, f.dropUnsynced = n, dropUnsynced
+}
+
+// Writes - количество вызовов WriteAt, удобно для выбора точки сбоя
+func (f *Faulty) Writes() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.writes
+}
+
+// Crashed - произошло ли падение
+func (f *Faulty) Crashed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.crashed
+}
+
+// Inner - обернутое хранилище, после падения в нем остается то, что "пережило" сбой
+func (f *Faulty) Inner() PageStore {
+	return f.inner
+}
+
+// ReadAt - чтение из обернутого хранилища
+func (f *Faulty) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.crashed {
+		return 0, ErrCrashed
+	}
+
+	return f.inner.ReadAt(p, off)
+}
+
+// WriteAt - запись с учетом запланированных сбоев
+func (f *Faulty) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.crashed {
+		return 0, ErrCrashed
+	}
+
+	f.writes++
+	switch {
+	case f.failFrom > 0 && f.writes >= f.failFrom:
+		return 0, f.failErr
+	case f.writes == f.crashAt:
+		if err := f.write(p[:len(p)/2], off); err != nil { // рваная запись
+			return 0, err
+		}
+		f.crash()
+		return 0, ErrCrashed
+	case f.writes == f.shortAt:
+		n := min(f.shortN, len(p))
+		if err := f.write(p[:n], off); err != nil {
+			return 0, err
+		}
+		return n, io.ErrShortWrite
+	}
+
+	if err := f.write(p, off); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Функция записи с сохранением старого содержимого для отката
+func (f *Faulty) write(p []byte, off int64) error {
+	if f.dropUnsynced {
+		size, err := f.inner.Size()
+		if err != nil {
+			return err
+		}
+		old := make([]byte, len(p))
+		if _, err = f.inner.ReadAt(old, off); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		f.undo = append(f.undo, undoRecord{off: off, data: old, size: size})
+	}
+
+	_, err := f.inner.WriteAt(p, off)
+	return err
+}
+
+// Функция падения: откат несброшенных записей в обратном порядке
+func (f *Faulty) crash() {
+	f.crashed = true
+	if !f.dropUnsynced {
+		return
+	}
+
+	for i := len(f.undo) - 1; i >= 0; i-- {
+		rec := f.undo[i]
+		_, _ = f.inner.WriteAt(rec.data, rec.off)
+		_ = f.inner.Truncate(rec.size)
+	}
+	f.undo = nil
+}
+
+// Size - размер обернутого хранилища
+func (f *Faulty) Size() (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.crashed {
+		return 0, ErrCrashed
+	}
+
+	return f.inner.Size()
+}
+
+// Truncate - изменение размера обернутого хранилища
+func (f *Faulty) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.crashed {
+		return ErrCrashed
+	}
+	if f.dropUnsynced { // truncate тоже нужно уметь откатить
+		oldSize, err := f.inner.Size()
+		if err != nil {
+			return err
+		}
+		if size < oldSize {
+			old := make([]byte, oldSize-size)
+			if _, err = f.inner.ReadAt(old, size); err != nil && !errors.Is(err, io.EOF) {
+				return err
+			}
+			f.undo = append(f.undo, undoRecord{off: size, data: old, size: oldSize})
+		} else {
+			f.undo = append(f.undo, undoRecord{off: oldSize, size: oldSize})
+		}
+	}
+
+	return f.inner.Truncate(size)
+}
+
+// Reserve - выделение места в обернутом хранилище, если оно это умеет
+func (f *Faulty) Reserve(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.crashed {
+		return ErrCrashed
+	}
+	if f.reserveErr != nil {
+		return f.reserveErr
+	}
+	if r, ok := f.inner.(Reserver); ok {
+		return r.Reserve(size)
+	}
+
+	return nil
+}
+
+// Sync - сброс обернутого хранилища, после него записи уже не откатываются
+func (f *Faulty) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.crashed {
+		return ErrCrashed
+	}
+	if err := f.inner.Sync(); err != nil {
+		return err
+	}
+	f.undo = nil
+
+	return nil
+}
+
+// Close - закрытие обертки. Обернутое хранилище остается открытым, что бы его можно было проверить после сбоя.
+func (f *Faulty) Close() error {
+	return nil
+}
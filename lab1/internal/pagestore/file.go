@@ -0,0 +1,81 @@
+package pagestore
+
+import (
+	"fmt"
+	"os"
+)
+
+// File - хранилище страниц в файле с доступом через pread/pwrite
+type File struct {
+	file     *os.File
+	readOnly bool
+}
+
+// OpenFile - открывает (или создает) файл для доступа через pread/pwrite
+func OpenFile(path string) (*File, error) {
+	return openFile(path, OpenOptions{})
+}
+
+// Функция открытия файла для доступа через pread/pwrite с блокировкой файла
+func openFile(path string, opts OpenOptions) (*File, error) {
+	file, err := openLocked(path, opts)
+	if err != nil {
+		return nil, fmt.Errorf("open file page store: %w", err)
+	}
+
+	return &File{file: file, readOnly: opts.ReadOnly}, nil
+}
+
+// ReadAt - чтение len(p) байт по смещению off
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	return f.file.ReadAt(p, off)
+}
+
+// WriteAt - запись p по смещению off, файл при необходимости растет
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	if f.readOnly {
+		return 0, ErrReadOnly
+	}
+	return f.file.WriteAt(p, off)
+}
+
+// Size - размер файла
+func (f *File) Size() (int64, error) {
+	info, err := f.file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("file page store - stat: %w", err)
+	}
+	return info.Size(), nil
+}
+
+// Truncate - изменение размера файла
+func (f *File) Truncate(size int64) error {
+	if f.readOnly {
+		return ErrReadOnly
+	}
+	return f.file.Truncate(size)
+}
+
+// Reserve - выделение места на диске под первые size байт файла
+func (f *File) Reserve(size int64) error {
+	if f.readOnly {
+		return ErrReadOnly
+	}
+	if err := reserve(f.file, size); err != nil {
+		return fmt.Errorf("file page store - reserve: %w", err)
+	}
+	return nil
+}
+
+// Sync - fsync файла
+func (f *File) Sync() error {
+	if f.readOnly { // изменений нет
+		return nil
+	}
+	return f.file.Sync()
+}
+
+// Close - закрытие файла, блокировка снимается вместе с ним
+func (f *File) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,99 @@
+package pagestore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	lockRetryInterval = 10 * time.Millisecond
+)
+
+// Функция открытия файла с блокировкой: на запись - исключительной, только на чтение - разделяемой.
+// Блокировка держится, пока файл открыт.
+func openLocked(path string, opts OpenOptions) (*os.File, error) {
+	flag := os.O_RDWR | os.O_CREATE
+	if opts.ReadOnly {
+		flag = os.O_RDONLY
+	}
+
+	return openLockedFlag(path, flag, opts)
+}
+
+// LockFile - открывает существующий файл path и берет на него ту же блокировку, что и файловые хранилища страниц:
+// для ReadOnly разделяемую, иначе исключительную. Нужна, что бы работать с файлом бд в обход хранилища страниц.
+// Блокировка держится, пока файл открыт.
+func LockFile(path string, opts OpenOptions) (*os.File, error) {
+	flag := os.O_RDWR
+	if opts.ReadOnly {
+		flag = os.O_RDONLY
+	}
+
+	return openLockedFlag(path, flag, opts)
+}
+
+// Функция открытия файла с флагами flag и блокировкой.
+// Пока ждали блокировку, держатель мог подменить файл переименованием (так migrate обновляет бд на месте),
+// и тогда блокировка взята на файл, которого по этому пути уже нет: такой файл открывается заново.
+func openLockedFlag(path string, flag int, opts OpenOptions) (*os.File, error) {
+	for {
+		file, err := os.OpenFile(path, flag, 0755)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = lockFile(file, !opts.ReadOnly, opts.LockTimeout); err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		same, err := isFileAt(file, path)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if same {
+			return file, nil
+		}
+		file.Close()
+	}
+}
+
+// Функция проверки, что по пути path лежит открытый файл file
+func isFileAt(file *os.File, path string) (bool, error) {
+	opened, err := file.Stat()
+	if err != nil {
+		return false, fmt.Errorf("lock %s - stat: %w", path, err)
+	}
+	current, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) { // файл удален, при повторе он будет создан или открытие вернет ошибку
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("lock %s - stat: %w", path, err)
+	}
+
+	return os.SameFile(opened, current), nil
+}
+
+// Функция взятия блокировки файла. Пока блокировку держит другой процесс, попытки повторяются до истечения timeout.
+func lockFile(file *os.File, exclusive bool, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLock(file, exclusive)
+		if err != nil {
+			return fmt.Errorf("lock %s: %w", file.Name(), err)
+		}
+		if locked {
+			return nil
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return fmt.Errorf("%w: %s", ErrLocked, file.Name())
+		}
+		time.Sleep(min(wait, lockRetryInterval))
+	}
+}
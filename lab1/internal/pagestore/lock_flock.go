@@ -0,0 +1,25 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package pagestore
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Функция неблокирующей попытки взять flock. false - блокировку держит кто-то другой.
+// flock привязан к открытому файлу, поэтому конфликтуют и два открытия одного файла внутри процесса.
+func tryLock(file *os.File, exclusive bool) (bool, error) {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
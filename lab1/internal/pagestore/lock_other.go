@@ -0,0 +1,12 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package pagestore
+
+import (
+	"os"
+)
+
+// Функция попытки взять блокировку файла. На платформах без flock файл не блокируется.
+func tryLock(_ *os.File, _ bool) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,118 @@
+package pagestore
+
+import (
+	"io"
+	"sync"
+)
+
+// Memory - хранилище страниц в памяти процесса
+type Memory struct {
+	mu     sync.RWMutex
+	data   []byte
+	closed bool
+}
+
+// NewMemory - создает пустое хранилище страниц в памяти
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// ReadAt - чтение len(p) байт по смещению off
+func (m *Memory) ReadAt(p []byte, off int64) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return 0, ErrClosed
+	}
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// WriteAt - запись p по смещению off, хранилище при необходимости растет
+func (m *Memory) WriteAt(p []byte, off int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return 0, ErrClosed
+	}
+	if end := int(off) + len(p); end > len(m.data) {
+		m.grow(end)
+	}
+
+	return copy(m.data[off:], p), nil
+}
+
+// Size - размер хранилища
+func (m *Memory) Size() (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return 0, ErrClosed
+	}
+
+	return int64(len(m.data)), nil
+}
+
+// Truncate - изменение размера хранилища, новые байты заполняются нулями
+func (m *Memory) Truncate(size int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+	if int(size) > len(m.data) {
+		m.grow(int(size))
+		return nil
+	}
+	clear(m.data[size:])
+	m.data = m.data[:size]
+
+	return nil
+}
+
+// Sync - данные в памяти сбрасывать некуда
+func (m *Memory) Sync() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	return nil
+}
+
+// Close - закрытие хранилища, данные теряются
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	m.data = nil
+
+	return nil
+}
+
+// Функция увеличения размера хранилища до size байт
+func (m *Memory) grow(size int) {
+	if size <= cap(m.data) {
+		m.data = m.data[:size]
+		return
+	}
+
+	data := make([]byte, size, max(size, 2*cap(m.data)))
+	copy(data, m.data)
+	m.data = data
+}
@@ -0,0 +1,153 @@
+package pagestore
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/edsrzf/mmap-go"
+)
+
+// Mmap - хранилище страниц в файле с доступом через mmap.
+// Каждая операция мапит только нужный участок файла и сразу его размапливает.
+// В режиме только для чтения участки мапятся с mmap.RDONLY, запись возвращает ErrReadOnly.
+type Mmap struct {
+	file     *os.File
+	readOnly bool
+}
+
+// OpenMmap - открывает (или создает) файл для доступа через mmap
+func OpenMmap(path string) (*Mmap, error) {
+	return openMmap(path, OpenOptions{})
+}
+
+// Функция открытия файла для доступа через mmap с блокировкой файла
+func openMmap(path string, opts OpenOptions) (*Mmap, error) {
+	file, err := openLocked(path, opts)
+	if err != nil {
+		return nil, fmt.Errorf("open mmap page store: %w", err)
+	}
+
+	return &Mmap{file: file, readOnly: opts.ReadOnly}, nil
+}
+
+// ReadAt - чтение len(p) байт по смещению off
+func (m *Mmap) ReadAt(p []byte, off int64) (int, error) {
+	size, err := m.Size()
+	if err != nil {
+		return 0, err
+	}
+
+	n := len(p)
+	if off >= size {
+		return 0, io.EOF
+	}
+	if off+int64(n) > size { // мапить за концом файла нельзя - читаем сколько есть
+		n = int(size - off)
+	}
+
+	err = m.mapRegion(off, n, mmap.RDONLY, func(data []byte) {
+		copy(p, data)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// WriteAt - запись p по смещению off, файл при необходимости растет
+func (m *Mmap) WriteAt(p []byte, off int64) (int, error) {
+	if m.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	size, err := m.Size()
+	if err != nil {
+		return 0, err
+	}
+
+	if end := off + int64(len(p)); end > size { // перед маппингом файл должен покрывать весь участок
+		if err = m.file.Truncate(end); err != nil {
+			return 0, fmt.Errorf("mmap page store - grow: %w", err)
+		}
+	}
+
+	err = m.mapRegion(off, len(p), mmap.RDWR, func(data []byte) {
+		copy(data, p)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Функция маппинга участка [off, off+n) файла. Смещение маппинга выравнивается по системной странице.
+func (m *Mmap) mapRegion(off int64, n int, prot int, fn func(data []byte)) error {
+	if n == 0 {
+		return nil
+	}
+
+	pageSize := int64(os.Getpagesize())
+	aligned := off - off%pageSize // mmap требует смещение кратное размеру страницы
+	shift := int(off - aligned)
+
+	data, err := mmap.MapRegion(m.file, n+shift, prot, 0, aligned)
+	if err != nil {
+		return fmt.Errorf("mmap page store - map region: %w", err)
+	}
+
+	fn(data[shift:])
+
+	if err = data.Unmap(); err != nil { // размапливаем память
+		return fmt.Errorf("mmap page store - unmap: %w", err)
+	}
+
+	return nil
+}
+
+// Size - размер файла
+func (m *Mmap) Size() (int64, error) {
+	info, err := m.file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("mmap page store - stat: %w", err)
+	}
+	return info.Size(), nil
+}
+
+// Truncate - изменение размера файла
+func (m *Mmap) Truncate(size int64) error {
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	return m.file.Truncate(size)
+}
+
+// Reserve - выделение места на диске под первые size байт файла
+func (m *Mmap) Reserve(size int64) error {
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	if err := reserve(m.file, size); err != nil {
+		return fmt.Errorf("mmap page store - reserve: %w", err)
+	}
+	return nil
+}
+
+// Sync - сброс на диск. Участки размапливаются сразу после операции, поэтому используется fsync файла,
+// который сбрасывает и страницы, измененные через mmap.
+func (m *Mmap) Sync() error {
+	if m.readOnly { // изменений нет
+		return nil
+	}
+	return m.file.Sync()
+}
+
+// Close - закрытие файла, блокировка снимается вместе с ним
+func (m *Mmap) Close() error {
+	return m.file.Close()
+}
@@ -0,0 +1,70 @@
+package pagestore
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	ErrClosed   = errors.New("page store is closed")
+	ErrReadOnly = errors.New("page store is read-only")
+	ErrLocked   = errors.New("file is locked by another process")
+)
+
+// PageStore - хранилище страниц, поверх которого работают бакеты.
+// Смещения и размеры не обязаны быть выровнены по страницам, но бакеты всегда читают и пишут их целиком.
+type PageStore interface {
+	io.ReaderAt
+	io.WriterAt
+
+	Size() (int64, error)      // текущий размер хранилища в байтах
+	Truncate(size int64) error // изменение размера хранилища
+	Sync() error               // сброс записанных данных на постоянный носитель
+	Close() error              // освобождение ресурсов
+}
+
+// Reserver - хранилище страниц, которое умеет заранее выделить место на диске.
+// После успешного Reserve рост хранилища до size байт не упирается в нехватку места:
+// без этого файл, растущий через mmap, узнал бы о переполнении диска только посреди записи.
+type Reserver interface {
+	Reserve(size int64) error // выделить место под первые size байт; размер хранилища не меняется, при нехватке места - ErrNoSpace
+}
+
+// Backend - реализация хранилища страниц
+type Backend int
+
+const (
+	BackendMmap   Backend = iota // файл, доступ через mmap
+	BackendFile                  // файл, доступ через pread/pwrite
+	BackendMemory                // память процесса, данные теряются при закрытии
+)
+
+// OpenOptions - параметры открытия файла хранилища страниц
+type OpenOptions struct {
+	// ReadOnly - файл открывается только на чтение под разделяемой блокировкой,
+	// иначе файл создается при необходимости и берется исключительная блокировка
+	ReadOnly bool
+	// LockTimeout - сколько ждать, пока другой процесс отпустит блокировку файла; 0 - сразу вернуть ErrLocked
+	LockTimeout time.Duration
+}
+
+// Open - открывает хранилище страниц выбранной реализации для файла path на запись
+func Open(backend Backend, path string) (PageStore, error) {
+	return OpenWithOptions(backend, path, OpenOptions{})
+}
+
+// OpenWithOptions - открывает хранилище страниц выбранной реализации для файла path.
+// Файловые реализации блокируют файл через flock, так что два процесса не могут писать в него одновременно.
+func OpenWithOptions(backend Backend, path string, opts OpenOptions) (PageStore, error) {
+	switch backend {
+	case BackendMmap:
+		return openMmap(path, opts)
+	case BackendFile:
+		return openFile(path, opts)
+	case BackendMemory:
+		return NewMemory(), nil
+	default:
+		return nil, errors.New("unknown page store backend")
+	}
+}
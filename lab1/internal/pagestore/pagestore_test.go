@@ -0,0 +1,225 @@
+package pagestore
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Функция тестирования общих свойств всех реализаций хранилища страниц
+func TestPageStores(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		backend Backend
+	}{
+		{name: "mmap", backend: BackendMmap},
+		{name: "file", backend: BackendFile},
+		{name: "memory", backend: BackendMemory},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pages, err := Open(tc.backend, filepath.Join(t.TempDir(), "pages.data"))
+			require.NoError(t, err)
+			defer pages.Close()
+
+			pageSize := int64(os.Getpagesize())
+			data := []byte("debildb page store")
+
+			n, err := pages.WriteAt(data, pageSize+10) // запись за концом и не по границе страницы
+			require.NoError(t, err)
+			require.Equal(t, len(data), n)
+
+			size, err := pages.Size()
+			require.NoError(t, err)
+			require.Equal(t, pageSize+10+int64(len(data)), size)
+
+			buf := make([]byte, len(data))
+			_, err = pages.ReadAt(buf, pageSize+10)
+			require.NoError(t, err)
+			require.Equal(t, data, buf)
+
+			zeros := make([]byte, 10) // дыра до записанного участка заполнена нулями
+			_, err = pages.ReadAt(zeros, pageSize)
+			require.NoError(t, err)
+			require.Equal(t, make([]byte, 10), zeros)
+
+			n, err = pages.ReadAt(make([]byte, 100), size-5) // чтение за концом
+			require.ErrorIs(t, err, io.EOF)
+			require.Equal(t, 5, n)
+
+			require.NoError(t, pages.Truncate(pageSize))
+			size, err = pages.Size()
+			require.NoError(t, err)
+			require.Equal(t, pageSize, size)
+
+			if r, ok := pages.(Reserver); ok { // резервирование места не меняет размер
+				require.NoError(t, r.Reserve(4*pageSize))
+				size, err = pages.Size()
+				require.NoError(t, err)
+				require.Equal(t, pageSize, size)
+			}
+
+			require.NoError(t, pages.Sync())
+		})
+	}
+}
+
+// Функция тестирования блокировок файла и режима только для чтения
+func TestLock(t *testing.T) {
+	for _, backend := range []Backend{BackendMmap, BackendFile} {
+		path := filepath.Join(t.TempDir(), "pages.data")
+
+		writer, err := Open(backend, path)
+		require.NoError(t, err)
+		_, err = writer.WriteAt([]byte("debildb"), 0)
+		require.NoError(t, err)
+
+		_, err = Open(backend, path) // второй писатель
+		require.ErrorIs(t, err, ErrLocked)
+		_, err = OpenWithOptions(backend, path, OpenOptions{ReadOnly: true})
+		require.ErrorIs(t, err, ErrLocked)
+
+		closed := make(chan error, 1)
+		go func() { // писатель закрывает файл, пока читатель ждет блокировку
+			time.Sleep(50 * time.Millisecond)
+			closed <- writer.Close()
+		}()
+		reader, err := OpenWithOptions(backend, path, OpenOptions{ReadOnly: true, LockTimeout: 5 * time.Second})
+		require.NoError(t, err)
+		require.NoError(t, <-closed)
+
+		other, err := OpenWithOptions(backend, path, OpenOptions{ReadOnly: true}) // читателей может быть несколько
+		require.NoError(t, err)
+
+		buf := make([]byte, 7)
+		_, err = other.ReadAt(buf, 0)
+		require.NoError(t, err)
+		require.Equal(t, []byte("debildb"), buf)
+
+		_, err = reader.WriteAt([]byte("x"), 0)
+		require.ErrorIs(t, err, ErrReadOnly)
+		require.ErrorIs(t, reader.Truncate(0), ErrReadOnly)
+		require.NoError(t, reader.Sync())
+
+		_, err = OpenWithOptions(backend, path, OpenOptions{LockTimeout: 20 * time.Millisecond}) // писатель не дождался читателей
+		require.ErrorIs(t, err, ErrLocked)
+
+		require.NoError(t, reader.Close())
+		require.NoError(t, other.Close())
+		reopened, err := Open(backend, path) // после читателей писатель снова получает блокировку
+		require.NoError(t, err)
+
+		replacement := path + ".new"
+		require.NoError(t, os.WriteFile(replacement, []byte("replaced"), 0644))
+		go func() { // держатель блокировки подменяет файл переименованием и только потом закрывает старый
+			time.Sleep(50 * time.Millisecond)
+			if err := os.Rename(replacement, path); err != nil {
+				closed <- err
+				return
+			}
+			closed <- reopened.Close()
+		}()
+		waiter, err := OpenWithOptions(backend, path, OpenOptions{ReadOnly: true, LockTimeout: 5 * time.Second})
+		require.NoError(t, err)
+		require.NoError(t, <-closed)
+		buf = make([]byte, 8)
+		_, err = waiter.ReadAt(buf, 0)
+		require.NoError(t, err)
+		require.Equal(t, []byte("replaced"), buf) // ожидавший блокировку открыл новый файл, а не удаленный
+		require.NoError(t, waiter.Close())
+	}
+
+	_, err := OpenWithOptions(BackendMmap, filepath.Join(t.TempDir(), "missing.data"), OpenOptions{ReadOnly: true}) // только для чтения файл не создается
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// Функция тестирования внедрения сбоев
+func TestFaulty(t *testing.T) {
+	t.Run("no space", func(t *testing.T) {
+		faulty := NewFaulty(NewMemory())
+		faulty.FailWrites(2, ErrNoSpace)
+
+		_, err := faulty.WriteAt([]byte("first"), 0)
+		require.NoError(t, err)
+
+		_, err = faulty.WriteAt([]byte("second"), 5)
+		require.ErrorIs(t, err, ErrNoSpace)
+
+		size, err := faulty.Size()
+		require.NoError(t, err)
+		require.Equal(t, int64(5), size)
+	})
+
+	t.Run("no space on reserve", func(t *testing.T) {
+		faulty := NewFaulty(NewMemory())
+		require.NoError(t, faulty.Reserve(100))
+
+		faulty.FailReserve(ErrNoSpace)
+		require.ErrorIs(t, faulty.Reserve(100), ErrNoSpace)
+		_, err := faulty.WriteAt([]byte("data"), 0) // запись не затронута
+		require.NoError(t, err)
+
+		faulty.FailReserve(nil)
+		require.NoError(t, faulty.Reserve(100))
+	})
+
+	t.Run("short write", func(t *testing.T) {
+		faulty := NewFaulty(NewMemory())
+		faulty.ShortWrite(1, 3)
+
+		n, err := faulty.WriteAt([]byte("abcdef"), 0)
+		require.ErrorIs(t, err, io.ErrShortWrite)
+		require.Equal(t, 3, n)
+
+		buf := make([]byte, 3)
+		_, err = faulty.ReadAt(buf, 0)
+		require.NoError(t, err)
+		require.Equal(t, []byte("abc"), buf)
+	})
+
+	t.Run("crash drops unsynced writes", func(t *testing.T) {
+		mem := NewMemory()
+		faulty := NewFaulty(mem)
+		faulty.CrashAt(3, true)
+
+		_, err := faulty.WriteAt([]byte("synced"), 0)
+		require.NoError(t, err)
+		require.NoError(t, faulty.Sync())
+
+		_, err = faulty.WriteAt([]byte("lost"), 0)
+		require.NoError(t, err)
+
+		_, err = faulty.WriteAt([]byte("torn write"), 6)
+		require.ErrorIs(t, err, ErrCrashed)
+		require.True(t, faulty.Crashed())
+
+		_, err = faulty.ReadAt(make([]byte, 1), 0)
+		require.ErrorIs(t, err, ErrCrashed)
+
+		size, err := mem.Size() // после падения остается только то, что было сброшено
+		require.NoError(t, err)
+		require.Equal(t, int64(6), size)
+
+		buf := make([]byte, 6)
+		_, err = mem.ReadAt(buf, 0)
+		require.NoError(t, err)
+		require.Equal(t, []byte("synced"), buf)
+	})
+
+	t.Run("crash keeps torn write", func(t *testing.T) {
+		mem := NewMemory()
+		faulty := NewFaulty(mem)
+		faulty.CrashAt(1, false)
+
+		_, err := faulty.WriteAt([]byte("abcdef"), 0)
+		require.ErrorIs(t, err, ErrCrashed)
+
+		buf := make([]byte, 3)
+		_, err = mem.ReadAt(buf, 0)
+		require.NoError(t, err)
+		require.Equal(t, []byte("abc"), buf)
+	})
+}
@@ -0,0 +1,26 @@
+//go:build linux
+
+package pagestore
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+const fallocKeepSize = 0x01 // FALLOC_FL_KEEP_SIZE: выделить блоки, не меняя размер файла
+
+// Функция выделения места под первые size байт файла через fallocate.
+// Файловые системы без fallocate не резервируют место, на них рост файла проверяется только при записи.
+func reserve(file *os.File, size int64) error {
+	for {
+		err := syscall.Fallocate(int(file.Fd()), fallocKeepSize, 0, size)
+		switch {
+		case errors.Is(err, syscall.EINTR):
+			continue
+		case errors.Is(err, syscall.EOPNOTSUPP):
+			return nil
+		}
+		return err
+	}
+}
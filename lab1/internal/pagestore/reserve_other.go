@@ -0,0 +1,12 @@
+//go:build !linux
+
+package pagestore
+
+import (
+	"os"
+)
+
+// Функция выделения места под первые size байт файла. На платформах без fallocate место заранее не резервируется.
+func reserve(_ *os.File, _ int64) error {
+	return nil
+}
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"math/bits"
 )
@@ -10,39 +11,118 @@ import (
 // 1 key = 1 B (len) + 127 B (data)
 // 1 value = 2 B (len) + 1235 B (data)
 const (
-	lenKey   = 128
-	lenValue = 1237
+	DefaultMaxKeySize   = 127
+	DefaultMaxValueSize = 1235
 )
 
-const (
-	keyType = iota
-	valType
+var (
+	ErrKeyTooLong   = errors.New("key too long")
+	ErrValueTooLong = errors.New("value too long")
 )
 
-// Сериализует пару ключ-значение,
-// где ключ занимает 128 байт
-// значение занимает 1237 байт.
-// Возвращает слайс байт размером 1365 байт
-func MarshalKV(key, val string) ([]byte, error) {
-	keyBytes, err := serializeString(key, keyType)	// Сериализация ключа
+// Layout - размеры записи ключ-значение.
+// Под ключ и значение отводятся области фиксированного размера: длина (varint) + данные.
+type Layout struct {
+	MaxKeySize   int // максимальный размер ключа в байтах
+	MaxValueSize int // максимальный размер значения в байтах
+}
+
+// DefaultLayout - раскладка записи по умолчанию (1365 байт)
+var DefaultLayout = Layout{MaxKeySize: DefaultMaxKeySize, MaxValueSize: DefaultMaxValueSize}
+
+// KeyLen - размер области ключа
+func (l Layout) KeyLen() int {
+	return uintLen(uint64(l.MaxKeySize)) + l.MaxKeySize
+}
+
+// ValueLen - размер области значения
+func (l Layout) ValueLen() int {
+	return uintLen(uint64(l.MaxValueSize)) + l.MaxValueSize
+}
+
+// RecordLen - размер всей записи
+func (l Layout) RecordLen() int {
+	return l.KeyLen() + l.ValueLen()
+}
+
+// Сериализует пару ключ-значение в запись размером RecordLen байт
+func (l Layout) Marshal(key, val string) ([]byte, error) {
+	if len(key) > l.MaxKeySize { // ключ не должен вылезать за свою область
+		return nil, ErrKeyTooLong
+	}
+	if len(val) > l.MaxValueSize {
+		return nil, ErrValueTooLong
+	}
+
+	keyBytes, err := serializeString(key, l.KeyLen()) // Сериализация ключа
 	if err != nil {
 		return nil, err
 	}
-	valBytes, err := serializeString(val, valType) // Сериализация значения
+	valBytes, err := serializeString(val, l.ValueLen()) // Сериализация значения
 	if err != nil {
 		return nil, err
 	}
 
-	keyBytes = padSlice(keyBytes, lenKey)
-	valBytes = padSlice(valBytes, lenValue)
+	keyBytes = padSlice(keyBytes, l.KeyLen())
+	valBytes = padSlice(valBytes, l.ValueLen())
 
-	result := make([]byte, lenKey+lenValue)
+	result := make([]byte, l.RecordLen())
 	copy(result[0:], keyBytes)
-	copy(result[lenKey:], valBytes)
+	copy(result[l.KeyLen():], valBytes)
 
 	return result, nil
 }
 
+// Парсинг записи ключ-значение размером RecordLen байт
+func (l Layout) Unmarshal(dataKV []byte) (string, string, error) {
+	bufKey := bytes.NewBuffer(dataKV[:l.KeyLen()])
+	bufValue := bytes.NewBuffer(dataKV[l.KeyLen():l.RecordLen()])
+	key, err := deserializeString(bufKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	val, err := deserializeString(bufValue)
+	if err != nil {
+		return "", "", err
+	}
+
+	return key, val, nil
+}
+
+// Сериализует ключ в область размером KeyLen байт
+func (l Layout) MarshalKey(key string) ([]byte, error) {
+	if len(key) > l.MaxKeySize {
+		return nil, ErrKeyTooLong
+	}
+
+	keyBytes, err := serializeString(key, l.KeyLen())
+	if err != nil {
+		return nil, err
+	}
+
+	return padSlice(keyBytes, l.KeyLen()), nil
+}
+
+// Парсинг ключа из области размером KeyLen байт
+func (l Layout) UnmarshalKey(data []byte) (string, error) {
+	return deserializeString(bytes.NewBuffer(data[:l.KeyLen()]))
+}
+
+// Сериализует пару ключ-значение,
+// где ключ занимает 128 байт
+// значение занимает 1237 байт.
+// Возвращает слайс байт размером 1365 байт
+func MarshalKV(key, val string) ([]byte, error) {
+	return DefaultLayout.Marshal(key, val)
+}
+
+// Функция подсчета количества байт, которые займет число в varint кодировке
+func uintLen(value uint64) int {
+	bytesLen := (bits.Len64(value) + 6) / 7
+	return max(bytesLen, 1)
+}
+
 // Функция дополнения нулями в случае если значение меньше целевого размера
 func padSlice(data []byte, targetLen int) []byte {
 	if len(data) < targetLen {
@@ -73,13 +153,8 @@ func serializeUint(value uint64) ([]byte, error) {
 }
 
 // Сериализация строки - состоит из сериализации числа (длины строки) и сериализации самой строки
-func serializeString(value string, typeVal int) ([]byte, error) {
-	var bf *bytes.Buffer
-	if typeVal == keyType {
-		bf = bytes.NewBuffer(make([]byte, 0, 128))
-	} else {
-		bf = bytes.NewBuffer(make([]byte, 0, 1237))
-	}
+func serializeString(value string, capacity int) ([]byte, error) {
+	bf := bytes.NewBuffer(make([]byte, 0, capacity))
 
 	bLen, err := serializeUint(uint64(len(value)))
 	if err != nil {
@@ -94,20 +169,7 @@ func serializeString(value string, typeVal int) ([]byte, error) {
 // Парсинг записи ключ-значение
 // На вход подается слайс байт, длинной в 1365 байт
 func UnmarshalKV(dataKV []byte) (string, string, error) {
-	bufKey := bytes.NewBuffer(dataKV[:128])
-	bufValue := bytes.NewBuffer(dataKV[128:])
-	key, err := deserializeString(bufKey)
-	if err != nil {
-		return "", "", err
-	}
-
-	val, err := deserializeString(bufValue)
-	if err != nil {
-		return "", "", err
-	}
-
-	return key, val, nil
-
+	return DefaultLayout.Unmarshal(dataKV)
 }
 
 // Функция дессериализации числа (длина строки)
@@ -1,8 +1,10 @@
 package parser
 
 import (
+	"math"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -66,3 +68,93 @@ func TestParseKV(t *testing.T) {
 		})
 	}
 }
+
+// Функция проверки раскладки записи с заданными размерами ключа и значения
+func TestLayout(t *testing.T) {
+	layout := Layout{MaxKeySize: 16, MaxValueSize: 300}
+	require.Equal(t, 17, layout.KeyLen())
+	require.Equal(t, 302, layout.ValueLen())
+	require.Equal(t, lenKV, DefaultLayout.RecordLen())
+
+	dataKV, err := layout.Marshal(strings.Repeat("k", 16), strings.Repeat("v", 300))
+	require.NoError(t, err)
+	require.Equal(t, layout.RecordLen(), len(dataKV))
+
+	parsedKey, parsedValue, err := layout.Unmarshal(dataKV)
+	require.NoError(t, err)
+	require.Equal(t, strings.Repeat("k", 16), parsedKey)
+	require.Equal(t, strings.Repeat("v", 300), parsedValue)
+
+	_, err = layout.Marshal(strings.Repeat("k", 17), "")
+	require.ErrorIs(t, err, ErrKeyTooLong)
+
+	_, err = layout.Marshal("key", strings.Repeat("v", 301))
+	require.ErrorIs(t, err, ErrValueTooLong)
+}
+
+// Функция проверки кодирования типизированных значений
+func TestTypedValue(t *testing.T) {
+	ts := time.Date(1969, 7, 20, 20, 17, 40, 123456789, time.UTC)
+	for _, tc := range []struct {
+		name  string
+		value any
+		want  any
+	}{
+		{name: "nil", value: nil},
+		{name: "int", value: -42, want: int64(-42)},
+		{name: "int64 min", value: int64(math.MinInt64)},
+		{name: "uint64 max", value: uint64(math.MaxUint64)},
+		{name: "float", value: 3.25},
+		{name: "bool", value: true},
+		{name: "bytes", value: []byte{0, 1, 0xff}},
+		{name: "empty bytes", value: []byte{}},
+		{name: "string", value: "должник"},
+		{name: "time before epoch", value: ts},
+		{name: "time in other zone", value: ts.In(time.FixedZone("MSK", 3*3600)), want: ts},
+		{
+			name:  "nested",
+			value: map[string]any{"name": "roma", "tags": []any{"a", int64(1), nil}, "meta": map[string]any{"ok": false}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := MarshalValue(tc.value)
+			require.NoError(t, err)
+
+			got, err := UnmarshalValue(data)
+			require.NoError(t, err)
+			want := tc.want
+			if want == nil {
+				want = tc.value
+			}
+			require.Equal(t, want, got)
+		})
+	}
+
+	a, err := MarshalValue(map[string]any{"a": 1, "b": 2, "c": 3})
+	require.NoError(t, err)
+	b, err := MarshalValue(map[string]any{"c": 3, "b": 2, "a": 1})
+	require.NoError(t, err)
+	require.Equal(t, a, b) // кодировка не зависит от порядка обхода словаря
+
+	_, err = MarshalValue(struct{}{})
+	require.ErrorIs(t, err, ErrUnsupportedType)
+
+	deep := any("leaf")
+	for i := 0; i <= maxValueDepth+1; i++ {
+		deep = []any{deep}
+	}
+	_, err = MarshalValue(deep)
+	require.ErrorIs(t, err, ErrUnsupportedType)
+
+	for _, data := range [][]byte{
+		{},                           // нет тега
+		{0x7f},                       // неизвестный тег
+		{byte(TypeString), 5, 'a'},   // строка короче длины
+		{byte(TypeList), 0xff, 0x7f}, // количество больше данных
+		{byte(TypeBool), 2},
+		{byte(TypeNil), 0}, // лишние байты
+	} {
+		_, err = UnmarshalValue(data)
+		require.ErrorIs(t, err, ErrInvalidValue, "%v", data)
+	}
+}
@@ -0,0 +1,284 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Type - тег типа значения, первый байт его кодировки
+type Type byte
+
+const (
+	TypeNil    Type = iota // отсутствующее значение (nil)
+	TypeInt                // int64, zigzag varint
+	TypeUint               // uint64, varint
+	TypeFloat              // float64, 8 байт IEEE 754 big-endian
+	TypeBool               // bool, 1 байт
+	TypeBytes              // []byte, varint длина + данные
+	TypeString             // string, varint длина + данные
+	TypeTime               // time.Time, zigzag varint секунды + varint наносекунды, в UTC
+	TypeList               // []any, varint количество + элементы
+	TypeMap                // map[string]any, varint количество + пары (ключ как строка без тега, значение), ключи по возрастанию
+)
+
+const (
+	maxValueDepth = 64 // ограничение вложенности списков и словарей
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported value type")
+	ErrInvalidValue    = errors.New("invalid typed value")
+)
+
+// MarshalValue - кодирует значение с тегом типа.
+// Поддерживаются nil, int, int64, uint64, float64, bool, []byte, string, time.Time,
+// а также вложенные []any и map[string]any из них.
+// Кодировка детерминирована: ключи словарей пишутся по возрастанию.
+func MarshalValue(v any) ([]byte, error) {
+	bf := bytes.NewBuffer(nil)
+	if err := encodeValue(bf, v, 0); err != nil {
+		return nil, err
+	}
+
+	return bf.Bytes(), nil
+}
+
+// UnmarshalValue - декодирует значение, записанное MarshalValue.
+// Целые возвращаются как int64 и uint64, время - в UTC, вложенные значения - как []any и map[string]any.
+func UnmarshalValue(data []byte) (any, error) {
+	bf := bytes.NewBuffer(data)
+	v, err := decodeValue(bf, 0)
+	if err != nil {
+		return nil, err
+	}
+	if bf.Len() != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrInvalidValue, bf.Len())
+	}
+
+	return v, nil
+}
+
+// Функция кодирования значения с тегом типа
+func encodeValue(bf *bytes.Buffer, v any, depth int) error {
+	if depth > maxValueDepth {
+		return fmt.Errorf("%w: nesting deeper than %d", ErrUnsupportedType, maxValueDepth)
+	}
+
+	switch v := v.(type) {
+	case nil:
+		bf.WriteByte(byte(TypeNil))
+	case int:
+		bf.WriteByte(byte(TypeInt))
+		writeUint(bf, zigzag(int64(v)))
+	case int64:
+		bf.WriteByte(byte(TypeInt))
+		writeUint(bf, zigzag(v))
+	case uint64:
+		bf.WriteByte(byte(TypeUint))
+		writeUint(bf, v)
+	case float64:
+		bf.WriteByte(byte(TypeFloat))
+		bf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(v)))
+	case bool:
+		bf.WriteByte(byte(TypeBool))
+		if v {
+			bf.WriteByte(1)
+		} else {
+			bf.WriteByte(0)
+		}
+	case []byte:
+		bf.WriteByte(byte(TypeBytes))
+		writeUint(bf, uint64(len(v)))
+		bf.Write(v)
+	case string:
+		bf.WriteByte(byte(TypeString))
+		writeUint(bf, uint64(len(v)))
+		bf.WriteString(v)
+	case time.Time:
+		bf.WriteByte(byte(TypeTime))
+		writeUint(bf, zigzag(v.Unix()))
+		writeUint(bf, uint64(v.Nanosecond()))
+	case []any:
+		bf.WriteByte(byte(TypeList))
+		writeUint(bf, uint64(len(v)))
+		for _, elem := range v {
+			if err := encodeValue(bf, elem, depth+1); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		bf.WriteByte(byte(TypeMap))
+		writeUint(bf, uint64(len(v)))
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			writeUint(bf, uint64(len(key)))
+			bf.WriteString(key)
+			if err := encodeValue(bf, v[key], depth+1); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%w: %T", ErrUnsupportedType, v)
+	}
+
+	return nil
+}
+
+// Функция декодирования значения с тегом типа
+func decodeValue(bf *bytes.Buffer, depth int) (any, error) {
+	if depth > maxValueDepth {
+		return nil, fmt.Errorf("%w: nesting deeper than %d", ErrInvalidValue, maxValueDepth)
+	}
+
+	tag, err := bf.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing type tag", ErrInvalidValue)
+	}
+
+	switch Type(tag) {
+	case TypeNil:
+		return nil, nil
+	case TypeInt:
+		n, err := readUint(bf)
+		return unzigzag(n), err
+	case TypeUint:
+		return readUint(bf)
+	case TypeFloat:
+		data, err := readBytes(bf, 8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), nil
+	case TypeBool:
+		b, err := bf.ReadByte()
+		if err != nil || b > 1 {
+			return nil, fmt.Errorf("%w: bool", ErrInvalidValue)
+		}
+		return b == 1, nil
+	case TypeBytes:
+		data, err := readLenBytes(bf)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.Clone(data), nil
+	case TypeString:
+		data, err := readLenBytes(bf)
+		return string(data), err
+	case TypeTime:
+		sec, err := readUint(bf)
+		if err != nil {
+			return nil, err
+		}
+		nsec, err := readUint(bf)
+		if err != nil {
+			return nil, err
+		}
+		if nsec >= uint64(time.Second) {
+			return nil, fmt.Errorf("%w: time nanoseconds %d", ErrInvalidValue, nsec)
+		}
+		return time.Unix(unzigzag(sec), int64(nsec)).UTC(), nil
+	case TypeList:
+		count, err := readCount(bf)
+		if err != nil {
+			return nil, err
+		}
+		list := make([]any, 0, count)
+		for i := 0; i < count; i++ {
+			elem, err := decodeValue(bf, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, elem)
+		}
+		return list, nil
+	case TypeMap:
+		count, err := readCount(bf)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]any, count)
+		for i := 0; i < count; i++ {
+			key, err := readLenBytes(bf)
+			if err != nil {
+				return nil, err
+			}
+			elem, err := decodeValue(bf, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			m[string(key)] = elem
+		}
+		return m, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown type tag %d", ErrInvalidValue, tag)
+}
+
+// Функция записи числа в varint кодировке
+func writeUint(bf *bytes.Buffer, value uint64) {
+	data, _ := serializeUint(value)
+	if len(data) == 0 { // ноль кодируется одним байтом
+		data = []byte{0}
+	}
+	bf.Write(data)
+}
+
+// Функция чтения числа в varint кодировке
+func readUint(bf *bytes.Buffer) (uint64, error) {
+	n, err := binary.ReadUvarint(bf)
+	if err != nil {
+		return 0, fmt.Errorf("%w: varint", ErrInvalidValue)
+	}
+	return n, nil
+}
+
+// Функция чтения количества элементов. Каждый элемент занимает хотя бы байт, что защищает от огромных аллокаций.
+func readCount(bf *bytes.Buffer) (int, error) {
+	n, err := readUint(bf)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(bf.Len()) {
+		return 0, fmt.Errorf("%w: count %d exceeds data", ErrInvalidValue, n)
+	}
+	return int(n), nil
+}
+
+// Функция чтения данных с длиной в varint кодировке
+func readLenBytes(bf *bytes.Buffer) ([]byte, error) {
+	n, err := readUint(bf)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(bf.Len()) {
+		return nil, fmt.Errorf("%w: length %d exceeds data", ErrInvalidValue, n)
+	}
+	return readBytes(bf, int(n))
+}
+
+// Функция чтения n байт
+func readBytes(bf *bytes.Buffer, n int) ([]byte, error) {
+	if bf.Len() < n {
+		return nil, fmt.Errorf("%w: truncated", ErrInvalidValue)
+	}
+	return bf.Next(n), nil
+}
+
+// Функция zigzag-кодирования: числа с маленьким модулем занимают мало байт независимо от знака
+func zigzag(n int64) uint64 {
+	return uint64(n<<1) ^ uint64(n>>63)
+}
+
+// Функция обратного zigzag-кодирования
+func unzigzag(n uint64) int64 {
+	return int64(n>>1) ^ -int64(n&1)
+}
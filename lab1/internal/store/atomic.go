@@ -0,0 +1,165 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	bkt "debildb/internal/bucket"
+)
+
+var (
+	ErrNotInteger = errors.New("value is not an integer")
+	ErrOverflow   = errors.New("integer overflow")
+)
+
+// CompareAndSwap - записывает newVal, только если текущее значение ключа равно oldVal.
+// Возвращает false, если значение отличается или ключа нет.
+func (s *Store) CompareAndSwap(key, oldVal, newVal string) (bool, error) {
+	return s.CompareAndSwapContext(context.Background(), key, oldVal, newVal)
+}
+
+// CompareAndSwapContext - CompareAndSwap с отменой и дедлайном из ctx
+func (s *Store) CompareAndSwapContext(ctx context.Context, key, oldVal, newVal string) (bool, error) {
+	swapped := false
+	err := s.update(ctx, "compare-and-swap", key, func(val string, exists bool) (*Change, error) {
+		if !exists || val != oldVal {
+			return nil, nil
+		}
+		swapped = true
+		return &Change{Op: OpPut, Key: key, Val: newVal}, nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("store - CompareAndSwap: %w", err)
+	}
+
+	return swapped, nil
+}
+
+// SetIfAbsent - записывает значение, только если ключа еще нет. Возвращает false, если ключ уже был.
+func (s *Store) SetIfAbsent(key, value string) (bool, error) {
+	return s.SetIfAbsentContext(context.Background(), key, value)
+}
+
+// SetIfAbsentContext - SetIfAbsent с отменой и дедлайном из ctx
+func (s *Store) SetIfAbsentContext(ctx context.Context, key, value string) (bool, error) {
+	set := false
+	err := s.update(ctx, "set-if-absent", key, func(_ string, exists bool) (*Change, error) {
+		if exists {
+			return nil, nil
+		}
+		set = true
+		return &Change{Op: OpPut, Key: key, Val: value}, nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("store - SetIfAbsent: %w", err)
+	}
+
+	return set, nil
+}
+
+// Increment - прибавляет delta к значению ключа, записанному десятичным числом, и возвращает новое значение.
+// Отсутствующий ключ считается равным нулю. Если результат не помещается в int64, значение не меняется
+// и возвращается ErrOverflow.
+func (s *Store) Increment(key string, delta int64) (int64, error) {
+	return s.IncrementContext(context.Background(), key, delta)
+}
+
+// IncrementContext - Increment с отменой и дедлайном из ctx
+func (s *Store) IncrementContext(ctx context.Context, key string, delta int64) (int64, error) {
+	var result int64
+	err := s.update(ctx, "increment", key, func(val string, exists bool) (*Change, error) {
+		var cur int64
+		if exists {
+			n, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %q", ErrNotInteger, val)
+			}
+			cur = n
+		}
+		if (delta > 0 && cur > math.MaxInt64-delta) || (delta < 0 && cur < math.MinInt64-delta) {
+			return nil, fmt.Errorf("%w: %d + %d", ErrOverflow, cur, delta)
+		}
+		result = cur + delta
+		return &Change{Op: OpPut, Key: key, Val: strconv.FormatInt(result, 10)}, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("store - Increment: %w", err)
+	}
+
+	return result, nil
+}
+
+// GetAndDelete - удаляет ключ и возвращает его последнее значение
+func (s *Store) GetAndDelete(key string) (string, error) {
+	return s.GetAndDeleteContext(context.Background(), key)
+}
+
+// GetAndDeleteContext - GetAndDelete с отменой и дедлайном из ctx
+func (s *Store) GetAndDeleteContext(ctx context.Context, key string) (string, error) {
+	var old string
+	err := s.update(ctx, "get-and-delete", key, func(val string, exists bool) (*Change, error) {
+		if !exists {
+			return nil, bkt.ErrKeyNotFound
+		}
+		old = val
+		return &Change{Op: OpDelete, Key: key}, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("store - GetAndDelete: %w", err)
+	}
+
+	return old, nil
+}
+
+// Функция атомарного изменения ключа: чтение, решение и запись выполняются под одной блокировкой хранилища,
+// поэтому между ними не может вклиниться другая запись или сплит бакета.
+// fn получает текущее значение и возвращает изменение, nil - ничего не менять.
+// При отмене ctx изменение не применяется, как в SetValueContext. op - имя операции для наблюдателя.
+func (s *Store) update(ctx context.Context, op, key string, fn func(val string, exists bool) (*Change, error)) (err error) {
+	var change *Change
+	defer func(start time.Time) {
+		switch { // без изменения и без ошибки оповещать не о чем
+		case change != nil:
+			s.observeWrite(start, op, *change, &err)
+		case err != nil:
+			s.observeWrite(start, op, Change{Key: key}, &err)
+		}
+	}(time.Now())
+
+	if err := s.writable(); err != nil {
+		return err
+	}
+
+	if err := s.lockContext(ctx); err != nil {
+		return err
+	}
+	val, err := s.getValue(key)
+	exists := err == nil
+	if err != nil && !errors.Is(err, bkt.ErrKeyNotFound) {
+		s.mu.Unlock()
+		return err
+	}
+
+	change, err = fn(val, exists)
+	if err == nil && change != nil {
+		switch change.Op {
+		case OpPut:
+			err = s.putValue(ctx, change.Key, change.Val)
+		case OpDelete:
+			err = s.deleteValue(ctx, change.Key)
+		}
+		if err == nil {
+			s.recordChange(*change, oldValue{val: val, exists: exists})
+		}
+	}
+	s.mu.Unlock()
+	if err != nil || change == nil {
+		return err
+	}
+
+	return s.commit() // ждем, пока запись станет durable согласно политике сброса
+}
@@ -0,0 +1,377 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	bkt "debildb/internal/bucket"
+
+	"go.uber.org/zap"
+)
+
+// KVIterator - источник пар ключ-значение для массовой загрузки
+type KVIterator interface {
+	Next() (bkt.KV, bool) // возвращает следующую пару, false - когда пары закончились
+}
+
+// SliceIterator - итератор по слайсу пар ключ-значение
+type SliceIterator struct {
+	kvs []bkt.KV
+	pos int
+}
+
+// NewSliceIterator - создает итератор по слайсу пар
+func NewSliceIterator(kvs []bkt.KV) *SliceIterator {
+	return &SliceIterator{kvs: kvs}
+}
+
+// Next - возвращает следующую пару из слайса
+func (it *SliceIterator) Next() (bkt.KV, bool) {
+	if it.pos >= len(it.kvs) {
+		return bkt.KV{}, false
+	}
+	kv := it.kvs[it.pos]
+	it.pos++
+
+	return kv, true
+}
+
+// Через сколько записей итератора проверяется отмена массовой загрузки
+const bulkCheckEvery = 1024
+
+// Запись, подготовленная к раскладке по бакетам
+type bulkRecord struct {
+	kv   bkt.KV
+	hash int // последние maxGlobalDepth бит хэша ключа
+}
+
+// BulkLoad - массовая загрузка значений.
+// Вместо последовательных SetValue (каждый из которых может вызвать сплит и глобальный ресайз)
+// сначала вычитываются все записи, по хэшам выбирается итоговая глубина каждого бакета,
+// после чего бакеты пишутся в свободные страницы или в конец файла, каждая страница ровно один раз.
+// Загрузка фиксируется записью заголовка, и только после этого освобождаются страницы старых бакетов и старого индекса.
+// Уже лежащие в хранилище значения сохраняются, при повторе ключа побеждает последнее значение из iter.
+func (s *Store) BulkLoad(iter KVIterator) error {
+	return s.BulkLoadContext(context.Background(), iter)
+}
+
+// BulkLoadContext - массовая загрузка с отменой и дедлайном из ctx.
+// Отмена проверяется, пока записи вычитываются и раскладываются по бакетам; как только начинается
+// запись бакетов, загрузка доводится до конца. Прерванная или неудавшаяся загрузка не меняет хранилище,
+// сбой процесса до записи заголовка тоже: после открытия бд содержит прежние значения.
+func (s *Store) BulkLoadContext(ctx context.Context, iter KVIterator) (err error) {
+	defer func() { s.observeError("bulk load", "", err) }()
+
+	if err := s.writable(); err != nil {
+		return fmt.Errorf("store - BulkLoad: %w", err)
+	}
+
+	if err := s.lockContext(ctx); err != nil {
+		return fmt.Errorf("store - BulkLoad: %w", err)
+	}
+	err = s.bulkLoad(ctx, iter)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return s.commit()
+}
+
+// Функция массовой загрузки без блокировки хранилища
+func (s *Store) bulkLoad(ctx context.Context, iter KVIterator) error {
+	var loaded []bkt.KV
+	records, err := s.collectBulkRecords(ctx, iter, &loaded) // собираем записи из хранилища и из итератора
+	if err != nil {
+		return fmt.Errorf("store - BulkLoad: %w", err)
+	}
+
+	olds := s.watchedValues(loaded) // старые значения для подписчиков, пока бд не переписана
+
+	var pages []bkt.Page
+	globalDepth := s.opts.InitialGlobalDepth
+	if s.opts.Scheme == SchemeLinear {
+		pages, globalDepth = s.partitionLinear(records)
+	} else {
+		if err = s.partitionRecords(records, 0, 0, &pages); err != nil { // раскладываем записи по бакетам по битам хэша
+			return fmt.Errorf("store - BulkLoad: %w", err)
+		}
+		for _, page := range pages {
+			globalDepth = max(globalDepth, page.LocalDepth)
+		}
+	}
+
+	start := s.bulkStart(len(pages))
+	if err = s.reserveBulk(start, len(pages)); err != nil {
+		return fmt.Errorf("store - BulkLoad: %w", err)
+	}
+
+	if err = ctx.Err(); err != nil { // дальше файл перезаписывается и прерваться уже нельзя
+		return fmt.Errorf("store - BulkLoad: %w", err)
+	}
+
+	var oldIndexPages []int
+	if s.index != nil { // индекс будет построен заново, все его нынешние страницы принадлежат старому индексу
+		if oldIndexPages, err = s.pagesOfKind(bkt.KindIndex); err != nil {
+			return fmt.Errorf("store - BulkLoad: %w", err)
+		}
+	}
+
+	// новые бакеты пишутся в свободные страницы или за концом файла с другим типом страниц, чем у текущих.
+	// Пока заголовок не переключен на этот тип, при открытии бд такие страницы считаются брошенными и освобождаются,
+	// поэтому сбой до переключения оставляет хранилище таким, каким оно было до загрузки
+	next := table{kind: bkt.KindBucketAlt}
+	if s.kind == bkt.KindBucketAlt {
+		next.kind = bkt.KindBucket
+	}
+	created, endOffset, err := bkt.WriteBuckets(s.pages, start, s.tableOptions(&next), pages) // пишем все страницы за один проход
+	if err != nil {
+		if start+len(pages)*s.bktOpts.Size() > s.endOffset {
+			if terr := s.pages.Truncate(int64(s.endOffset)); terr != nil { // отрезаем недописанные страницы
+				err = errors.Join(err, fmt.Errorf("truncate: %w", terr))
+			}
+		}
+		return fmt.Errorf("store - BulkLoad: %w", s.checkDiskFull(err))
+	}
+
+	next.globalDepth = globalDepth
+	next.dirList = make([]Directory, 1<<globalDepth)
+	for i, page := range pages {
+		if s.opts.Scheme == SchemeLinear { // страницы одного адреса образуют цепочку переполнения
+			if err = next.addLinearBucket(created[i], page.LocalDepth, page.Pattern); err != nil {
+				return fmt.Errorf("store - BulkLoad: %w", err)
+			}
+			continue
+		}
+		// каждая директория, у которой последние LocalDepth бит совпадают с Pattern, указывает на бакет
+		for index := page.Pattern; index < len(next.dirList); index += 1 << page.LocalDepth {
+			next.dirList[index] = Directory{
+				index:      index,
+				bucket:     created[i],
+				localDepth: page.LocalDepth,
+			}
+		}
+	}
+
+	prev, prevFree, prevEnd, prevIndex := s.table, s.free, s.endOffset, s.index
+	if err = s.switchToBulk(next, start, max(endOffset, s.endOffset)); err != nil {
+		// загрузка не зафиксирована: возвращаем прежнюю хэш-таблицу, а все страницы, дописанные за прежним концом файла,
+		// в памяти считаем свободными. На диске страницы новых бакетов освободит открытие бд
+		end := s.endOffset
+		s.table, s.free, s.index = prev, prevFree, prevIndex
+		for offset := prevEnd; offset < end; offset += s.bktOpts.Size() {
+			s.free = append(s.free, offset)
+		}
+		return fmt.Errorf("store - BulkLoad: %w", s.checkDiskFull(err))
+	}
+
+	// загрузка зафиксирована, страницы старых бакетов и старого индекса больше не нужны.
+	// Если освобождение прервется, страницы бакетов освободит следующее открытие бд
+	for _, offset := range append(bucketOffsets(prev.buckets()), oldIndexPages...) {
+		if err = s.freePage(offset); err != nil {
+			s.log.Warn("bulk load: free old page", zap.Int("offset", offset), zap.Error(err))
+			break
+		}
+	}
+
+	for i, kv := range loaded { // для реплик и подписчиков загрузка выглядит как последовательность записей
+		s.recordChange(Change{Op: OpPut, Key: kv.Key, Val: kv.Val}, olds[i])
+	}
+
+	if err = s.writeHeader(); err != nil { // номер последнего изменения
+		return fmt.Errorf("store - BulkLoad: %w", s.checkDiskFull(err))
+	}
+
+	s.log.Info("bulk load completed", zap.Int("records", len(records)), zap.Int("buckets", len(pages)), zap.Int("globalDepth", globalDepth))
+
+	return nil
+}
+
+// Функция выбора смещения для count новых бакетов массовой загрузки: первая цепочка подряд идущих
+// свободных страниц, которая вмещает все бакеты или доходит до конца файла, иначе конец файла.
+// Так повторные загрузки пишут на место прошлых бакетов и файл не растет с каждой загрузкой.
+func (s *Store) bulkStart(count int) int {
+	free := append([]int(nil), s.free...)
+	sort.Ints(free)
+
+	size := s.bktOpts.Size()
+	for i := 0; i < len(free); {
+		j := i + 1
+		for j < len(free) && free[j] == free[j-1]+size {
+			j++
+		}
+		if j-i >= count || free[j-1]+size == s.endOffset {
+			return free[i]
+		}
+		i = j
+	}
+
+	return s.endOffset
+}
+
+// Функция переключения основного пространства ключей на хэш-таблицу next, бакеты которой массовая загрузка
+// записала с offset start. Перед записью заголовка новые страницы сбрасываются на диск, после - сам заголовок:
+// запись заголовка с новым типом страниц бакетов и есть момент фиксации загрузки.
+func (s *Store) switchToBulk(next table, start, endOffset int) error {
+	end := start + len(next.buckets())*s.bktOpts.Size() // страницы новых бакетов больше не свободны
+	free := make([]int, 0, len(s.free))
+	for _, offset := range s.free {
+		if offset < start || offset >= end {
+			free = append(free, offset)
+		}
+	}
+	s.free = free
+	s.endOffset = endOffset
+	s.table = next
+	s.split = 0
+
+	if s.index != nil { // новый индекс пишется в свободные страницы, старый остается целым до фиксации
+		index, err := s.createIndex()
+		if err != nil {
+			return err
+		}
+		s.index = index
+	}
+
+	if err := s.pages.Sync(); err != nil {
+		return fmt.Errorf("switch to bulk - sync: %w", err)
+	}
+	if err := writeHeader(s.pages, s.header(), true); err != nil {
+		return fmt.Errorf("switch to bulk: %w", err)
+	}
+
+	return nil
+}
+
+// Функция получения смещений страниц бакетов
+func bucketOffsets(buckets []*bkt.Bucket) []int {
+	offsets := make([]int, len(buckets))
+	for i, bucket := range buckets {
+		offsets[i] = bucket.Offset()
+	}
+
+	return offsets
+}
+
+// Функция поиска всех страниц файла бд с типом kind
+func (s *Store) pagesOfKind(kind byte) ([]int, error) {
+	var offsets []int
+	for offset := s.opts.PageSize; offset < s.endOffset; offset += s.bktOpts.Size() {
+		pageKind, err := bkt.PageKind(s.pages, offset)
+		if err != nil {
+			return nil, err
+		}
+		if pageKind == kind {
+			offsets = append(offsets, offset)
+		}
+	}
+
+	return offsets, nil
+}
+
+// Функция сбора записей для массовой загрузки: сначала текущее содержимое хранилища, затем записи итератора
+// В loaded попадают записи итератора в исходном порядке.
+func (s *Store) collectBulkRecords(ctx context.Context, iter KVIterator, loaded *[]bkt.KV) ([]bulkRecord, error) {
+	positions := make(map[string]int)
+	records := make([]bulkRecord, 0)
+
+	add := func(kv bkt.KV) {
+		if pos, ok := positions[kv.Key]; ok { // повтор ключа - перезаписываем значение
+			records[pos].kv.Val = kv.Val
+			return
+		}
+		positions[kv.Key] = len(records)
+		records = append(records, bulkRecord{kv: kv, hash: getDirID(kv.Key, maxGlobalDepth)})
+	}
+
+	for _, bucket := range s.buckets() {
+		values, err := bucket.GetBucketValues()
+		if err != nil {
+			return nil, fmt.Errorf("collect bulk records: %w", err)
+		}
+		for _, kv := range values {
+			add(kv)
+		}
+	}
+
+	for kv, ok := iter.Next(); ok; kv, ok = iter.Next() {
+		if len(*loaded)%bulkCheckEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, fmt.Errorf("collect bulk records: %w", err)
+			}
+		}
+		*loaded = append(*loaded, kv)
+		if !s.opts.History {
+			add(kv)
+			continue
+		}
+
+		if err := s.checkVersionedKV(kv.Key, kv.Val); err != nil {
+			return nil, fmt.Errorf("collect bulk records: %w", err)
+		}
+		meta := versionMeta{time: time.Now().UnixNano()}
+		if pos, ok := positions[kv.Key]; ok { // каждая загружаемая пара становится новой версией ключа
+			prev, val, err := decodeVersion(records[pos].kv.Val)
+			if err != nil {
+				return nil, fmt.Errorf("collect bulk records: %w", err)
+			}
+			old := versionMeta{version: prev.version, time: prev.time, flags: prev.flags | versionHistory}
+			add(bkt.KV{Key: historyKey(kv.Key, prev.version), Val: encodeVersion(old, val)})
+			meta.version = prev.version
+		}
+		meta.version++
+		add(bkt.KV{Key: kv.Key, Val: encodeVersion(meta, kv.Val)})
+	}
+
+	if s.opts.History { // файл переписывается целиком - применяем политику хранения версий
+		kvs := make([]bkt.KV, len(records))
+		for i, r := range records {
+			kvs[i] = r.kv
+		}
+		kvs = s.retainHistory(kvs, func(string) (uint64, bool) { return 0, false })
+
+		kept := records[:0]
+		for _, kv := range kvs {
+			kept = append(kept, bulkRecord{kv: kv, hash: getDirID(kv.Key, maxGlobalDepth)})
+		}
+		records = kept
+	}
+
+	return records, nil
+}
+
+// Функция рекурсивного разбиения записей по битам хэша.
+// Если записи помещаются в бакет - он фиксируется с текущей локальной глубиной,
+// иначе записи делятся по следующему биту хэша (аналогично splitBucket).
+func (s *Store) partitionRecords(records []bulkRecord, pattern, depth int, pages *[]bkt.Page) error {
+	if depth >= s.opts.InitialGlobalDepth && len(records) <= s.bktOpts.Capacity() {
+		kvs := make([]bkt.KV, len(records))
+		for i, r := range records {
+			kvs[i] = r.kv
+		}
+		*pages = append(*pages, bkt.Page{Records: kvs, Pattern: pattern, LocalDepth: depth})
+		return nil
+	}
+
+	if depth >= maxGlobalDepth {
+		return ErrMaxDepthReached
+	}
+
+	var zeros, ones []bulkRecord
+	for _, r := range records {
+		if r.hash&(1<<depth) == 0 {
+			zeros = append(zeros, r)
+		} else {
+			ones = append(ones, r)
+		}
+	}
+
+	if err := s.partitionRecords(zeros, pattern, depth+1, pages); err != nil {
+		return err
+	}
+
+	return s.partitionRecords(ones, pattern|1<<depth, depth+1, pages)
+}
@@ -0,0 +1,140 @@
+package store
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	ErrChangesTruncated = errors.New("requested changes are no longer retained")
+	ErrChangesAhead     = errors.New("requested changes are ahead of the change log")
+)
+
+// OpType - тип изменения
+type OpType byte
+
+const (
+	OpPut            OpType = iota + 1 // запись значения
+	OpDelete                           // удаление значения
+	OpCreateKeyspace                   // создание пространства ключей
+	OpDropKeyspace                     // удаление пространства ключей
+)
+
+// Change - изменение хранилища с порядковым номером
+type Change struct {
+	Seq      uint64
+	Op       OpType
+	Keyspace string // пустое - основное пространство ключей
+	Key      string
+	Val      string
+}
+
+// Журнал последних изменений - кольцевой буфер фиксированного размера.
+// Читатели ждут новых изменений на канале notify, который закрывается и пересоздается при каждой записи.
+type changeLog struct {
+	mu      sync.Mutex
+	entries []Change
+	start   int // индекс самого старого изменения в entries
+	count   int
+	notify  chan struct{}
+}
+
+// Функция создания журнала изменений
+func newChangeLog(size int) *changeLog {
+	return &changeLog{
+		entries: make([]Change, size),
+		notify:  make(chan struct{}),
+	}
+}
+
+// Функция добавления изменения в журнал, самое старое изменение вытесняется
+func (l *changeLog) append(change Change) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.entries) == 0 {
+		return
+	}
+
+	end := (l.start + l.count) % len(l.entries)
+	l.entries[end] = change
+	if l.count < len(l.entries) {
+		l.count++
+	} else {
+		l.start = (l.start + 1) % len(l.entries)
+	}
+
+	close(l.notify) // будим ожидающих читателей
+	l.notify = make(chan struct{})
+}
+
+// Функция очистки журнала, ожидающие читатели просыпаются и получают ErrChangesTruncated
+func (l *changeLog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.start, l.count = 0, 0
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// Функция получения изменений с номером больше seq.
+// Вместе с изменениями возвращается канал, который закроется при появлении следующего изменения.
+func (l *changeLog) since(seq, lastSeq uint64) ([]Change, <-chan struct{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case seq == lastSeq: // новых изменений нет
+		return nil, l.notify, nil
+	case seq > lastSeq: // номер выдан не этим журналом, например до падения, которое не сохранило последние изменения
+		return nil, l.notify, ErrChangesAhead
+	}
+
+	if l.count == 0 || l.entries[l.start].Seq > seq+1 { // нужные изменения уже вытеснены из журнала
+		return nil, l.notify, ErrChangesTruncated
+	}
+
+	result := make([]Change, 0, lastSeq-seq)
+	for i := 0; i < l.count; i++ {
+		change := l.entries[(l.start+i)%len(l.entries)]
+		if change.Seq > seq {
+			result = append(result, change)
+		}
+	}
+
+	return result, l.notify, nil
+}
+
+// Функция регистрации изменения: присваивает номер, кладет в журнал и уведомляет подписчиков.
+// old - значение ключа до изменения. Вызывается под блокировкой хранилища.
+func (s *Store) recordChange(change Change, old oldValue) {
+	s.seq++
+	change.Seq = s.seq
+	s.changes.append(change)
+	s.publishChange(change, old)
+}
+
+// LastSeq - номер последнего изменения хранилища
+func (s *Store) LastSeq() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.seq
+}
+
+// ChangesSince - изменения с номером больше seq в порядке их применения.
+// Если часть изменений уже вытеснена из журнала, возвращается ErrChangesTruncated,
+// если seq больше номера последнего изменения - ErrChangesAhead.
+func (s *Store) ChangesSince(seq uint64) ([]Change, error) {
+	changes, _, err := s.changesSince(seq)
+	return changes, err
+}
+
+// Функция получения изменений вместе с каналом ожидания следующих
+func (s *Store) changesSince(seq uint64) ([]Change, <-chan struct{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.changes.since(seq, s.seq)
+}
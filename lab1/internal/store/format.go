@@ -0,0 +1,234 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"debildb/internal/pagestore"
+)
+
+// Версии формата файла бд. Версия пишется в заголовок и определяет раскладку бакетов;
+// читать и дописывать можно файлы любой поддерживаемой версии, migrate переводит файл в текущую.
+const (
+	formatLegacy = 0 // файлы первой версии хранилища без заголовка, открываются только миграцией (см. legacy.go)
+	formatV1     = 1 // исходная раскладка: заголовок бакета и записи фиксированного размера
+	formatV2     = 2 // в заголовке бакета хранятся отпечатки ключей
+
+	currentFormat = formatV2
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported database format")
+	ErrMigrationCheck    = errors.New("migration check failed")
+)
+
+// MigrateResult - итог миграции файла бд
+type MigrateResult struct {
+	FromFormat int // версия формата исходного файла
+	ToFormat   int // версия формата после миграции
+	Records    int // количество перенесенных записей, включая предыдущие версии значений
+}
+
+// Функция определения версии формата по заголовку.
+// В файлах, созданных до появления версии, байт версии нулевой: раскладку бакетов определяет флаг отпечатков.
+func (h header) resolveFormat() (int, error) {
+	switch {
+	case h.format == 0 && h.flags&headerFingerprints != 0:
+		return formatV2, nil
+	case h.format == 0:
+		return formatV1, nil
+	case h.format > currentFormat:
+		return 0, fmt.Errorf("%w: version %d, newest supported %d", ErrUnsupportedFormat, h.format, currentFormat)
+	}
+
+	return h.format, nil
+}
+
+// Migrate - переписывает бд src в текущем формате.
+// Если dst пустой или совпадает с src, файл обновляется на месте: новая бд собирается во временном файле рядом
+// и после проверки подменяет исходный; иначе результат пишется в dst, а src открывается только на чтение.
+// После переноса новая бд открывается заново и количество записей сверяется с исходным.
+// Файлы формата 0 без заголовка читаются отдельно, список директорий для них строится заново.
+// Из opts используются Backend, LockTimeout и Logger.
+func Migrate(src, dst string, opts Options) (MigrateResult, error) {
+	inPlace := dst == "" || dst == src
+	target := dst
+	if inPlace {
+		target = src + ".migrate"
+	}
+
+	info, err := os.Stat(src) // OpenStore создал бы пустую бд на месте отсутствующего файла
+	if err != nil {
+		return MigrateResult{}, fmt.Errorf("migrate: %w", err)
+	}
+	if info.Size() == 0 {
+		return MigrateResult{}, fmt.Errorf("migrate: %w: %s is empty", ErrInvalidHeader, src)
+	}
+
+	legacy, err := isLegacyFile(src)
+	if err != nil {
+		return MigrateResult{}, fmt.Errorf("migrate: %w", err)
+	}
+	if legacy { // OpenStore не откроет файл без заголовка, поэтому и блокировку исходного файла берем сами
+		file, err := pagestore.LockFile(src, pagestore.OpenOptions{ReadOnly: !inPlace, LockTimeout: opts.LockTimeout})
+		if err != nil {
+			return MigrateResult{}, fmt.Errorf("migrate: %w", err)
+		}
+		defer file.Close() // блокировка держится до подмены исходного файла
+
+		result := MigrateResult{FromFormat: formatLegacy, ToFormat: currentFormat}
+		if result.Records, err = migrateLegacy(file, target, opts); err != nil {
+			os.Remove(target)
+			return result, fmt.Errorf("migrate: %w", err)
+		}
+		if err = checkMigrated(target, result.Records, opts); err != nil {
+			os.Remove(target)
+			return result, fmt.Errorf("migrate: %w", err)
+		}
+		if inPlace {
+			if err = os.Rename(target, src); err != nil {
+				return result, fmt.Errorf("migrate: %w", err)
+			}
+		}
+		return result, nil
+	}
+
+	old, err := OpenStore(src, Options{ReadOnly: !inPlace, Backend: opts.Backend, LockTimeout: opts.LockTimeout, Logger: opts.Logger})
+	if err != nil {
+		return MigrateResult{}, fmt.Errorf("migrate: %w", err)
+	}
+	defer old.Close()
+
+	result := MigrateResult{FromFormat: old.opts.format, ToFormat: currentFormat}
+	if result.Records, err = old.recordCount(); err != nil {
+		return result, fmt.Errorf("migrate: %w", err)
+	}
+
+	if err = old.copyTo(target, opts); err != nil {
+		os.Remove(target)
+		return result, fmt.Errorf("migrate: %w", err)
+	}
+
+	if err = checkMigrated(target, result.Records, opts); err != nil {
+		os.Remove(target)
+		return result, fmt.Errorf("migrate: %w", err)
+	}
+
+	if inPlace { // исходный файл подменяется под его блокировкой, она снимается при закрытии old
+		if err = os.Rename(target, src); err != nil {
+			return result, fmt.Errorf("migrate: %w", err)
+		}
+	}
+
+	return result, nil
+}
+
+// Функция переноса всех записей хранилища в новую бд текущего формата по пути target.
+// Записи переносятся как есть, вместе с предыдущими версиями значений, политика хранения истории не применяется.
+func (s *Store) copyTo(target string, opts Options) error {
+	ns, err := NewStoreWithOptions(target, s.cloneOptions(opts))
+	if err != nil {
+		return err
+	}
+	defer ns.Close()
+
+	if err = s.copyTable(&s.table, ns, &ns.table); err != nil {
+		return err
+	}
+	for name, t := range s.keyspaces {
+		if err = ns.createKeyspace(name); err != nil {
+			return err
+		}
+		if err = s.copyTable(t, ns, ns.keyspaces[name]); err != nil {
+			return err
+		}
+	}
+
+	ns.seq = s.seq
+	if s.index != nil {
+		if err = ns.buildIndex(); err != nil {
+			return err
+		}
+	}
+
+	return ns.Sync()
+}
+
+// Функция переноса записей хэш-таблицы t в хэш-таблицу nt хранилища ns
+func (s *Store) copyTable(t *table, ns *Store, nt *table) error {
+	for _, bucket := range t.buckets() {
+		values, err := bucket.GetBucketValues()
+		if err != nil {
+			return err
+		}
+		for _, kv := range values {
+			if err = ns.putRecord(nt, kv.Key, kv.Val); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Функция получения параметров для новой бд текущего формата с теми же размерами и режимами, что у хранилища.
+// Новая бд заполняется без сброса на диск после каждой записи, сброс выполняется один раз в конце.
+func (s *Store) cloneOptions(opts Options) Options {
+	return Options{
+		PageSize:           s.opts.PageSize,
+		BucketPages:        s.opts.BucketPages,
+		InitialGlobalDepth: s.opts.InitialGlobalDepth,
+		MaxKeySize:         s.opts.MaxKeySize,
+		MaxValueSize:       s.opts.MaxValueSize,
+		SyncPolicy:         SyncNone,
+		Scheme:             s.opts.Scheme,
+		History:            s.opts.History,
+		Backend:            opts.Backend,
+		Logger:             opts.Logger,
+	}
+}
+
+// Функция проверки перенесенной бд: она открывается и количество записей сравнивается с исходным
+func checkMigrated(target string, records int, opts Options) error {
+	ns, err := OpenStore(target, Options{ReadOnly: true, Backend: opts.Backend, Logger: opts.Logger})
+	if err != nil {
+		return err
+	}
+	defer ns.Close()
+
+	if ns.opts.format != currentFormat {
+		return fmt.Errorf("%w: format %d", ErrMigrationCheck, ns.opts.format)
+	}
+
+	count, err := ns.recordCount()
+	if err != nil {
+		return err
+	}
+	if count != records {
+		return fmt.Errorf("%w: %d records, expected %d", ErrMigrationCheck, count, records)
+	}
+
+	return nil
+}
+
+// Функция подсчета записей во всех пространствах ключей, включая предыдущие версии значений
+func (s *Store) recordCount() (int, error) {
+	tables := []*table{&s.table}
+	for _, t := range s.keyspaces {
+		tables = append(tables, t)
+	}
+
+	count := 0
+	for _, t := range tables {
+		for _, bucket := range t.buckets() {
+			values, err := bucket.GetBucketValues()
+			if err != nil {
+				return 0, fmt.Errorf("record count: %w", err)
+			}
+			count += len(values)
+		}
+	}
+
+	return count, nil
+}
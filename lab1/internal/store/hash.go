@@ -2,17 +2,21 @@ package store
 
 import (
 	"crypto/sha256"
+	"encoding/binary"
 )
 
+// Максимальная глобальная глубина - для адресации используются последние 4 байта хэша
+const maxGlobalDepth = 32
+
 // Функция получения ID дирекотрии где должен быть элемент
 // Опрелеояется по count последним битам хэша
-func getDirID(key string, count int) byte {
+func getDirID(key string, count int) int {
 	hashBytes := hash(key)
 
-	lastByte := hashBytes[len(hashBytes)-1]	
-	mask := byte((1 << count) - 1) // считаем маску для выборки нужны битов
+	tail := binary.BigEndian.Uint32(hashBytes[len(hashBytes)-4:]) // последние 4 байта хэша, младшие биты - последний байт
+	mask := uint32((uint64(1) << count) - 1)                      // считаем маску для выборки нужны битов
 
-	return lastByte & mask // применяем маску
+	return int(tail & mask) // применяем маску
 }
 
 // Функция подсчета хэша ключа
@@ -22,3 +26,13 @@ func hash(key string) []byte {
 
 	return hasher.Sum(nil)
 }
+
+// Функция выбора шарда для ключа.
+// Используются первые 8 байт хэша, а не последние: по последним битам ключи адресуются внутри шарда,
+// и если бы шард выбирался по ним же, все ключи шарда попадали бы в одни и те же директории.
+// При удвоении количества шардов ключ шарда i переходит в шард i или i+n.
+func getShardID(key string, n int) int {
+	head := binary.BigEndian.Uint64(hash(key)[:8])
+
+	return int(head % uint64(n))
+}
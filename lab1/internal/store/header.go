@@ -0,0 +1,211 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"debildb/internal/pagestore"
+)
+
+const (
+	headerMagic = "DEBILDB\x00"
+	headerLen   = 48 // используемая часть заголовка, остаток первой страницы зарезервирован
+
+	catalogEntryLen = 7 // размер записи каталога пространств ключей без имени
+)
+
+// Флаги хранилища в заголовке
+const (
+	headerHistory      byte = 1 << iota // значения хранятся вместе с историей версий
+	headerLinear                        // бакеты адресуются линейным хэшированием
+	headerFingerprints                  // в заголовках бакетов хранятся отпечатки ключей; только для файлов без версии формата
+	headerAltBuckets                    // страницы бакетов основного пространства ключей имеют тип bkt.KindBucketAlt
+)
+
+var (
+	ErrInvalidHeader = errors.New("invalid database header")
+)
+
+// Заголовок файла бд, занимает первую страницу файла.
+// [0:8] magic, [8:12] размер страницы, [12:16] страниц в бакете,
+// [16:20] максимальный размер ключа, [20:24] максимальный размер значения,
+// [24] начальная глобальная глубина, [25] текущая глобальная глубина, [26] флаги хранилища, [27] версия формата,
+// [28:32] указатель расщепления (линейное хэширование),
+// [32:40] номер последнего изменения (для репликации), [40:48] смещение корня упорядоченного индекса (0 - индекса нет).
+// После используемой части до конца страницы лежит каталог именованных пространств ключей:
+// [2 байта] количество, затем для каждого [1 байт] id, [1 байт] глобальная глубина,
+// [4 байта] указатель расщепления, [1 байт] длина имени, имя.
+type header struct {
+	pageSize           int
+	bucketPages        int
+	maxKeySize         int
+	maxValueSize       int
+	initialGlobalDepth int
+	globalDepth        int
+	flags              byte
+	format             int
+	split              int
+	seq                uint64
+	indexRoot          int64
+	keyspaces          []keyspaceMeta
+}
+
+// Запись каталога пространств ключей
+type keyspaceMeta struct {
+	name        string
+	kind        byte // id пространства, он же тип страниц его бакетов
+	globalDepth int
+	split       int
+}
+
+// Функция формирования заголовка из параметров хранилища
+func newHeader(opts Options, globalDepth int, seq uint64, indexRoot int64) header {
+	return header{
+		pageSize:           opts.PageSize,
+		bucketPages:        opts.BucketPages,
+		maxKeySize:         opts.MaxKeySize,
+		maxValueSize:       opts.MaxValueSize,
+		initialGlobalDepth: opts.InitialGlobalDepth,
+		globalDepth:        globalDepth,
+		flags:              opts.headerFlags(),
+		format:             opts.format,
+		seq:                seq,
+		indexRoot:          indexRoot,
+	}
+}
+
+// Функция сериализации заголовка в страницу
+func (h header) marshal() []byte {
+	data := make([]byte, h.pageSize)
+	copy(data[0:8], headerMagic)
+	binary.LittleEndian.PutUint32(data[8:12], uint32(h.pageSize))
+	binary.LittleEndian.PutUint32(data[12:16], uint32(h.bucketPages))
+	binary.LittleEndian.PutUint32(data[16:20], uint32(h.maxKeySize))
+	binary.LittleEndian.PutUint32(data[20:24], uint32(h.maxValueSize))
+	data[24] = byte(h.initialGlobalDepth)
+	data[25] = byte(h.globalDepth)
+	data[26] = h.flags
+	data[27] = byte(h.format)
+	binary.LittleEndian.PutUint32(data[28:32], uint32(h.split))
+	binary.LittleEndian.PutUint64(data[32:40], h.seq)
+	binary.LittleEndian.PutUint64(data[40:48], uint64(h.indexRoot))
+
+	pos := headerLen
+	binary.LittleEndian.PutUint16(data[pos:], uint16(len(h.keyspaces)))
+	pos += 2
+	for _, ks := range h.keyspaces {
+		data[pos], data[pos+1] = ks.kind, byte(ks.globalDepth)
+		binary.LittleEndian.PutUint32(data[pos+2:], uint32(ks.split))
+		data[pos+6] = byte(len(ks.name))
+		pos += catalogEntryLen
+		pos += copy(data[pos:], ks.name)
+	}
+
+	return data
+}
+
+// Функция расчета размера каталога пространств ключей в заголовке
+func catalogLen(keyspaces []keyspaceMeta) int {
+	size := 2
+	for _, ks := range keyspaces {
+		size += catalogEntryLen + len(ks.name)
+	}
+	return size
+}
+
+// Функция десериализации заголовка
+func unmarshalHeader(data []byte) (header, error) {
+	if len(data) < headerLen || string(data[0:8]) != headerMagic {
+		return header{}, ErrInvalidHeader
+	}
+
+	keyspaces, err := unmarshalCatalog(data[headerLen:])
+	if err != nil {
+		return header{}, err
+	}
+
+	return header{
+		pageSize:           int(binary.LittleEndian.Uint32(data[8:12])),
+		bucketPages:        int(binary.LittleEndian.Uint32(data[12:16])),
+		maxKeySize:         int(binary.LittleEndian.Uint32(data[16:20])),
+		maxValueSize:       int(binary.LittleEndian.Uint32(data[20:24])),
+		initialGlobalDepth: int(data[24]),
+		globalDepth:        int(data[25]),
+		flags:              data[26],
+		format:             int(data[27]),
+		split:              int(binary.LittleEndian.Uint32(data[28:32])),
+		seq:                binary.LittleEndian.Uint64(data[32:40]),
+		indexRoot:          int64(binary.LittleEndian.Uint64(data[40:48])),
+		keyspaces:          keyspaces,
+	}, nil
+}
+
+// Функция десериализации каталога пространств ключей, пустые данные - каталог пуст
+func unmarshalCatalog(data []byte) ([]keyspaceMeta, error) {
+	if len(data) < 2 {
+		return nil, nil
+	}
+
+	count := int(binary.LittleEndian.Uint16(data))
+	keyspaces := make([]keyspaceMeta, 0, count)
+	pos := 2
+	for i := 0; i < count; i++ {
+		if pos+catalogEntryLen > len(data) || pos+catalogEntryLen+int(data[pos+6]) > len(data) {
+			return nil, fmt.Errorf("%w: keyspace catalog", ErrInvalidHeader)
+		}
+		nameLen := int(data[pos+6])
+		keyspaces = append(keyspaces, keyspaceMeta{
+			kind:        data[pos],
+			globalDepth: int(data[pos+1]),
+			split:       int(binary.LittleEndian.Uint32(data[pos+2:])),
+			name:        string(data[pos+catalogEntryLen : pos+catalogEntryLen+nameLen]),
+		})
+		pos += catalogEntryLen + nameLen
+	}
+
+	return keyspaces, nil
+}
+
+// Функция чтения заголовка из файла бд
+func readHeader(pages pagestore.PageStore) (header, error) {
+	data := make([]byte, headerLen)
+	if _, err := pages.ReadAt(data, 0); err != nil {
+		if errors.Is(err, io.EOF) {
+			return header{}, ErrInvalidHeader
+		}
+		return header{}, fmt.Errorf("read header - read at: %w", err)
+	}
+
+	h, err := unmarshalHeader(data)
+	if err != nil {
+		return header{}, err
+	}
+
+	if h.pageSize < headerLen {
+		return header{}, fmt.Errorf("%w: page size %d", ErrInvalidHeader, h.pageSize)
+	}
+
+	data = make([]byte, h.pageSize) // каталог пространств ключей занимает остаток первой страницы
+	if _, err = pages.ReadAt(data, 0); err != nil {
+		return header{}, fmt.Errorf("read header - read at: %w", err)
+	}
+
+	return unmarshalHeader(data)
+}
+
+// Функция записи заголовка в начало файла бд
+func writeHeader(pages pagestore.PageStore, h header, sync bool) error {
+	if _, err := pages.WriteAt(h.marshal(), 0); err != nil {
+		return fmt.Errorf("write header - write at: %w", err)
+	}
+
+	if sync {
+		if err := pages.Sync(); err != nil {
+			return fmt.Errorf("write header - sync: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,326 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	bkt "debildb/internal/bucket"
+	"debildb/internal/parser"
+)
+
+// В режиме истории каждое значение хранится вместе с метаданными версии:
+// [0:8] номер версии, [8:16] время записи (unix nano), [16] флаги, далее само значение.
+// Текущая версия лежит под ключом key, предыдущие - под ключами historyKey(key, version).
+const (
+	versionMetaLen = 17
+	historyKeyLen  = 9 // префикс ключа предыдущей версии: [0] historyKeyPrefix, [1:9] номер версии
+
+	historyKeyPrefix = "\x00" // ключи с этим префиксом зарезервированы под предыдущие версии
+)
+
+// Флаги версии
+const (
+	versionDeleted byte = 1 << iota // надгробие: ключ удален этой версией
+	versionHistory                  // запись хранит предыдущую версию ключа
+)
+
+var (
+	ErrHistoryDisabled = errors.New("version history is not enabled")
+	ErrVersionNotFound = errors.New("version not found")
+	ErrReservedKey     = errors.New("key prefix is reserved for version history")
+	ErrInvalidVersion  = errors.New("invalid versioned value")
+)
+
+// Retention - политика хранения предыдущих версий.
+// Версия удаляется, если нарушает хотя бы одно из заданных ограничений.
+// Политика применяется, когда бакет переписывается (сплит, BulkLoad), до этого лишние версии остаются доступны.
+// Текущая версия ключа (в том числе надгробие) не удаляется никогда.
+type Retention struct {
+	MaxVersions int           // сколько последних предыдущих версий хранить, 0 - без ограничения
+	MaxAge      time.Duration // версии старше этого возраста удаляются, 0 - без ограничения
+}
+
+// Version - версия значения ключа
+type Version struct {
+	Version uint64
+	Time    time.Time // время записи версии
+	Value   string
+	Deleted bool // версия удалила ключ, Value пустое
+}
+
+// Метаданные версии, которые хранятся перед значением
+type versionMeta struct {
+	version uint64
+	time    int64
+	flags   byte
+}
+
+// GetAt - значение ключа в версии version.
+// Если в этой версии ключ был удален, возвращается bkt.ErrKeyNotFound.
+func (s *Store) GetAt(key string, version uint64) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.opts.History {
+		return "", fmt.Errorf("store - GetAt: %w", ErrHistoryDisabled)
+	}
+
+	meta, val, err := s.getVersion(key)
+	if err != nil {
+		return "", fmt.Errorf("store - GetAt: %w", err)
+	}
+	if version != meta.version {
+		if version > meta.version {
+			return "", fmt.Errorf("store - GetAt %q@%d: %w", key, version, ErrVersionNotFound)
+		}
+		if meta, val, err = s.getVersion(historyKey(key, version)); err != nil {
+			if errors.Is(err, bkt.ErrKeyNotFound) {
+				err = ErrVersionNotFound
+			}
+			return "", fmt.Errorf("store - GetAt %q@%d: %w", key, version, err)
+		}
+	}
+	if meta.flags&versionDeleted != 0 {
+		return "", fmt.Errorf("store - GetAt %q@%d: %w", key, version, bkt.ErrKeyNotFound)
+	}
+
+	return val, nil
+}
+
+// History - все сохраненные версии ключа от старых к новым, последняя - текущая
+func (s *Store) History(key string) ([]Version, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.opts.History {
+		return nil, fmt.Errorf("store - History: %w", ErrHistoryDisabled)
+	}
+
+	meta, val, err := s.getVersion(key)
+	if err != nil {
+		return nil, fmt.Errorf("store - History: %w", err)
+	}
+
+	history := []Version{newVersion(meta, val)}
+	for v := meta.version - 1; v > 0; v-- { // версии удаляются политикой от старых к новым, поэтому первая пропущенная - конец истории
+		meta, val, err := s.getVersion(historyKey(key, v))
+		if errors.Is(err, bkt.ErrKeyNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("store - History: %w", err)
+		}
+		history = append(history, newVersion(meta, val))
+	}
+
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+
+	return history, nil
+}
+
+// Функция получения текущего значения ключа без блокировки хранилища, с учетом режима истории
+func (s *Store) getValue(key string) (string, error) {
+	if !s.opts.History {
+		return s.getRaw(key)
+	}
+
+	meta, val, err := s.getVersion(key)
+	if err != nil {
+		return "", err
+	}
+	if meta.flags&(versionDeleted|versionHistory) != 0 { // удаленный ключ или ключ предыдущей версии
+		return "", fmt.Errorf("store get value: %w", bkt.ErrKeyNotFound)
+	}
+
+	return val, nil
+}
+
+// Функция чтения записи с метаданными версии
+func (s *Store) getVersion(key string) (versionMeta, string, error) {
+	raw, err := s.getRaw(key)
+	if err != nil {
+		return versionMeta{}, "", err
+	}
+
+	return decodeVersion(raw)
+}
+
+// Функция записи новой версии: текущая версия переносится в историю, новая получает следующий номер
+func (s *Store) putVersion(ctx context.Context, key, value string) error {
+	return s.writeVersion(ctx, key, value, 0)
+}
+
+// Функция удаления в режиме истории: текущая версия переносится в историю, вместо нее пишется надгробие
+func (s *Store) deleteVersion(ctx context.Context, key string) error {
+	return s.writeVersion(ctx, key, "", versionDeleted)
+}
+
+// Функция записи новой версии ключа с флагами flags.
+// Отменить можно только первую из записей: после того как предыдущая версия перенесена в историю,
+// текущая версия дописывается без отмены.
+func (s *Store) writeVersion(ctx context.Context, key, value string, flags byte) error {
+	if err := s.checkVersionedKV(key, value); err != nil {
+		return err
+	}
+
+	meta, prev, err := s.getVersion(key)
+	switch {
+	case errors.Is(err, bkt.ErrKeyNotFound):
+		if flags&versionDeleted != 0 {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		if flags&versionDeleted != 0 && meta.flags&versionDeleted != 0 { // ключ уже удален
+			return fmt.Errorf("store delete value: %w", bkt.ErrKeyNotFound)
+		}
+
+		// сначала сохраняем предыдущую версию, что бы при сбое между записями она не потерялась
+		old := versionMeta{version: meta.version, time: meta.time, flags: meta.flags | versionHistory}
+		if err = s.setValue(ctx, historyKey(key, meta.version), encodeVersion(old, prev)); err != nil {
+			return err
+		}
+		ctx = context.Background()
+	}
+
+	cur := versionMeta{version: meta.version + 1, time: time.Now().UnixNano(), flags: flags}
+
+	return s.setValue(ctx, key, encodeVersion(cur, value))
+}
+
+// Функция проверки, что ключ и значение помещаются в запись вместе с метаданными версии
+func (s *Store) checkVersionedKV(key, value string) error {
+	switch {
+	case strings.HasPrefix(key, historyKeyPrefix):
+		return fmt.Errorf("%w: %q", ErrReservedKey, key)
+	case len(key)+historyKeyLen > s.opts.MaxKeySize:
+		return parser.ErrKeyTooLong
+	case len(value)+versionMetaLen > s.opts.MaxValueSize:
+		return parser.ErrValueTooLong
+	}
+
+	return nil
+}
+
+// Функция применения политики хранения к записям переписываемого бакета.
+// current возвращает номер текущей версии ключа, если его нет среди records.
+func (s *Store) retainHistory(records []bkt.KV, current func(key string) (uint64, bool)) []bkt.KV {
+	policy := s.opts.HistoryRetention
+	if policy.MaxVersions == 0 && policy.MaxAge == 0 {
+		return records
+	}
+
+	versions := make(map[string]uint64)
+	for _, kv := range records {
+		if meta, _, err := decodeVersion(kv.Val); err == nil && meta.flags&versionHistory == 0 {
+			versions[kv.Key] = meta.version
+		}
+	}
+
+	now := time.Now()
+	kept := records[:0]
+	for _, kv := range records {
+		meta, _, err := decodeVersion(kv.Val)
+		if err != nil || meta.flags&versionHistory == 0 { // текущие версии не трогаем
+			kept = append(kept, kv)
+			continue
+		}
+
+		if policy.MaxAge > 0 && now.Sub(time.Unix(0, meta.time)) > policy.MaxAge {
+			continue
+		}
+		if policy.MaxVersions > 0 {
+			key, _ := parseHistoryKey(kv.Key)
+			cur, ok := versions[key]
+			if !ok {
+				cur, ok = current(key)
+			}
+			if ok && meta.version+uint64(policy.MaxVersions) < cur {
+				continue
+			}
+		}
+
+		kept = append(kept, kv)
+	}
+
+	return kept
+}
+
+// Функция получения номера текущей версии ключа через хэш-таблицу
+func (s *Store) currentVersion(key string) (uint64, bool) {
+	meta, _, err := s.getVersion(key)
+	if err != nil {
+		return 0, false
+	}
+
+	return meta.version, true
+}
+
+// Функция проверки, что запись хранит текущее значение пользовательского ключа, а не предыдущую версию
+func (s *Store) isCurrentRecord(kv bkt.KV) bool {
+	if !s.opts.History {
+		return true
+	}
+
+	return !strings.HasPrefix(kv.Key, historyKeyPrefix)
+}
+
+// Функция формирования ключа предыдущей версии
+func historyKey(key string, version uint64) string {
+	var buf [historyKeyLen]byte
+	buf[0] = historyKeyPrefix[0]
+	binary.BigEndian.PutUint64(buf[1:], version)
+
+	return string(buf[:]) + key
+}
+
+// Функция разбора ключа предыдущей версии
+func parseHistoryKey(hkey string) (string, uint64) {
+	if len(hkey) < historyKeyLen || !strings.HasPrefix(hkey, historyKeyPrefix) {
+		return hkey, 0
+	}
+
+	return hkey[historyKeyLen:], binary.BigEndian.Uint64([]byte(hkey[1:historyKeyLen]))
+}
+
+// Функция сериализации значения вместе с метаданными версии
+func encodeVersion(meta versionMeta, value string) string {
+	data := make([]byte, versionMetaLen, versionMetaLen+len(value))
+	binary.BigEndian.PutUint64(data[0:8], meta.version)
+	binary.BigEndian.PutUint64(data[8:16], uint64(meta.time))
+	data[16] = meta.flags
+
+	return string(append(data, value...))
+}
+
+// Функция разбора значения с метаданными версии
+func decodeVersion(raw string) (versionMeta, string, error) {
+	if len(raw) < versionMetaLen {
+		return versionMeta{}, "", fmt.Errorf("%w: %d bytes", ErrInvalidVersion, len(raw))
+	}
+
+	meta := versionMeta{
+		version: binary.BigEndian.Uint64([]byte(raw[0:8])),
+		time:    int64(binary.BigEndian.Uint64([]byte(raw[8:16]))),
+		flags:   raw[16],
+	}
+
+	return meta, raw[versionMetaLen:], nil
+}
+
+// Функция преобразования метаданных в публичную версию
+func newVersion(meta versionMeta, value string) Version {
+	return Version{
+		Version: meta.version,
+		Time:    time.Unix(0, meta.time),
+		Value:   value,
+		Deleted: meta.flags&versionDeleted != 0,
+	}
+}
@@ -0,0 +1,202 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"debildb/internal/btree"
+	bkt "debildb/internal/bucket"
+)
+
+var (
+	ErrNoIndex = errors.New("ordered index is not enabled")
+)
+
+// RangeIterator - итератор по парам ключ-значение в порядке возрастания ключей.
+// Набор ключей фиксируется при создании итератора, значения читаются по мере обхода;
+// ключи, удаленные после создания итератора, пропускаются.
+type RangeIterator struct {
+	s    *Store
+	keys []string
+	pos  int
+	err  error
+}
+
+// Next - возвращает следующую пару, false - когда пары закончились или произошла ошибка
+func (it *RangeIterator) Next() (bkt.KV, bool) {
+	for it.err == nil && it.pos < len(it.keys) {
+		key := it.keys[it.pos]
+		it.pos++
+
+		val, err := it.s.GetValue(key)
+		if errors.Is(err, bkt.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			it.err = err
+			break
+		}
+
+		return bkt.KV{Key: key, Val: val}, true
+	}
+
+	return bkt.KV{}, false
+}
+
+// Err - ошибка, на которой остановился обход
+func (it *RangeIterator) Err() error {
+	return it.err
+}
+
+// Range - пары с ключами из полуинтервала [start, end) в порядке возрастания.
+// Пустой end означает обход до последнего ключа.
+func (s *Store) Range(start, end string) (*RangeIterator, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.index == nil {
+		return nil, fmt.Errorf("store - Range: %w", ErrNoIndex)
+	}
+
+	var keys []string
+	err := s.index.Scan(start, func(key string) bool {
+		if end != "" && key >= end {
+			return false
+		}
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("store - Range: %w", err)
+	}
+
+	return &RangeIterator{s: s, keys: keys}, nil
+}
+
+// Prefix - пары с ключами, начинающимися с prefix, в порядке возрастания
+func (s *Store) Prefix(prefix string) (*RangeIterator, error) {
+	return s.Range(prefix, prefixEnd(prefix))
+}
+
+// Функция получения наименьшей строки, большей всех строк с префиксом prefix. Пустая строка - такой нет.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+
+	return ""
+}
+
+// Функция открытия индекса, уже записанного в файл
+func (s *Store) openIndex(root int64) *btree.Tree {
+	return btree.Open(s.pages, s.bktOpts.Size(), s.bktOpts.Layout, s.allocPage, s.bktOpts.Sync, root)
+}
+
+// Функция построения индекса по всем ключам хэш-таблицы. Страницы старого индекса, если он был, больше не используются.
+func (s *Store) buildIndex() error {
+	index, err := s.createIndex()
+	if err != nil {
+		return err
+	}
+
+	s.index = index
+	if err = s.writeHeader(); err != nil { // корень индекса хранится в заголовке
+		return fmt.Errorf("build index: %w", err)
+	}
+
+	return nil
+}
+
+// Функция записи нового индекса по всем ключам хэш-таблицы, корень в заголовке не сохраняется
+func (s *Store) createIndex() (*btree.Tree, error) {
+	index, err := btree.Create(s.pages, s.bktOpts.Size(), s.bktOpts.Layout, s.allocPage, false)
+	if err != nil {
+		return nil, fmt.Errorf("build index: %w", err)
+	}
+
+	var keys []string
+	for _, bucket := range s.buckets() {
+		values, err := bucket.GetBucketValues()
+		if err != nil {
+			return nil, fmt.Errorf("build index: %w", err)
+		}
+		for _, kv := range values {
+			if s.isCurrentRecord(kv) { // предыдущие версии в индекс не попадают
+				keys = append(keys, kv.Key)
+			}
+		}
+	}
+
+	sort.Strings(keys) // вставка по порядку заполняет листья последовательно
+	for _, key := range keys {
+		if err = index.Insert(key); err != nil {
+			return nil, fmt.Errorf("build index: %w", err)
+		}
+	}
+
+	return s.openIndex(index.Root()), nil
+}
+
+// Функция добавления ключа в индекс, если он ведется
+func (s *Store) indexInsert(key string) error {
+	if s.index == nil {
+		return nil
+	}
+
+	root := s.index.Root()
+	if err := s.index.Insert(key); err != nil {
+		return fmt.Errorf("index insert: %w", err)
+	}
+
+	return s.saveIndexRoot(root)
+}
+
+// Функция удаления ключа из индекса, если он ведется
+func (s *Store) indexDelete(key string) error {
+	if s.index == nil {
+		return nil
+	}
+
+	if err := s.index.Delete(key); err != nil {
+		return fmt.Errorf("index delete: %w", err)
+	}
+
+	return nil
+}
+
+// Функция сохранения корня индекса в заголовке, если он изменился
+func (s *Store) saveIndexRoot(oldRoot int64) error {
+	if s.index.Root() == oldRoot {
+		return nil
+	}
+
+	return s.writeHeader()
+}
+
+// Функция смещения корня индекса для заголовка, 0 - индекс не ведется
+func (s *Store) indexRoot() int64 {
+	if s.index == nil {
+		return 0
+	}
+
+	return s.index.Root()
+}
+
+// Функция выделения страницы размером с бакет: сначала занимаются освобожденные страницы, затем конец файла бд
+func (s *Store) allocPage() (int64, error) {
+	if n := len(s.free); n > 0 {
+		offset := s.free[n-1]
+		s.free = s.free[:n-1]
+		return int64(offset), nil
+	}
+
+	offset := s.endOffset
+	s.endOffset += s.bktOpts.Size()
+
+	return int64(offset), nil
+}
@@ -0,0 +1,366 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	bkt "debildb/internal/bucket"
+)
+
+const (
+	maxKeyspaceName = 64
+)
+
+var (
+	ErrKeyspaceExists   = errors.New("keyspace already exists")
+	ErrKeyspaceNotFound = errors.New("keyspace not found")
+	ErrInvalidKeyspace  = errors.New("invalid keyspace name")
+	ErrTooManyKeyspaces = errors.New("too many keyspaces")
+)
+
+// Хэш-таблица пространства ключей: своя директория и глобальная глубина поверх общих страниц файла бд
+type table struct {
+	name        string // имя пространства ключей, пустое - основное
+	kind        byte   // тип страниц бакетов таблицы
+	dirList     []Directory
+	globalDepth int
+	split       int // указатель расщепления, только для линейного хэширования
+}
+
+// Keyspace - именованное пространство ключей в том же файле бд.
+// У каждого пространства своя хэш-таблица, страницы выделяются из общего файла.
+// Упорядоченный индекс, история версий и подписки Watch ведутся только для основного пространства ключей,
+// изменения именованных пространств попадают в журнал изменений и реплицируются.
+type Keyspace struct {
+	s    *Store
+	name string
+}
+
+// Keyspace - пространство ключей с именем name. Существование пространства проверяется при каждой операции.
+func (s *Store) Keyspace(name string) *Keyspace {
+	return &Keyspace{s: s, name: name}
+}
+
+// CreateKeyspace - создает пустое пространство ключей
+func (s *Store) CreateKeyspace(name string) error {
+	if err := s.writable(); err != nil {
+		return fmt.Errorf("store - CreateKeyspace %q: %w", name, err)
+	}
+
+	if err := s.lockContext(context.Background()); err != nil {
+		return fmt.Errorf("store - CreateKeyspace %q: %w", name, err)
+	}
+	err := s.createKeyspace(name)
+	if err == nil {
+		s.recordChange(Change{Op: OpCreateKeyspace, Keyspace: name}, oldValue{})
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("store - CreateKeyspace %q: %w", name, err)
+	}
+
+	return s.commit()
+}
+
+// DropKeyspace - удаляет пространство ключей вместе со всеми значениями, его страницы освобождаются
+func (s *Store) DropKeyspace(name string) error {
+	if err := s.writable(); err != nil {
+		return fmt.Errorf("store - DropKeyspace %q: %w", name, err)
+	}
+
+	if err := s.lockContext(context.Background()); err != nil {
+		return fmt.Errorf("store - DropKeyspace %q: %w", name, err)
+	}
+	err := s.dropKeyspace(name)
+	if err == nil {
+		s.recordChange(Change{Op: OpDropKeyspace, Keyspace: name}, oldValue{})
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("store - DropKeyspace %q: %w", name, err)
+	}
+
+	return s.commit()
+}
+
+// Keyspaces - имена именованных пространств ключей по возрастанию
+func (s *Store) Keyspaces() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.keyspaces))
+	for name := range s.keyspaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Name - имя пространства ключей
+func (k *Keyspace) Name() string {
+	return k.name
+}
+
+// SetValue - записывает значение в пространство ключей
+func (k *Keyspace) SetValue(key, value string) error {
+	return k.SetValueContext(context.Background(), key, value)
+}
+
+// SetValueContext - запись значения в пространство ключей с отменой и дедлайном из ctx, как у Store.SetValueContext
+func (k *Keyspace) SetValueContext(ctx context.Context, key, value string) error {
+	return k.write(ctx, Change{Op: OpPut, Keyspace: k.name, Key: key, Val: value})
+}
+
+// DeleteValue - удаляет значение из пространства ключей
+func (k *Keyspace) DeleteValue(key string) error {
+	return k.DeleteValueContext(context.Background(), key)
+}
+
+// DeleteValueContext - удаление значения из пространства ключей с отменой и дедлайном из ctx
+func (k *Keyspace) DeleteValueContext(ctx context.Context, key string) error {
+	return k.write(ctx, Change{Op: OpDelete, Keyspace: k.name, Key: key})
+}
+
+// GetValue - значение ключа в пространстве ключей
+func (k *Keyspace) GetValue(key string) (string, error) {
+	return k.GetValueContext(context.Background(), key)
+}
+
+// GetValueContext - значение ключа в пространстве ключей с отменой и дедлайном из ctx
+func (k *Keyspace) GetValueContext(ctx context.Context, key string) (val string, err error) {
+	defer k.s.observeGet(time.Now(), k.name, key, &err)
+
+	if err := k.s.rlockContext(ctx); err != nil {
+		return "", fmt.Errorf("keyspace %q - GetValue: %w", k.name, err)
+	}
+	defer k.s.mu.RUnlock()
+
+	t, err := k.s.keyspaceTable(k.name)
+	if err != nil {
+		return "", fmt.Errorf("keyspace %q - GetValue: %w", k.name, err)
+	}
+
+	val, err = k.s.getRecord(t, key)
+	if err != nil {
+		return "", fmt.Errorf("keyspace %q - GetValue: %w", k.name, err)
+	}
+
+	return val, nil
+}
+
+// Функция записи изменения в пространство ключей
+func (k *Keyspace) write(ctx context.Context, change Change) (err error) {
+	op := "set"
+	if change.Op == OpDelete {
+		op = "delete"
+	}
+	defer k.s.observeWrite(time.Now(), op, change, &err)
+
+	if err := k.s.writable(); err != nil {
+		return fmt.Errorf("keyspace %q: %w", k.name, err)
+	}
+
+	if err := k.s.lockContext(ctx); err != nil {
+		return fmt.Errorf("keyspace %q: %w", k.name, err)
+	}
+	err = k.s.applyKeyspaceChange(ctx, change)
+	if err == nil {
+		k.s.recordChange(change, oldValue{})
+	}
+	k.s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("keyspace %q: %w", k.name, err)
+	}
+
+	return k.s.commit()
+}
+
+// Функция применения изменения именованного пространства ключей без блокировки хранилища
+func (s *Store) applyKeyspaceChange(ctx context.Context, change Change) error {
+	switch change.Op {
+	case OpCreateKeyspace:
+		return s.createKeyspace(change.Keyspace)
+	case OpDropKeyspace:
+		return s.dropKeyspace(change.Keyspace)
+	}
+
+	t, err := s.keyspaceTable(change.Keyspace)
+	if err != nil {
+		return err
+	}
+
+	switch change.Op {
+	case OpPut:
+		pages, err := s.putPages(t, change.Key)
+		if err != nil {
+			return err
+		}
+		if err = s.reserve(pages); err != nil {
+			return err
+		}
+		return s.checkDiskFull(s.putRecordContext(ctx, t, change.Key, change.Val))
+	case OpDelete:
+		if err = s.deleteRecord(t, change.Key); err != nil {
+			return fmt.Errorf("delete value: %w", err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%w: op %d", ErrInvalidChange, change.Op)
+}
+
+// Функция создания пространства ключей без блокировки хранилища
+func (s *Store) createKeyspace(name string) error {
+	if name == "" || len(name) > maxKeyspaceName {
+		return fmt.Errorf("%w: %q", ErrInvalidKeyspace, name)
+	}
+	if _, ok := s.keyspaces[name]; ok {
+		return ErrKeyspaceExists
+	}
+
+	kind, err := s.nextKeyspaceKind()
+	if err != nil {
+		return err
+	}
+	if headerLen+catalogLen(append(s.keyspaceMetas(), keyspaceMeta{name: name})) > s.opts.PageSize { // каталог должен поместиться в заголовок
+		return fmt.Errorf("%w: catalog does not fit into header page", ErrTooManyKeyspaces)
+	}
+
+	if err = s.reserve(1 << s.opts.InitialGlobalDepth); err != nil { // по бакету на каждую директорию
+		return err
+	}
+
+	t := &table{name: name, kind: kind}
+	if err = s.initDirectoryList(t); err != nil {
+		// пространства нет в каталоге, бакеты, созданные до ошибки, никому не принадлежат
+		if ferr := s.freeTable(t); ferr != nil {
+			err = errors.Join(err, ferr)
+		}
+		return s.checkDiskFull(err)
+	}
+	s.keyspaces[name] = t
+
+	if err = s.writeHeader(); err != nil {
+		delete(s.keyspaces, name)
+		return err
+	}
+
+	return nil
+}
+
+// Функция удаления пространства ключей без блокировки хранилища.
+// Сначала пространство убирается из каталога, затем его страницы помечаются свободными:
+// если сбой произойдет между этими шагами, страницы без владельца освободятся при следующем открытии.
+func (s *Store) dropKeyspace(name string) error {
+	t, err := s.keyspaceTable(name)
+	if err != nil {
+		return err
+	}
+
+	delete(s.keyspaces, name)
+	if err = s.writeHeader(); err != nil {
+		s.keyspaces[name] = t
+		return err
+	}
+
+	return s.freeTable(t)
+}
+
+// Функция освобождения всех бакетов хэш-таблицы
+func (s *Store) freeTable(t *table) error {
+	for _, bucket := range t.buckets() {
+		if err := s.freePage(bucket.Offset()); err != nil {
+			return err
+		}
+	}
+	t.dirList = nil
+
+	return nil
+}
+
+// Функция получения хэш-таблицы пространства ключей по имени
+func (s *Store) keyspaceTable(name string) (*table, error) {
+	t, ok := s.keyspaces[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrKeyspaceNotFound, name)
+	}
+
+	return t, nil
+}
+
+// Функция выбора id для нового пространства ключей: наименьший свободный
+func (s *Store) nextKeyspaceKind() (byte, error) {
+	used := make(map[byte]bool, len(s.keyspaces))
+	for _, t := range s.keyspaces {
+		used[t.kind] = true
+	}
+
+	for kind := bkt.KindKeyspace; kind < bkt.KindBucketAlt; kind++ {
+		if !used[kind] {
+			return kind, nil
+		}
+	}
+
+	return 0, ErrTooManyKeyspaces
+}
+
+// Функция формирования каталога пространств ключей для заголовка
+func (s *Store) keyspaceMetas() []keyspaceMeta {
+	metas := make([]keyspaceMeta, 0, len(s.keyspaces))
+	for name, t := range s.keyspaces {
+		metas = append(metas, keyspaceMeta{name: name, kind: t.kind, globalDepth: t.globalDepth, split: t.split})
+	}
+	sort.Slice(metas, func(i, j int) bool { return metas[i].kind < metas[j].kind })
+
+	return metas
+}
+
+// Функция восстановления пространств ключей из каталога заголовка, директории заполняются при загрузке бакетов
+func (s *Store) loadKeyspaces(metas []keyspaceMeta) error {
+	for _, meta := range metas {
+		if meta.kind < bkt.KindKeyspace || meta.kind >= bkt.KindBucketAlt || meta.globalDepth > maxGlobalDepth || meta.split >= 1<<meta.globalDepth {
+			return fmt.Errorf("%w: keyspace %q", ErrInvalidHeader, meta.name)
+		}
+		s.keyspaces[meta.name] = &table{name: meta.name, kind: meta.kind, globalDepth: meta.globalDepth, split: meta.split}
+	}
+
+	return nil
+}
+
+// Функция получения параметров бакетов хэш-таблицы t
+func (s *Store) tableOptions(t *table) bkt.Options {
+	opts := s.bktOpts
+	opts.Kind = t.kind
+
+	return opts
+}
+
+// Функция создания бакета хэш-таблицы t на свободной странице или в конце файла бд
+func (s *Store) createBucket(t *table, localDepth, pattern int) (*bkt.Bucket, error) {
+	offset, err := s.allocPage()
+	if err != nil {
+		return nil, err
+	}
+
+	bucket, _, err := bkt.CreateBucket(s.pages, int(offset), s.tableOptions(t), localDepth, pattern)
+	if err != nil {
+		s.free = append(s.free, int(offset)) // страница так и не стала бакетом, ее можно занять заново
+		return nil, err
+	}
+
+	return bucket, nil
+}
+
+// Функция освобождения страницы по смещению offset
+func (s *Store) freePage(offset int) error {
+	if err := bkt.FreePage(s.pages, offset); err != nil {
+		return err
+	}
+	s.free = append(s.free, offset)
+
+	return nil
+}
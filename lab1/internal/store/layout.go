@@ -0,0 +1,250 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	bkt "debildb/internal/bucket"
+)
+
+// LayoutFormat - формат вывода раскладки хэш-таблиц
+type LayoutFormat int
+
+const (
+	LayoutJSON LayoutFormat = iota // JSON для утилит
+	LayoutDOT                      // граф Graphviz для визуализации
+
+	// LayoutKeys - добавляется к формату через |, что бы вывести еще и ключи каждого бакета
+	LayoutKeys LayoutFormat = 1 << 8
+)
+
+var ErrInvalidLayoutFormat = errors.New("invalid layout format")
+
+// Раскладка бд: хэш-таблицы основного и именованных пространств ключей
+type layout struct {
+	Scheme   string        `json:"scheme"`
+	Capacity int           `json:"capacity"` // записей в бакете
+	Tables   []tableLayout `json:"tables"`
+}
+
+// Раскладка одной хэш-таблицы
+type tableLayout struct {
+	Keyspace    string         `json:"keyspace"` // пустое имя - основное пространство ключей
+	GlobalDepth int            `json:"globalDepth"`
+	Split       int            `json:"split,omitempty"`
+	Directories []dirLayout    `json:"directories"`
+	Buckets     []bucketLayout `json:"buckets"`
+}
+
+// Директория и бакеты, на которые она указывает
+type dirLayout struct {
+	Index      int   `json:"index"`
+	LocalDepth int   `json:"localDepth"`
+	Bucket     int   `json:"bucket"`
+	Overflow   []int `json:"overflow,omitempty"` // цепочка переполнения линейного хэширования
+}
+
+// Бакет и директории, которые на него ссылаются
+type bucketLayout struct {
+	ID          int      `json:"id"`
+	LocalDepth  int      `json:"localDepth"`
+	Pattern     int      `json:"pattern"` // биты хэша, общие для ключей бакета
+	Records     int      `json:"records"`
+	Fill        float64  `json:"fill"`
+	Directories []int    `json:"directories"` // директории, указывающие на бакет; у бакета переполнения - директория цепочки
+	Overflow    bool     `json:"overflow,omitempty"`
+	Keys        []string `json:"keys,omitempty"`
+}
+
+// DumpLayout - выводит в w раскладку хэш-таблиц: директории и их локальную глубину, какие директории делят бакет,
+// заполненность бакетов и, с LayoutKeys, ключи в них. В основном пространстве ключей с историей
+// выводятся ключи записей как они лежат в бакете, вместе с ключами предыдущих версий.
+func (s *Store) DumpLayout(w io.Writer, format LayoutFormat) error {
+	s.mu.RLock()
+	l, err := s.layout(format&LayoutKeys != 0)
+	s.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("store - DumpLayout: %w", err)
+	}
+
+	switch format &^ LayoutKeys {
+	case LayoutJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(l)
+	case LayoutDOT:
+		err = writeDOT(w, l)
+	default:
+		err = fmt.Errorf("%w: %d", ErrInvalidLayoutFormat, format)
+	}
+	if err != nil {
+		return fmt.Errorf("store - DumpLayout: %w", err)
+	}
+
+	return nil
+}
+
+// Функция сбора раскладки всех хэш-таблиц хранилища
+func (s *Store) layout(keys bool) (layout, error) {
+	l := layout{Scheme: "extendible", Capacity: s.bktOpts.Capacity()}
+	if s.opts.Scheme == SchemeLinear {
+		l.Scheme = "linear"
+	}
+
+	names := make([]string, 0, len(s.keyspaces))
+	for name := range s.keyspaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tables := []*table{&s.table}
+	for _, name := range names {
+		tables = append(tables, s.keyspaces[name])
+	}
+
+	for i, t := range tables {
+		tl, err := s.tableLayout(t, keys)
+		if err != nil {
+			return layout{}, err
+		}
+		if i > 0 {
+			tl.Keyspace = names[i-1]
+		}
+		l.Tables = append(l.Tables, tl)
+	}
+
+	return l, nil
+}
+
+// Функция сбора раскладки хэш-таблицы
+func (s *Store) tableLayout(t *table, keys bool) (tableLayout, error) {
+	tl := tableLayout{GlobalDepth: t.globalDepth, Split: t.split}
+
+	byID := make(map[int]int) // ID бакета -> его номер в tl.Buckets
+	addBucket := func(bucket *bkt.Bucket, dir int, overflow bool) error {
+		if i, ok := byID[bucket.GetBucketID()]; ok {
+			tl.Buckets[i].Directories = append(tl.Buckets[i].Directories, dir)
+			return nil
+		}
+
+		localDepth, pattern, err := bucket.GetMeta()
+		if err != nil {
+			return err
+		}
+		values, err := bucket.GetBucketValues()
+		if err != nil {
+			return err
+		}
+
+		bl := bucketLayout{
+			ID:          bucket.GetBucketID(),
+			LocalDepth:  localDepth,
+			Pattern:     pattern,
+			Records:     len(values),
+			Fill:        float64(len(values)) / float64(s.bktOpts.Capacity()),
+			Directories: []int{dir},
+			Overflow:    overflow,
+		}
+		if keys {
+			for _, kv := range values {
+				bl.Keys = append(bl.Keys, kv.Key)
+			}
+			sort.Strings(bl.Keys)
+		}
+
+		byID[bl.ID] = len(tl.Buckets)
+		tl.Buckets = append(tl.Buckets, bl)
+
+		return nil
+	}
+
+	for _, dir := range t.dirList {
+		dl := dirLayout{Index: dir.index, LocalDepth: dir.localDepth, Bucket: dir.bucket.GetBucketID()}
+		if err := addBucket(dir.bucket, dir.index, false); err != nil {
+			return tableLayout{}, err
+		}
+		for _, overflow := range dir.overflow {
+			dl.Overflow = append(dl.Overflow, overflow.GetBucketID())
+			if err := addBucket(overflow, dir.index, true); err != nil {
+				return tableLayout{}, err
+			}
+		}
+		tl.Directories = append(tl.Directories, dl)
+	}
+
+	return tl, nil
+}
+
+// Функция вывода раскладки в формате DOT: каждая хэш-таблица - отдельный кластер,
+// директории указывают на бакеты сплошными стрелками, цепочки переполнения - пунктирными
+func writeDOT(w io.Writer, l layout) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph layout {\n\trankdir=LR;\n\tnode [shape=record];\n")
+
+	for i, tl := range l.Tables {
+		name := tl.Keyspace
+		if name == "" {
+			name = "default"
+		}
+		label := fmt.Sprintf("%s: %s, global depth %d", name, l.Scheme, tl.GlobalDepth)
+		if l.Scheme == "linear" {
+			label += fmt.Sprintf(", split %d", tl.Split)
+		}
+		fmt.Fprintf(&b, "\tsubgraph cluster_%d {\n\t\tlabel=\"%s\";\n", i, dotEscape(label))
+
+		for _, bl := range tl.Buckets {
+			fields := []string{
+				fmt.Sprintf("bucket %d", bl.ID),
+				fmt.Sprintf("depth %d, pattern %d", bl.LocalDepth, bl.Pattern),
+				fmt.Sprintf("%d/%d (%.0f%%)", bl.Records, l.Capacity, bl.Fill*100),
+			}
+			if len(bl.Keys) > 0 {
+				keys := make([]string, 0, len(bl.Keys))
+				for _, key := range bl.Keys {
+					keys = append(keys, dotEscape(key)+`\l`) // \l - перенос строки с выравниванием влево
+				}
+				fields = append(fields, strings.Join(keys, ""))
+			}
+			fmt.Fprintf(&b, "\t\tt%d_b%d [label=\"%s\"];\n", i, bl.ID, strings.Join(fields, "|"))
+		}
+
+		for _, dl := range tl.Directories {
+			fmt.Fprintf(&b, "\t\tt%d_d%d [label=\"dir %d|depth %d\"];\n", i, dl.Index, dl.Index, dl.LocalDepth)
+			fmt.Fprintf(&b, "\t\tt%d_d%d -> t%d_b%d;\n", i, dl.Index, i, dl.Bucket)
+			prev := dl.Bucket
+			for _, id := range dl.Overflow {
+				fmt.Fprintf(&b, "\t\tt%d_b%d -> t%d_b%d [style=dashed];\n", i, prev, i, id)
+				prev = id
+			}
+		}
+
+		b.WriteString("\t}\n")
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+// Функция экранирования текста для меток record-узлов: разделители полей и кавычки экранируются
+func dotEscape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch r {
+		case '\\', '"', '|', '{', '}', '<', '>':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
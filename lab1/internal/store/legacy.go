@@ -0,0 +1,119 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	bkt "debildb/internal/bucket"
+	"debildb/internal/parser"
+)
+
+// Файлы формата 0 писала первая версия хранилища. Заголовка у них нет: файл - это подряд идущие бакеты
+// по legacyPageSize байт, [0] бакета - количество записей, за ним до legacyBucketCap записей
+// раскладки parser.DefaultLayout (1365 байт). Список директорий в файле не хранился,
+// поэтому при миграции он строится заново по хэшам ключей.
+const (
+	legacyPageSize  = 4096
+	legacyBucketCap = 3
+)
+
+// Функция проверки, что файл бд записан в формате 0: в начале файла нет magic заголовка
+func isLegacyFile(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("legacy check: %w", err)
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(headerMagic))
+	if _, err = io.ReadFull(file, magic); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) { // такой короткий файл не бд ни в каком формате
+			return false, nil
+		}
+		return false, fmt.Errorf("legacy check - read: %w", err)
+	}
+
+	return string(magic) != headerMagic, nil
+}
+
+// Функция чтения всех записей файла формата 0.
+// Первая версия не перезаписывала ключи, а дописывала их в бакет, и поиск возвращал первую запись,
+// поэтому из повторов ключа остается первый. Файл должен быть открыт под блокировкой.
+func readLegacy(file *os.File) ([]bkt.KV, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("read legacy: %w", err)
+	}
+	if info.Size()%legacyPageSize != 0 {
+		return nil, fmt.Errorf("read legacy: %w: size %d is not a multiple of %d", ErrInvalidHeader, info.Size(), legacyPageSize)
+	}
+
+	recordLen := parser.DefaultLayout.RecordLen()
+	page := make([]byte, legacyPageSize)
+	seen := make(map[string]bool)
+	var kvs []bkt.KV
+	for offset := int64(0); offset < info.Size(); offset += legacyPageSize {
+		if _, err = file.ReadAt(page, offset); err != nil {
+			return nil, fmt.Errorf("read legacy - read at: %w", err)
+		}
+
+		count := int(page[0])
+		if count > legacyBucketCap {
+			return nil, fmt.Errorf("read legacy: %w: bucket at %d has %d records", ErrInvalidHeader, offset, count)
+		}
+
+		for i := 0; i < count; i++ {
+			start := 1 + i*recordLen
+			key, val, err := parser.DefaultLayout.Unmarshal(page[start : start+recordLen])
+			if err != nil {
+				return nil, fmt.Errorf("read legacy: %w: bucket at %d, record %d: %w", ErrInvalidHeader, offset, i, err)
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			kvs = append(kvs, bkt.KV{Key: key, Val: val})
+		}
+	}
+
+	return kvs, nil
+}
+
+// Функция переноса бд формата 0 из заблокированного файла src в новую бд текущего формата по пути target.
+// Записи раскладываются по бакетам массовой загрузкой. Размер значения по умолчанию уменьшен
+// относительно первой версии, поэтому если какое-то значение в него не помещается, новая бд
+// получает прежний размер значения ценой меньшего числа записей в бакете.
+func migrateLegacy(src *os.File, target string, opts Options) (int, error) {
+	kvs, err := readLegacy(src)
+	if err != nil {
+		return 0, err
+	}
+
+	maxValueSize := bkt.DefaultMaxValueSize
+	for _, kv := range kvs {
+		if len(kv.Val) > maxValueSize {
+			maxValueSize = parser.DefaultMaxValueSize
+			break
+		}
+	}
+
+	ns, err := NewStoreWithOptions(target, Options{
+		MaxKeySize:   parser.DefaultMaxKeySize,
+		MaxValueSize: maxValueSize,
+		SyncPolicy:   SyncNone,
+		Backend:      opts.Backend,
+		Logger:       opts.Logger,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer ns.Close()
+
+	if err = ns.BulkLoad(NewSliceIterator(kvs)); err != nil {
+		return 0, err
+	}
+
+	return len(kvs), ns.Sync()
+}
@@ -0,0 +1,275 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	bkt "debildb/internal/bucket"
+)
+
+// Scheme - схема адресации бакетов
+type Scheme int
+
+const (
+	// SchemeExtendible - расширяемое хэширование: при переполнении бакет делится,
+	// а когда его локальная глубина догоняет глобальную - удваивается весь список директорий
+	SchemeExtendible Scheme = iota
+	// SchemeLinear - линейное хэширование (Litwin): бакеты делятся по одному по кругу по указателю расщепления,
+	// переполненные записи уходят в цепочки переполнения, удвоений списка директорий нет.
+	// Выигрывает на больших бд, где пауза на удвоение списка директорий заметна (см. BenchmarkScheme),
+	// на маленьких цепочки переполнения делают записи медленнее
+	SchemeLinear
+)
+
+// Линейное хэширование использует те же структуры, что и расширяемое:
+// globalDepth - количество бит хэша в текущем раунде, split - указатель расщепления,
+// dirList[i] - основной бакет адреса i и его цепочка переполнения.
+// Адресов всего 2^globalDepth + split, бакеты левее split и правее 2^globalDepth уже адресуются globalDepth+1 битами.
+
+// Функция получения адреса бакета для ключа
+func (t *table) linearAddress(key string) int {
+	addr := getDirID(key, t.globalDepth)
+	if addr < t.split { // бакет уже расщеплен в этом раунде
+		addr = getDirID(key, t.globalDepth+1)
+	}
+
+	return addr
+}
+
+// Функция получения значения из цепочки бакетов адреса ключа
+func (s *Store) linearGet(t *table, key string) (string, error) {
+	dir := t.dirList[t.linearAddress(key)]
+	for _, bucket := range dir.chain() {
+		kv, err := bucket.GetValue(key)
+		if errors.Is(err, bkt.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("store get value: %w", err)
+		}
+		return kv.Val, nil
+	}
+
+	return "", fmt.Errorf("store get value: %w", bkt.ErrKeyNotFound)
+}
+
+// Функция загрузки значения: существующий ключ перезаписывается в своем бакете,
+// новый кладется в первый бакет цепочки со свободным местом.
+// Если пришлось удлинить цепочку переполнения, расщепляется бакет под указателем расщепления.
+func (s *Store) linearPut(t *table, key, value string) error {
+	addr := t.linearAddress(key)
+	kv := &bkt.KV{Key: key, Val: value}
+
+	bucket, err := t.dirList[addr].find(key)
+	if err != nil {
+		return fmt.Errorf("store - SetValue: %w", err)
+	}
+	if bucket != nil {
+		if err = bucket.PutValue(kv); err != nil {
+			return fmt.Errorf("store - SetValue: %w", err)
+		}
+		return nil
+	}
+
+	overflowed, err := s.linearInsert(t, addr, kv)
+	if err != nil {
+		return fmt.Errorf("store - SetValue: %w", err)
+	}
+	if overflowed {
+		if err = s.linearSplit(t); err != nil {
+			return fmt.Errorf("store - SetValue: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Функция удаления значения из цепочки бакетов адреса ключа
+func (s *Store) linearDelete(t *table, key string) error {
+	bucket, err := t.dirList[t.linearAddress(key)].find(key)
+	if err != nil {
+		return err
+	}
+	if bucket == nil {
+		return bkt.ErrKeyNotFound
+	}
+
+	return bucket.DeleteValue(key)
+}
+
+// Функция добавления новой записи в цепочку адреса addr.
+// Возвращает true, если для записи пришлось создать бакет переполнения.
+func (s *Store) linearInsert(t *table, addr int, kv *bkt.KV) (bool, error) {
+	dir := &t.dirList[addr]
+	for _, bucket := range dir.chain() {
+		err := bucket.PutValue(kv)
+		if err == nil {
+			return false, nil
+		}
+		if !errors.Is(err, bkt.ErrBucketIsFull) {
+			return false, err
+		}
+	}
+
+	overflow, err := s.createBucket(t, dir.localDepth, addr)
+	if err != nil {
+		return false, err
+	}
+	if err = overflow.PutValue(kv); err != nil {
+		return false, err
+	}
+	dir.overflow = append(dir.overflow, overflow)
+
+	return true, nil
+}
+
+// Функция расщепления бакета под указателем расщепления.
+// Записи цепочки делятся по следующему биту хэша между старым адресом и новым адресом в конце списка,
+// бакеты переполнения старой цепочки освобождаются.
+func (s *Store) linearSplit(t *table) error {
+	if t.globalDepth+1 > maxGlobalDepth {
+		return ErrMaxDepthReached
+	}
+
+	addr := t.split
+	newAddr := addr + 1<<t.globalDepth
+	localDepth := t.globalDepth + 1
+
+	var records []bkt.KV
+	for _, bucket := range t.dirList[addr].chain() {
+		values, err := bucket.GetBucketValues()
+		if err != nil {
+			return fmt.Errorf("linear split: %w", err)
+		}
+		records = append(records, values...)
+	}
+
+	newBkt, err := s.createBucket(t, localDepth, newAddr)
+	if err != nil {
+		return fmt.Errorf("linear split: %w", err)
+	}
+
+	dir := &t.dirList[addr]
+	if err = dir.bucket.SetBucketIsEmpty(localDepth, addr); err != nil {
+		return fmt.Errorf("linear split - empty: %w", err)
+	}
+	for _, overflow := range dir.overflow {
+		if err = s.freePage(overflow.Offset()); err != nil {
+			return fmt.Errorf("linear split: %w", err)
+		}
+	}
+	dir.overflow = nil
+	dir.localDepth = localDepth
+
+	t.dirList = append(t.dirList, Directory{index: newAddr, bucket: newBkt, localDepth: localDepth})
+	t.split++
+	resized := t.split == 1<<t.globalDepth
+	if resized { // раунд закончен - все бакеты адресуются на бит больше
+		t.globalDepth++
+		t.split = 0
+	}
+
+	if s.opts.History && t == &s.table { // бакет переписывается - заодно удаляем версии, вышедшие за политику хранения
+		records = s.retainHistory(records, s.currentVersion)
+	}
+
+	for i := range records {
+		if _, err = s.linearInsert(t, t.linearAddress(records[i].Key), &records[i]); err != nil {
+			return fmt.Errorf("linear split - insert: %w", err)
+		}
+	}
+
+	if err = s.writeHeader(); err != nil { // указатель расщепления хранится в заголовке
+		return fmt.Errorf("linear split: %w", err)
+	}
+
+	s.obs.OnSplit(SplitEvent{
+		Keyspace:   t.name,
+		Bucket:     t.dirList[addr].bucket.GetBucketID(),
+		NewBucket:  newBkt.GetBucketID(),
+		LocalDepth: localDepth,
+		Records:    len(records),
+	})
+	if resized {
+		s.obs.OnResize(ResizeEvent{Keyspace: t.name, GlobalDepth: t.globalDepth})
+	}
+
+	return nil
+}
+
+// Функция добавления бакета в цепочку адреса при загрузке бд
+func (t *table) addLinearBucket(bucket *bkt.Bucket, localDepth, addr int) error {
+	if addr >= len(t.dirList) {
+		return fmt.Errorf("%w: bucket address %d", ErrInvalidHeader, addr)
+	}
+
+	dir := &t.dirList[addr]
+	if dir.bucket == nil {
+		*dir = Directory{index: addr, bucket: bucket, localDepth: localDepth}
+		return nil
+	}
+	dir.overflow = append(dir.overflow, bucket)
+
+	return nil
+}
+
+// Функция разбиения записей массовой загрузки по адресам линейного хэширования.
+// Количество бит подбирается так, что бы записи в среднем помещались в основные бакеты.
+func (s *Store) partitionLinear(records []bulkRecord) ([]bkt.Page, int) {
+	depth := s.opts.InitialGlobalDepth
+	for depth < maxGlobalDepth && len(records) > (1<<depth)*s.bktOpts.Capacity() {
+		depth++
+	}
+
+	groups := make([][]bkt.KV, 1<<depth)
+	for _, r := range records {
+		addr := r.hash & (1<<depth - 1)
+		groups[addr] = append(groups[addr], r.kv)
+	}
+
+	var pages []bkt.Page
+	for addr, kvs := range groups {
+		for first := true; first || len(kvs) > 0; first = false { // у каждого адреса есть основной бакет, даже пустой
+			n := min(len(kvs), s.bktOpts.Capacity())
+			pages = append(pages, bkt.Page{Records: kvs[:n], LocalDepth: depth, Pattern: addr})
+			kvs = kvs[n:]
+		}
+	}
+
+	return pages, depth
+}
+
+// Функция получения цепочки бакетов директории: основной бакет и бакеты переполнения
+func (d Directory) chain() []*bkt.Bucket {
+	return append([]*bkt.Bucket{d.bucket}, d.overflow...)
+}
+
+// Функция поиска бакета цепочки, в котором лежит ключ, nil - ключа нет
+func (d Directory) find(key string) (*bkt.Bucket, error) {
+	for _, bucket := range d.chain() {
+		_, err := bucket.GetValue(key)
+		if err == nil {
+			return bucket, nil
+		}
+		if !errors.Is(err, bkt.ErrKeyNotFound) {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// Функция получения всех бакетов хэш-таблицы без повторов, вместе с бакетами переполнения
+func (t *table) buckets() []*bkt.Bucket {
+	seen := make(map[*bkt.Bucket]struct{})
+	var buckets []*bkt.Bucket
+	for _, dir := range t.dirList { // на один бакет может ссылаться несколько директорий
+		if _, ok := seen[dir.bucket]; ok {
+			continue
+		}
+		seen[dir.bucket] = struct{}{}
+		buckets = append(buckets, dir.chain()...)
+	}
+
+	return buckets
+}
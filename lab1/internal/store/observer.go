@@ -0,0 +1,148 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	bkt "debildb/internal/bucket"
+
+	"go.uber.org/zap"
+)
+
+// Observer - получатель событий хранилища для логирования, трассировки и метрик.
+// Методы вызываются синхронно в горутине операции, но уже без блокировки хранилища,
+// кроме OnSplit и OnResize, которые вызываются посреди записи и не должны обращаться к хранилищу.
+// Что бы реализовать только нужные события, достаточно встроить NopObserver.
+type Observer interface {
+	OnGet(GetEvent)       // чтение значения, в том числе промах
+	OnSet(SetEvent)       // запись значения
+	OnDelete(DeleteEvent) // удаление значения
+	OnSplit(SplitEvent)   // сплит бакета
+	OnResize(ResizeEvent) // рост глобальной глубины
+	OnError(ErrorEvent)   // операция завершилась ошибкой
+}
+
+// GetEvent - чтение значения
+type GetEvent struct {
+	Keyspace string // пустое имя - основное пространство ключей
+	Key      string
+	Found    bool
+	Duration time.Duration
+}
+
+// SetEvent - запись значения
+type SetEvent struct {
+	Keyspace string
+	Key      string
+	Value    string
+	Duration time.Duration // вместе с ожиданием сброса на диск
+}
+
+// DeleteEvent - удаление значения
+type DeleteEvent struct {
+	Keyspace string
+	Key      string
+	Duration time.Duration
+}
+
+// SplitEvent - сплит бакета: часть записей переехала в новый бакет
+type SplitEvent struct {
+	Keyspace   string
+	Bucket     int // ID разделенного бакета
+	NewBucket  int // ID нового бакета
+	LocalDepth int // локальная глубина обоих бакетов после сплита
+	Records    int // сколько записей было перераспределено
+}
+
+// ResizeEvent - рост глобальной глубины: удвоение списка директорий или конец раунда линейного хэширования
+type ResizeEvent struct {
+	Keyspace    string
+	GlobalDepth int // новая глобальная глубина
+}
+
+// ErrorEvent - ошибка операции. Промах чтения и удаление отсутствующего ключа ошибками не считаются.
+type ErrorEvent struct {
+	Op       string // get, set, delete, compare-and-swap, bulk load и т.д.
+	Keyspace string
+	Key      string
+	Err      error
+}
+
+// NopObserver - наблюдатель, который ничего не делает; используется по умолчанию
+type NopObserver struct{}
+
+func (NopObserver) OnGet(GetEvent)       {}
+func (NopObserver) OnSet(SetEvent)       {}
+func (NopObserver) OnDelete(DeleteEvent) {}
+func (NopObserver) OnSplit(SplitEvent)   {}
+func (NopObserver) OnResize(ResizeEvent) {}
+func (NopObserver) OnError(ErrorEvent)   {}
+
+// ZapObserver - наблюдатель, который пишет события в zap так же, как раньше писало само хранилище.
+// Значения не логируются: вместо них пишется только размер.
+type ZapObserver struct {
+	log *zap.Logger
+}
+
+// NewZapObserver - создает наблюдатель, пишущий события в log
+func NewZapObserver(log *zap.Logger) *ZapObserver {
+	return &ZapObserver{log: log}
+}
+
+func (o *ZapObserver) OnGet(e GetEvent) {
+	o.log.Info("Get data", zap.String("keyspace", e.Keyspace), zap.String("key", e.Key), zap.Bool("found", e.Found), zap.Duration("duration", e.Duration))
+}
+
+func (o *ZapObserver) OnSet(e SetEvent) {
+	o.log.Info("Save data", zap.String("keyspace", e.Keyspace), zap.String("key", e.Key), zap.Int("valueSize", len(e.Value)), zap.Duration("duration", e.Duration))
+}
+
+func (o *ZapObserver) OnDelete(e DeleteEvent) {
+	o.log.Info("Delete data", zap.String("keyspace", e.Keyspace), zap.String("key", e.Key), zap.Duration("duration", e.Duration))
+}
+
+func (o *ZapObserver) OnSplit(e SplitEvent) {
+	o.log.Info("split bucket", zap.String("keyspace", e.Keyspace), zap.Int("bucket", e.Bucket), zap.Int("newBucket", e.NewBucket), zap.Int("localDepth", e.LocalDepth), zap.Int("records", e.Records))
+}
+
+func (o *ZapObserver) OnResize(e ResizeEvent) {
+	o.log.Info("global resize", zap.String("keyspace", e.Keyspace), zap.Int("globalDepth", e.GlobalDepth))
+}
+
+func (o *ZapObserver) OnError(e ErrorEvent) {
+	o.log.Error("store operation failed", zap.String("op", e.Op), zap.String("keyspace", e.Keyspace), zap.String("key", e.Key), zap.Error(e.Err))
+}
+
+// Функция оповещения наблюдателя о завершении записи или удаления change операцией op.
+// Вызывается через defer с указателем на возвращаемую ошибку. Отсутствующий ключ ошибкой не считается.
+func (s *Store) observeWrite(start time.Time, op string, change Change, err *error) {
+	if *err != nil {
+		if !errors.Is(*err, bkt.ErrKeyNotFound) {
+			s.obs.OnError(ErrorEvent{Op: op, Keyspace: change.Keyspace, Key: change.Key, Err: *err})
+		}
+		return
+	}
+
+	if change.Op == OpDelete {
+		s.obs.OnDelete(DeleteEvent{Keyspace: change.Keyspace, Key: change.Key, Duration: time.Since(start)})
+		return
+	}
+	s.obs.OnSet(SetEvent{Keyspace: change.Keyspace, Key: change.Key, Value: change.Val, Duration: time.Since(start)})
+}
+
+// Функция оповещения наблюдателя о завершении чтения
+func (s *Store) observeGet(start time.Time, keyspace, key string, err *error) {
+	if *err != nil && !errors.Is(*err, bkt.ErrKeyNotFound) {
+		s.obs.OnError(ErrorEvent{Op: "get", Keyspace: keyspace, Key: key, Err: *err})
+		return
+	}
+
+	s.obs.OnGet(GetEvent{Keyspace: keyspace, Key: key, Found: *err == nil, Duration: time.Since(start)})
+}
+
+// Функция оповещения наблюдателя об ошибке операции без отдельного события
+func (s *Store) observeError(op, keyspace string, err error) {
+	if err != nil {
+		s.obs.OnError(ErrorEvent{Op: op, Keyspace: keyspace, Err: err})
+	}
+}
@@ -0,0 +1,279 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	bkt "debildb/internal/bucket"
+	"debildb/internal/pagestore"
+	"debildb/internal/parser"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidOptions  = errors.New("invalid store options")
+	ErrOptionsMismatch = errors.New("options do not match database header")
+)
+
+// SyncPolicy - политика сброса изменений на диск
+type SyncPolicy int
+
+const (
+	SyncAlways SyncPolicy = iota // страница бакета сбрасывается на диск после каждой записи
+	SyncGroup                    // групповой коммит: сброс раз в GroupCommitInterval или каждые GroupCommitOps записей
+	SyncNone                     // когда сбрасывать данные на диск решает ОС
+)
+
+const (
+	defaultGroupCommitInterval = 10 * time.Millisecond
+	defaultGroupCommitOps      = 64
+
+	defaultChangeLogSize = 4096
+)
+
+// Options - параметры хранилища.
+// Нулевое значение поля означает значение по умолчанию.
+// Размеры бакета, ключа и значения сохраняются в заголовке файла бд и при открытии берутся из него.
+type Options struct {
+	PageSize           int        // размер страницы, должен быть кратен системному размеру страницы
+	BucketPages        int        // размер бакета в страницах
+	InitialGlobalDepth int        // глобальная глубина нового хранилища
+	MaxKeySize         int        // максимальный размер ключа в байтах
+	MaxValueSize       int        // максимальный размер значения в байтах
+	SyncPolicy         SyncPolicy // политика сброса изменений на диск
+	Scheme             Scheme     // схема адресации бакетов; задается при создании бд и сохраняется в заголовке
+
+	Backend   pagestore.Backend   // реализация хранилища страниц
+	PageStore pagestore.PageStore // готовое хранилище страниц (например, с внедрением сбоев), Backend тогда не используется
+
+	// ReadOnly - открыть существующую бд только на чтение: файл мапится с mmap.RDONLY под разделяемой блокировкой,
+	// читателей может быть несколько, пишущий процесс - только один и только когда читателей нет
+	ReadOnly    bool
+	LockTimeout time.Duration // сколько ждать блокировку файла, занятую другим процессом; 0 - сразу вернуть ошибку
+
+	GroupCommitInterval time.Duration // для SyncGroup - максимальное время ожидания сброса
+	GroupCommitOps      int           // для SyncGroup - количество ожидающих записей, при котором сброс выполняется сразу
+
+	ChangeLogSize int // количество последних изменений, которые хранятся в памяти для догоняющих реплик
+	WatchBuffer   int // количество событий, которое помещается в буфер подписчика Watch
+
+	OrderedIndex bool // вести упорядоченный индекс ключей для Range и Prefix; индекс, уже записанный в файл, ведется всегда
+
+	History          bool      // хранить предыдущие версии значений; задается при создании бд и сохраняется в заголовке
+	HistoryRetention Retention // политика хранения предыдущих версий
+
+	// Квоты: при превышении запись возвращает ErrQuotaExceeded, ничего не изменив; 0 - без ограничения.
+	// Узлы индекса занимают такие же страницы, как бакеты, и учитываются в MaxBuckets.
+	MaxFileSize int64 // максимальный размер файла бд в байтах
+	MaxBuckets  int   // максимальное количество занятых страниц бакетов
+
+	Logger   *zap.Logger // логгер событий жизненного цикла, по умолчанию логирование отключено
+	Observer Observer    // получатель событий операций (чтение, запись, сплиты, ошибки), по умолчанию NopObserver
+
+	format int // версия формата файла бд, 0 - текущая; для существующей бд берется из заголовка
+}
+
+// DefaultOptions - параметры хранилища по умолчанию.
+// Размер значения по умолчанию - bkt.DefaultMaxValueSize, на 4 байта меньше, чем в первых версиях (см. его описание).
+func DefaultOptions() Options {
+	return Options{
+		PageSize:           os.Getpagesize(),
+		BucketPages:        1,
+		InitialGlobalDepth: defaultGlobalDepth,
+		MaxKeySize:         parser.DefaultMaxKeySize,
+		MaxValueSize:       bkt.DefaultMaxValueSize,
+		SyncPolicy:         SyncAlways,
+
+		GroupCommitInterval: defaultGroupCommitInterval,
+		GroupCommitOps:      defaultGroupCommitOps,
+
+		ChangeLogSize: defaultChangeLogSize,
+		WatchBuffer:   defaultWatchBuffer,
+
+		Logger:   zap.NewNop(),
+		Observer: NopObserver{},
+	}
+}
+
+// Функция заполнения незаданных параметров значениями по умолчанию
+func (o Options) withDefaults() Options {
+	def := DefaultOptions()
+	if o.PageSize == 0 {
+		o.PageSize = def.PageSize
+	}
+	if o.BucketPages == 0 {
+		o.BucketPages = def.BucketPages
+	}
+	if o.InitialGlobalDepth == 0 {
+		o.InitialGlobalDepth = def.InitialGlobalDepth
+	}
+	if o.MaxKeySize == 0 {
+		o.MaxKeySize = def.MaxKeySize
+	}
+	if o.MaxValueSize == 0 {
+		o.MaxValueSize = def.MaxValueSize
+	}
+	if o.GroupCommitInterval == 0 {
+		o.GroupCommitInterval = def.GroupCommitInterval
+	}
+	if o.GroupCommitOps == 0 {
+		o.GroupCommitOps = def.GroupCommitOps
+	}
+	if o.ChangeLogSize == 0 {
+		o.ChangeLogSize = def.ChangeLogSize
+	}
+	if o.WatchBuffer == 0 {
+		o.WatchBuffer = def.WatchBuffer
+	}
+	if o.Logger == nil {
+		o.Logger = def.Logger
+	}
+	if o.Observer == nil {
+		o.Observer = def.Observer
+	}
+	if o.format == 0 {
+		o.format = currentFormat
+	}
+	return o
+}
+
+// Функция проверки параметров
+func (o Options) validate() error {
+	if o.InitialGlobalDepth < 1 || o.InitialGlobalDepth > maxGlobalDepth {
+		return fmt.Errorf("%w: initial global depth %d", ErrInvalidOptions, o.InitialGlobalDepth)
+	}
+	if o.SyncPolicy < SyncAlways || o.SyncPolicy > SyncNone {
+		return fmt.Errorf("%w: sync policy %d", ErrInvalidOptions, o.SyncPolicy)
+	}
+	if o.Scheme < SchemeExtendible || o.Scheme > SchemeLinear {
+		return fmt.Errorf("%w: scheme %d", ErrInvalidOptions, o.Scheme)
+	}
+	if o.ChangeLogSize < 0 {
+		return fmt.Errorf("%w: change log size %d", ErrInvalidOptions, o.ChangeLogSize)
+	}
+	if o.WatchBuffer < 0 {
+		return fmt.Errorf("%w: watch buffer %d", ErrInvalidOptions, o.WatchBuffer)
+	}
+	if o.format < formatV1 || o.format > currentFormat {
+		return fmt.Errorf("%w: %w: version %d", ErrInvalidOptions, ErrUnsupportedFormat, o.format)
+	}
+	if o.MaxFileSize < 0 || o.MaxBuckets < 0 {
+		return fmt.Errorf("%w: max file size %d, max buckets %d", ErrInvalidOptions, o.MaxFileSize, o.MaxBuckets)
+	}
+	if o.LockTimeout < 0 {
+		return fmt.Errorf("%w: lock timeout %s", ErrInvalidOptions, o.LockTimeout)
+	}
+	if o.GroupCommitInterval < 0 || o.GroupCommitOps < 0 {
+		return fmt.Errorf("%w: group commit interval %s, ops %d", ErrInvalidOptions, o.GroupCommitInterval, o.GroupCommitOps)
+	}
+	if o.History && (o.MaxKeySize <= historyKeyLen || o.MaxValueSize < versionMetaLen) {
+		return fmt.Errorf("%w: key size %d, value size %d too small for version history", ErrInvalidOptions, o.MaxKeySize, o.MaxValueSize)
+	}
+	if o.HistoryRetention.MaxVersions < 0 || o.HistoryRetention.MaxAge < 0 {
+		return fmt.Errorf("%w: history retention %+v", ErrInvalidOptions, o.HistoryRetention)
+	}
+	if err := o.geometry().Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidOptions, err)
+	}
+	return nil
+}
+
+// Функция открытия хранилища страниц для файла бд
+func (o Options) openPages(pathDB string) (pagestore.PageStore, error) {
+	if o.PageStore != nil {
+		return o.PageStore, nil
+	}
+
+	return pagestore.OpenWithOptions(o.Backend, pathDB, pagestore.OpenOptions{ReadOnly: o.ReadOnly, LockTimeout: o.LockTimeout})
+}
+
+// Функция закрытия хранилища страниц при ошибке открытия бд. Переданное снаружи хранилище не закрывается.
+func (o Options) closePages(pages pagestore.PageStore) {
+	if o.PageStore == nil {
+		pages.Close()
+	}
+}
+
+// Функция получения геометрии бакета из параметров
+func (o Options) geometry() bkt.Geometry {
+	return bkt.Geometry{
+		PageSize: o.PageSize,
+		Pages:    o.BucketPages,
+		Layout: parser.Layout{
+			MaxKeySize:   o.MaxKeySize,
+			MaxValueSize: o.MaxValueSize,
+		},
+		Fingerprints: o.format >= formatV2,
+	}
+}
+
+// Функция получения параметров бакетов
+func (o Options) bucketOptions() bkt.Options {
+	return bkt.Options{
+		Geometry: o.geometry(),
+		Sync:     o.SyncPolicy == SyncAlways,
+	}
+}
+
+// Функция применения параметров из заголовка бд.
+// Явно заданные параметры, которые расходятся с заголовком, считаются ошибкой.
+func (o Options) applyHeader(h header) (Options, error) {
+	for _, f := range []struct {
+		name        string
+		opt, stored int
+	}{
+		{"page size", o.PageSize, h.pageSize},
+		{"bucket pages", o.BucketPages, h.bucketPages},
+		{"initial global depth", o.InitialGlobalDepth, h.initialGlobalDepth},
+		{"max key size", o.MaxKeySize, h.maxKeySize},
+		{"max value size", o.MaxValueSize, h.maxValueSize},
+	} {
+		if f.opt != 0 && f.opt != f.stored {
+			return o, fmt.Errorf("%w: %s is %d, stored %d", ErrOptionsMismatch, f.name, f.opt, f.stored)
+		}
+	}
+
+	o.PageSize = h.pageSize
+	o.BucketPages = h.bucketPages
+	o.InitialGlobalDepth = h.initialGlobalDepth
+	o.MaxKeySize = h.maxKeySize
+	o.MaxValueSize = h.maxValueSize
+	o.OrderedIndex = o.OrderedIndex || h.indexRoot != 0
+
+	history := h.flags&headerHistory != 0
+	if o.History && !history { // старые значения записаны без метаданных версий
+		return o, fmt.Errorf("%w: history is enabled, stored without history", ErrOptionsMismatch)
+	}
+	o.History = history
+
+	scheme := SchemeExtendible
+	if h.flags&headerLinear != 0 {
+		scheme = SchemeLinear
+	}
+	if o.Scheme != SchemeExtendible && o.Scheme != scheme { // схему адресации нельзя сменить без перестроения бд
+		return o, fmt.Errorf("%w: scheme is %d, stored %d", ErrOptionsMismatch, o.Scheme, scheme)
+	}
+	o.Scheme = scheme
+	format, err := h.resolveFormat()
+	if err != nil {
+		return o, err
+	}
+	o.format = format
+
+	return o.withDefaults(), nil
+}
+
+// Функция получения флагов хранилища для заголовка
+func (o Options) headerFlags() byte {
+	var flags byte
+	if o.History {
+		flags |= headerHistory
+	}
+	if o.Scheme == SchemeLinear {
+		flags |= headerLinear
+	}
+	return flags
+}
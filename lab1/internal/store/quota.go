@@ -0,0 +1,182 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	bkt "debildb/internal/bucket"
+	"debildb/internal/pagestore"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrQuotaExceeded = errors.New("database quota exceeded")
+	ErrDiskFull      = errors.New("disk is full, store is read-only")
+)
+
+// Health - состояние хранилища
+type Health int32
+
+const (
+	HealthOK       Health = iota // хранилище работает
+	HealthDiskFull               // на диске закончилось место: запись отключена до переоткрытия бд
+)
+
+// Health - текущее состояние хранилища
+func (s *Store) Health() Health {
+	return Health(s.health.Load())
+}
+
+// Квоты проверяются до того, как операция что-то изменит: заранее считается, сколько новых страниц
+// выделят сплиты бакетов и расщепления узлов индекса, и место под них резервируется на диске.
+// Если место закончилось, хранилище переходит в режим только для чтения, а не обрывает сплит на середине.
+
+// Функция проверки квот и резервирования места перед записью ключа в основное пространство ключей
+func (s *Store) reservePut(key string) error {
+	target := key
+	if s.opts.History { // первой пишется предыдущая версия под ключом истории, текущая перезаписывается на месте
+		meta, _, err := s.getVersion(key)
+		switch {
+		case err == nil:
+			target = historyKey(key, meta.version)
+		case !errors.Is(err, bkt.ErrKeyNotFound):
+			return err
+		}
+	}
+
+	pages, err := s.putPages(&s.table, target)
+	if err != nil {
+		return err
+	}
+	if s.index != nil {
+		nodes, err := s.index.InsertNodes(key)
+		if err != nil {
+			return err
+		}
+		pages += nodes
+	}
+
+	return s.reserve(pages)
+}
+
+// Функция проверки квот и резервирования места под pages новых страниц
+func (s *Store) reserve(pages int) error {
+	if pages == 0 {
+		return nil
+	}
+
+	grow := max(0, pages-len(s.free)) // сначала занимаются освобожденные страницы
+	end := int64(s.endOffset + grow*s.bktOpts.Size())
+	if err := s.checkQuota(end, s.usedPages()+pages); err != nil {
+		return err
+	}
+
+	return s.reserveSpace(end)
+}
+
+// Функция проверки квот и резервирования места под массовую загрузку pages бакетов основного пространства ключей,
+// которые пишутся начиная со смещения start. Старые бакеты освобождаются только после записи новых.
+// Узлы нового индекса в проверку не входят.
+func (s *Store) reserveBulk(start, pages int) error {
+	used := s.usedPages() - len(s.buckets()) + pages
+	end := int64(max(s.endOffset, start+pages*s.bktOpts.Size()))
+	if err := s.checkQuota(end, used); err != nil {
+		return err
+	}
+
+	return s.reserveSpace(end)
+}
+
+// Функция проверки квот для состояния после операции: end - размер файла, used - занятые страницы
+func (s *Store) checkQuota(end int64, used int) error {
+	if s.opts.MaxFileSize > 0 && end > s.opts.MaxFileSize {
+		return fmt.Errorf("%w: file size %d, limit %d", ErrQuotaExceeded, end, s.opts.MaxFileSize)
+	}
+	if s.opts.MaxBuckets > 0 && used > s.opts.MaxBuckets {
+		return fmt.Errorf("%w: %d buckets, limit %d", ErrQuotaExceeded, used, s.opts.MaxBuckets)
+	}
+
+	return nil
+}
+
+// Функция резервирования места на диске под первые end байт файла, если хранилище страниц это умеет
+func (s *Store) reserveSpace(end int64) error {
+	r, ok := s.pages.(pagestore.Reserver)
+	if !ok || end <= int64(s.endOffset) {
+		return nil
+	}
+
+	return s.checkDiskFull(r.Reserve(end))
+}
+
+// Функция подсчета занятых страниц: бакеты всех пространств ключей и узлы индекса
+func (s *Store) usedPages() int {
+	return (s.endOffset-s.opts.PageSize)/s.bktOpts.Size() - len(s.free)
+}
+
+// Функция подсчета новых страниц, которые выделит запись ключа в хэш-таблицу t
+func (s *Store) putPages(t *table, key string) (int, error) {
+	if s.opts.Scheme == SchemeLinear {
+		for _, bucket := range t.dirList[t.linearAddress(key)].chain() {
+			values, err := bucket.GetBucketValues()
+			if err != nil {
+				return 0, err
+			}
+			if len(values) < s.bktOpts.Capacity() || containsKey(values, key) {
+				return 0, nil
+			}
+		}
+		return 2, nil // бакет переполнения и новый бакет расщепления, цепочке хватит освобожденных страниц
+	}
+
+	dir := t.dirList[getDirID(key, t.globalDepth)]
+	values, err := dir.bucket.GetBucketValues()
+	if err != nil {
+		return 0, err
+	}
+	if len(values) < s.bktOpts.Capacity() || containsKey(values, key) {
+		return 0, nil
+	}
+
+	// каждый сплит выделяет один бакет; сплиты повторяются, пока ключ делит бакет с Capacity записями
+	hash := getDirID(key, maxGlobalDepth)
+	pages := 0
+	for depth := dir.localDepth; len(values) >= s.bktOpts.Capacity() && depth < maxGlobalDepth; depth++ {
+		same := values[:0:0]
+		for _, kv := range values {
+			if getDirID(kv.Key, maxGlobalDepth)&(1<<depth) == hash&(1<<depth) {
+				same = append(same, kv)
+			}
+		}
+		values = same
+		pages++
+	}
+
+	return pages, nil
+}
+
+// Функция перевода хранилища в режим только для чтения, если err - переполнение диска.
+// Операция, на которой закончилось место, могла оборваться на середине, поэтому дальше бд только читается.
+func (s *Store) checkDiskFull(err error) error {
+	if !errors.Is(err, pagestore.ErrNoSpace) {
+		return err
+	}
+
+	if s.health.CompareAndSwap(int32(HealthOK), int32(HealthDiskFull)) {
+		s.log.Error("no space left on device, store is read-only now", zap.Error(err))
+	}
+
+	return fmt.Errorf("%w: %w", ErrDiskFull, err)
+}
+
+// Функция проверки, что среди записей есть ключ
+func containsKey(values []bkt.KV, key string) bool {
+	for _, kv := range values {
+		if kv.Key == key {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,492 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+
+	bkt "debildb/internal/bucket"
+
+	"go.uber.org/zap"
+)
+
+// Протокол репликации:
+// реплика отправляет номер последнего примененного изменения (8 байт),
+// основное хранилище отвечает статусом (1 байт) и затем бесконечно шлет изменения.
+// Изменение: [8 байт seq][1 байт тип][uvarint длина имени пространства ключей][имя]
+// [uvarint длина ключа][ключ][uvarint длина значения][значение]
+// Если продолжить с номера реплики нельзя, после статуса replStatusSnapshot идет снимок хранилища
+// в виде тех же изменений: создание пространств ключей, затем запись каждого текущего значения,
+// все с номером последнего изменения основного хранилища, и завершающая запись opSnapshotEnd.
+const (
+	replStatusOK       byte = 0
+	replStatusSnapshot byte = 2 // статус 1 отказывал реплике, которой не хватало журнала, и больше не отправляется
+
+	opSnapshotEnd OpType = 0xff // конец снимка, дальше идут обычные изменения
+
+	// Номер изменения реплики на время полной пересинхронизации: если она прервется, реплика окажется
+	// впереди основного хранилища и при следующем подключении снова получит снимок
+	resyncSeq uint64 = math.MaxUint64
+)
+
+var (
+	ErrInvalidChange = errors.New("invalid change record")
+)
+
+// ServeFollower - отдает реплике, подключенной через conn, поток изменений.
+// Сначала отправляются изменения, которые реплика пропустила, затем новые по мере появления.
+// Если пропущенные изменения уже вытеснены из журнала или реплика впереди основного хранилища
+// (оно упало, не сохранив последние изменения, которые реплика успела получить), вместо них отправляется снимок.
+// Возвращается при отмене ctx или ошибке соединения.
+func (s *Store) ServeFollower(ctx context.Context, conn io.ReadWriter) error {
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+
+	var buf [8]byte
+	if _, err := io.ReadFull(conn, buf[:]); err != nil { // номер, с которого реплике нужны изменения
+		return fmt.Errorf("serve follower - read handshake: %w", ctxErr(ctx, err))
+	}
+	seq := binary.BigEndian.Uint64(buf[:])
+
+	w := bufio.NewWriter(conn)
+	changes, notify, snapshot, err := s.followerChanges(seq)
+	if err != nil {
+		return fmt.Errorf("serve follower from %d: %w", seq, err)
+	}
+	status := replStatusOK
+	if snapshot {
+		status = replStatusSnapshot
+	}
+	if err = w.WriteByte(status); err != nil {
+		return fmt.Errorf("serve follower - write status: %w", ctxErr(ctx, err))
+	}
+
+	s.log.Info("follower connected", zap.Uint64("seq", seq), zap.Bool("snapshot", snapshot))
+
+	for {
+		for _, change := range changes {
+			if _, err = w.Write(marshalChange(change)); err != nil {
+				return fmt.Errorf("serve follower - write change: %w", ctxErr(ctx, err))
+			}
+			seq = change.Seq
+		}
+		if err = w.Flush(); err != nil {
+			return fmt.Errorf("serve follower - flush: %w", ctxErr(ctx, err))
+		}
+
+		select { // ждем новых изменений
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		changes, notify, err = s.changesSince(seq)
+		if err != nil { // реплика не успевает за журналом, при переподключении она получит снимок
+			return fmt.Errorf("serve follower from %d: %w", seq, err)
+		}
+	}
+}
+
+// Функция выбора изменений для реплики, применившей изменения до seq включительно.
+// Если продолжить журнал с seq нельзя, вместо изменений возвращается снимок хранилища и snapshot = true.
+func (s *Store) followerChanges(seq uint64) (changes []Change, notify <-chan struct{}, snapshot bool, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	changes, notify, err = s.changes.since(seq, s.seq)
+	if !errors.Is(err, ErrChangesTruncated) && !errors.Is(err, ErrChangesAhead) {
+		return changes, notify, false, err
+	}
+
+	changes = []Change{}
+	for name := range s.keyspaces {
+		changes = append(changes, Change{Seq: s.seq, Op: OpCreateKeyspace, Keyspace: name})
+	}
+	err = s.eachCurrentValue(func(keyspace string, kv bkt.KV) {
+		changes = append(changes, Change{Seq: s.seq, Op: OpPut, Keyspace: keyspace, Key: kv.Key, Val: kv.Val})
+	})
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("snapshot: %w", err)
+	}
+
+	return append(changes, Change{Seq: s.seq, Op: opSnapshotEnd}), notify, true, nil
+}
+
+// Функция обхода текущих значений всех пространств ключей, в режиме истории предыдущие версии
+// и удаленные ключи пропускаются. Вызывается под блокировкой хранилища.
+func (s *Store) eachCurrentValue(fn func(keyspace string, kv bkt.KV)) error {
+	tables := map[string]*table{"": &s.table}
+	for name, t := range s.keyspaces {
+		tables[name] = t
+	}
+
+	for name, t := range tables {
+		for _, bucket := range t.buckets() {
+			values, err := bucket.GetBucketValues()
+			if err != nil {
+				return err
+			}
+			for _, kv := range values {
+				if name == "" && s.opts.History { // история есть только у основного пространства ключей
+					meta, val, err := decodeVersion(kv.Val)
+					if err != nil {
+						return err
+					}
+					if meta.flags&(versionDeleted|versionHistory) != 0 {
+						continue
+					}
+					kv.Val = val
+				}
+				fn(name, kv)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Follow - применяет к хранилищу поток изменений основного хранилища, подключенного через conn.
+// Реплика запрашивает изменения начиная со своего LastSeq, поэтому после разрыва соединения
+// достаточно вызвать Follow повторно, что бы догнать основное хранилище. Если основное хранилище
+// не может продолжить с LastSeq, реплика пересинхронизируется по его снимку: все, чего нет в снимке, удаляется.
+// Пока Follow работает, локальные записи отклоняются с ErrFollower: иначе они сдвинули бы номер
+// изменений реплики и изменения основного хранилища с теми же номерами были бы пропущены.
+// Возвращается при отмене ctx или ошибке соединения.
+func (s *Store) Follow(ctx context.Context, conn io.ReadWriter) error {
+	s.mu.Lock() // под блокировкой: локальные записи, ждущие ее, увидят режим реплики
+	started := s.following.CompareAndSwap(false, true)
+	s.mu.Unlock()
+	if !started {
+		return fmt.Errorf("follow: %w: already following", ErrFollower)
+	}
+	defer s.following.Store(false)
+
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], s.LastSeq())
+	if _, err := conn.Write(buf[:]); err != nil {
+		return fmt.Errorf("follow - write handshake: %w", ctxErr(ctx, err))
+	}
+
+	r := bufio.NewReader(conn)
+	status, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("follow - read status: %w", ctxErr(ctx, err))
+	}
+	switch status {
+	case replStatusOK:
+	case replStatusSnapshot:
+		if err = s.applySnapshot(r); err != nil {
+			return fmt.Errorf("follow - snapshot: %w", ctxErr(ctx, err))
+		}
+	default:
+		return fmt.Errorf("follow: %w: status %d", ErrInvalidChange, status)
+	}
+
+	for {
+		change, err := unmarshalChange(r, s.opts.MaxKeySize, s.opts.MaxValueSize)
+		if err != nil {
+			return fmt.Errorf("follow - read change: %w", ctxErr(ctx, err))
+		}
+
+		if err = s.applyChange(change); err != nil {
+			return fmt.Errorf("follow: %w", err)
+		}
+	}
+}
+
+// ServeReplication - принимает подключения реплик на ln и обслуживает каждую в отдельной горутине.
+// Возвращается при отмене ctx или ошибке ln.Accept.
+func (s *Store) ServeReplication(ctx context.Context, ln net.Listener) error {
+	stop := closeOnDone(ctx, ln)
+	defer stop()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return fmt.Errorf("serve replication - accept: %w", ctxErr(ctx, err))
+		}
+
+		go func() {
+			defer conn.Close()
+			if err := s.ServeFollower(ctx, conn); err != nil && ctx.Err() == nil {
+				s.log.Warn("follower disconnected", zap.Error(err))
+			}
+		}()
+	}
+}
+
+// FollowTCP - подключается к основному хранилищу по адресу addr и применяет его изменения
+func (s *Store) FollowTCP(ctx context.Context, addr string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("follow tcp - dial: %w", err)
+	}
+	defer conn.Close()
+
+	return s.Follow(ctx, conn)
+}
+
+// Функция применения изменения основного хранилища.
+// Уже примененные изменения пропускаются, удаление отсутствующего ключа не считается ошибкой.
+// Номер изменения сохраняется в заголовке сразу после применения, что бы после падения реплика
+// запросила изменения с него, а не с номера последнего Sync. Если падение случится между записью
+// данных и заголовка, изменение применится повторно, что для всех типов изменений безопасно.
+func (s *Store) applyChange(change Change) error {
+	if err := s.storageWritable(); err != nil {
+		return err
+	}
+
+	if err := s.lockOpen(); err != nil {
+		return err
+	}
+	if change.Seq <= s.seq {
+		s.mu.Unlock()
+		return nil
+	}
+
+	old, err := s.applyOp(change)
+	if err == nil {
+		s.seq = change.Seq - 1 // номер изменения на реплике совпадает с номером на основном хранилище
+		s.recordChange(change, old)
+		err = s.checkDiskFull(s.writeHeader())
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("apply change %d: %w", change.Seq, err)
+	}
+
+	return s.commit()
+}
+
+// Функция применения изменения к данным без учета номера. Уже примененное изменение не считается ошибкой.
+// Возвращает значение ключа до изменения для подписчиков. Вызывается под блокировкой хранилища.
+func (s *Store) applyOp(change Change) (oldValue, error) {
+	var old oldValue
+	var err error
+	switch {
+	case change.Keyspace != "" || change.Op == OpCreateKeyspace || change.Op == OpDropKeyspace:
+		err = s.applyKeyspaceChange(context.Background(), change)
+		if errors.Is(err, bkt.ErrKeyNotFound) || errors.Is(err, ErrKeyspaceExists) ||
+			(change.Op == OpDropKeyspace && errors.Is(err, ErrKeyspaceNotFound)) {
+			err = nil
+		}
+	case change.Op == OpPut:
+		old = s.watchedValue(change.Key)
+		err = s.putValue(context.Background(), change.Key, change.Val) // изменение основного хранилища применяется целиком
+	case change.Op == OpDelete:
+		old = s.watchedValue(change.Key)
+		err = s.deleteValue(context.Background(), change.Key)
+		if errors.Is(err, bkt.ErrKeyNotFound) {
+			err = nil
+		}
+	default:
+		err = fmt.Errorf("%w: op %d", ErrInvalidChange, change.Op)
+	}
+
+	return old, err
+}
+
+// Функция полной пересинхронизации реплики по снимку основного хранилища из r.
+// Значения снимка пишутся поверх имеющихся, после чего удаляется все, чего в снимке не было.
+// До конца пересинхронизации номер изменения реплики равен resyncSeq.
+func (s *Store) applySnapshot(r *bufio.Reader) error {
+	if err := s.storageWritable(); err != nil {
+		return err
+	}
+	if err := s.resetSeq(resyncSeq); err != nil {
+		return err
+	}
+
+	seen := map[string]map[string]bool{"": {}} // ключи снимка по пространствам ключей
+	for {
+		change, err := unmarshalChange(r, s.opts.MaxKeySize, s.opts.MaxValueSize)
+		if err != nil {
+			return fmt.Errorf("read change: %w", err)
+		}
+
+		switch change.Op {
+		case opSnapshotEnd:
+			return s.finishSnapshot(change.Seq, seen)
+		case OpCreateKeyspace:
+			seen[change.Keyspace] = make(map[string]bool)
+		case OpPut:
+			if seen[change.Keyspace] == nil {
+				return fmt.Errorf("%w: keyspace %q is not in snapshot", ErrInvalidChange, change.Keyspace)
+			}
+			seen[change.Keyspace][change.Key] = true
+		default:
+			return fmt.Errorf("%w: op %d in snapshot", ErrInvalidChange, change.Op)
+		}
+
+		if err = s.lockOpen(); err != nil {
+			return err
+		}
+		old, err := s.applyOp(change)
+		if err == nil {
+			s.publishChange(change, old)
+		}
+		s.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("apply snapshot: %w", err)
+		}
+	}
+}
+
+// Функция завершения пересинхронизации: удаляются ключи и пространства ключей, которых нет в снимке,
+// и сохраняется номер изменения seq, на котором снят снимок. Журнал реплики очищается:
+// ее собственные реплики продолжить его не смогут и тоже пересинхронизируются.
+func (s *Store) finishSnapshot(seq uint64, seen map[string]map[string]bool) error {
+	if err := s.lockOpen(); err != nil {
+		return err
+	}
+	var stale []Change
+	for name := range s.keyspaces {
+		if seen[name] == nil {
+			stale = append(stale, Change{Seq: seq, Op: OpDropKeyspace, Keyspace: name})
+		}
+	}
+	err := s.eachCurrentValue(func(keyspace string, kv bkt.KV) {
+		if seen[keyspace] != nil && !seen[keyspace][kv.Key] {
+			stale = append(stale, Change{Seq: seq, Op: OpDelete, Keyspace: keyspace, Key: kv.Key})
+		}
+	})
+	for i := 0; err == nil && i < len(stale); i++ {
+		var old oldValue
+		if old, err = s.applyOp(stale[i]); err == nil {
+			s.publishChange(stale[i], old)
+		}
+	}
+	if err == nil {
+		s.seq = seq
+		s.changes.reset()
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("finish snapshot: %w", err)
+	}
+
+	return s.Sync()
+}
+
+// Функция замены номера последнего изменения с немедленным сохранением на диск
+func (s *Store) resetSeq(seq uint64) error {
+	if err := s.lockOpen(); err != nil {
+		return err
+	}
+	s.seq = seq
+	s.mu.Unlock()
+
+	return s.Sync()
+}
+
+// Функция захвата блокировки хранилища на запись для изменений основного хранилища,
+// которым не нужны проверки lockContext: только закрытие хранилища
+func (s *Store) lockOpen() error {
+	s.mu.Lock()
+	if s.closed.Load() {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+
+	return nil
+}
+
+// Функция сериализации изменения
+func marshalChange(change Change) []byte {
+	data := make([]byte, 0, 8+1+3*binary.MaxVarintLen64+len(change.Keyspace)+len(change.Key)+len(change.Val))
+	data = binary.BigEndian.AppendUint64(data, change.Seq)
+	data = append(data, byte(change.Op))
+	data = binary.AppendUvarint(data, uint64(len(change.Keyspace)))
+	data = append(data, change.Keyspace...)
+	data = binary.AppendUvarint(data, uint64(len(change.Key)))
+	data = append(data, change.Key...)
+	data = binary.AppendUvarint(data, uint64(len(change.Val)))
+	data = append(data, change.Val...)
+
+	return data
+}
+
+// Функция десериализации изменения, ключ и значение не длиннее maxKey и maxVal байт
+func unmarshalChange(r *bufio.Reader, maxKey, maxVal int) (Change, error) {
+	var buf [9]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return Change{}, err
+	}
+
+	keyspace, err := readString(r, maxKeyspaceName)
+	if err != nil {
+		return Change{}, fmt.Errorf("keyspace: %w", err)
+	}
+	key, err := readString(r, maxKey)
+	if err != nil {
+		return Change{}, fmt.Errorf("key: %w", err)
+	}
+	val, err := readString(r, maxVal)
+	if err != nil {
+		return Change{}, fmt.Errorf("value: %w", err)
+	}
+
+	return Change{
+		Seq:      binary.BigEndian.Uint64(buf[:8]),
+		Op:       OpType(buf[8]),
+		Keyspace: keyspace,
+		Key:      key,
+		Val:      val,
+	}, nil
+}
+
+// Функция чтения строки с длиной в varint. Длина приходит из сети, поэтому до выделения памяти
+// она проверяется по limit: строки длиннее в хранилище все равно не поместятся.
+func readString(r *bufio.Reader, limit int) (string, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if size > uint64(limit) {
+		return "", fmt.Errorf("%w: length %d exceeds %d", ErrInvalidChange, size, limit)
+	}
+
+	data := make([]byte, size)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// Функция закрытия c при отмене ctx, что бы прервать блокирующее чтение или запись.
+// Возвращает функцию, которая прекращает ожидание.
+func closeOnDone(ctx context.Context, c any) func() {
+	closer, ok := c.(io.Closer)
+	if !ok {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			closer.Close()
+		case <-done:
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// Функция подмены ошибки соединения на ошибку контекста, если соединение закрыто из-за его отмены
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
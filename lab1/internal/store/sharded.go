@@ -0,0 +1,402 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	bkt "debildb/internal/bucket"
+)
+
+const (
+	manifestName    = "MANIFEST.json"
+	manifestVersion = 1
+)
+
+// Манифест шардированного хранилища: какие файлы сейчас составляют хранилище.
+// Шард ключа - его номер в Files. Generation растет при каждом решардинге,
+// файлы нового поколения не пересекаются по именам со старыми.
+type manifest struct {
+	Version    int      `json:"version"`
+	Generation int      `json:"generation"`
+	Files      []string `json:"files"`
+}
+
+// ShardedStore - хранилище из нескольких независимых Store в отдельных файлах одного каталога.
+// Ключ направляется в шард по хэшу, так что записи в разные шарды не конкурируют за одну блокировку и один список директорий.
+// Именованные пространства ключей, история, индекс и подписки доступны через Shard у отдельных шардов.
+type ShardedStore struct {
+	dir    string
+	shards []*Store
+}
+
+// ShardedStats - статистика шардированного хранилища: сумма по шардам и статистика каждого шарда
+type ShardedStats struct {
+	Stats
+	Shards []Stats
+}
+
+// OpenSharded - открывает шардированное хранилище в каталоге dir.
+// Если манифеста нет, создается хранилище из shards шардов; иначе количество шардов берется из манифеста,
+// а ненулевое shards должно с ним совпадать. opts применяются к каждому шарду.
+func OpenSharded(dir string, shards int, opts Options) (*ShardedStore, error) {
+	if opts.PageStore != nil { // одно хранилище страниц не может обслуживать несколько файлов
+		return nil, fmt.Errorf("open sharded: %w: page store is not supported", ErrInvalidOptions)
+	}
+
+	m, err := readManifest(dir)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if shards < 1 {
+			return nil, fmt.Errorf("open sharded: %w: shards %d", ErrInvalidOptions, shards)
+		}
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("open sharded: %w", err)
+		}
+		m = newManifest(1, shards)
+	case err != nil:
+		return nil, fmt.Errorf("open sharded: %w", err)
+	case shards != 0 && shards != len(m.Files):
+		return nil, fmt.Errorf("open sharded: %w: shards is %d, stored %d", ErrOptionsMismatch, shards, len(m.Files))
+	}
+
+	ss := &ShardedStore{dir: dir}
+	for _, file := range m.Files {
+		s, err := OpenStore(filepath.Join(dir, file), opts)
+		if err != nil {
+			ss.Close()
+			return nil, fmt.Errorf("open sharded: %w", err)
+		}
+		ss.shards = append(ss.shards, s)
+	}
+
+	if err = writeManifest(dir, m); err != nil { // манифест пишется, когда все шарды уже созданы
+		ss.Close()
+		return nil, fmt.Errorf("open sharded: %w", err)
+	}
+
+	return ss, nil
+}
+
+// Shards - количество шардов
+func (ss *ShardedStore) Shards() int {
+	return len(ss.shards)
+}
+
+// Shard - шард с номером i
+func (ss *ShardedStore) Shard(i int) *Store {
+	return ss.shards[i]
+}
+
+// SetValue - записывает значение в шард ключа
+func (ss *ShardedStore) SetValue(key, value string) error {
+	return ss.shard(key).SetValue(key, value)
+}
+
+// SetValueContext - записывает значение в шард ключа с отменой и дедлайном из ctx
+func (ss *ShardedStore) SetValueContext(ctx context.Context, key, value string) error {
+	return ss.shard(key).SetValueContext(ctx, key, value)
+}
+
+// GetValue - значение ключа из его шарда
+func (ss *ShardedStore) GetValue(key string) (string, error) {
+	return ss.shard(key).GetValue(key)
+}
+
+// GetValueContext - значение ключа из его шарда с отменой и дедлайном из ctx
+func (ss *ShardedStore) GetValueContext(ctx context.Context, key string) (string, error) {
+	return ss.shard(key).GetValueContext(ctx, key)
+}
+
+// DeleteValue - удаляет ключ из его шарда
+func (ss *ShardedStore) DeleteValue(key string) error {
+	return ss.shard(key).DeleteValue(key)
+}
+
+// DeleteValueContext - удаляет ключ из его шарда с отменой и дедлайном из ctx
+func (ss *ShardedStore) DeleteValueContext(ctx context.Context, key string) error {
+	return ss.shard(key).DeleteValueContext(ctx, key)
+}
+
+// BulkLoad - массовая загрузка: записи раскладываются по шардам и загружаются во все шарды параллельно
+func (ss *ShardedStore) BulkLoad(iter KVIterator) error {
+	return ss.BulkLoadContext(context.Background(), iter)
+}
+
+// BulkLoadContext - массовая загрузка с отменой и дедлайном из ctx.
+// Каждый шард прерывается как Store.BulkLoadContext, но шарды, успевшие загрузиться до отмены, остаются загруженными.
+func (ss *ShardedStore) BulkLoadContext(ctx context.Context, iter KVIterator) error {
+	parts := make([][]bkt.KV, len(ss.shards))
+	for kv, ok := iter.Next(); ok; kv, ok = iter.Next() {
+		i := getShardID(kv.Key, len(ss.shards))
+		parts[i] = append(parts[i], kv)
+	}
+
+	return ss.each(func(i int, s *Store) error {
+		if len(parts[i]) == 0 { // без записей загрузка только переписала бы бакеты шарда с теми же значениями
+			return nil
+		}
+		return s.BulkLoadContext(ctx, NewSliceIterator(parts[i]))
+	})
+}
+
+// Stats - статистика всех шардов, собирается параллельно
+func (ss *ShardedStore) Stats() (ShardedStats, error) {
+	stats := ShardedStats{Shards: make([]Stats, len(ss.shards))}
+	err := ss.each(func(i int, s *Store) error {
+		var err error
+		stats.Shards[i], err = s.Stats()
+		return err
+	})
+	if err != nil {
+		return ShardedStats{}, err
+	}
+
+	for _, st := range stats.Shards {
+		stats.add(st)
+	}
+
+	return stats, nil
+}
+
+// Sync - сбрасывает все шарды на диск параллельно
+func (ss *ShardedStore) Sync() error {
+	return ss.each(func(_ int, s *Store) error { return s.Sync() })
+}
+
+// Close - закрывает все шарды
+func (ss *ShardedStore) Close() error {
+	return ss.each(func(_ int, s *Store) error { return s.Close() })
+}
+
+// Reshard - перераспределяет записи шардированного хранилища в каталоге dir по shards новым шардам.
+// Выполняется офлайн: хранилище должно быть закрыто, файлы шардов блокируются на время решардинга.
+// Записи переносятся как есть, вместе с предыдущими версиями и именованными пространствами ключей.
+// Новое поколение файлов становится действующим в момент замены манифеста, после чего старые файлы удаляются;
+// если решардинг прервется раньше, хранилище останется в прежнем виде.
+func Reshard(dir string, shards int, opts Options) error {
+	if shards < 1 {
+		return fmt.Errorf("reshard: %w: shards %d", ErrInvalidOptions, shards)
+	}
+
+	m, err := readManifest(dir)
+	if err != nil {
+		return fmt.Errorf("reshard: %w", err)
+	}
+
+	old, err := OpenSharded(dir, 0, Options{Backend: opts.Backend, LockTimeout: opts.LockTimeout, Logger: opts.Logger})
+	if err != nil {
+		return fmt.Errorf("reshard: %w", err)
+	}
+	defer old.Close()
+
+	next := newManifest(m.Generation+1, shards)
+	resharded := &ShardedStore{dir: dir}
+	done := false
+	defer func() {
+		resharded.Close()
+		if !done { // недоделанное поколение не нужно
+			for _, file := range next.Files {
+				os.Remove(filepath.Join(dir, file))
+			}
+		}
+	}()
+	for _, file := range next.Files {
+		s, err := NewStoreWithOptions(filepath.Join(dir, file), old.shards[0].cloneOptions(opts))
+		if err != nil {
+			return fmt.Errorf("reshard: %w", err)
+		}
+		resharded.shards = append(resharded.shards, s)
+	}
+
+	for _, s := range old.shards {
+		if err = s.reshardInto(resharded.shards); err != nil {
+			return fmt.Errorf("reshard: %w", err)
+		}
+	}
+	err = resharded.each(func(_ int, ns *Store) error {
+		for _, s := range old.shards {
+			ns.seq = max(ns.seq, s.seq)
+		}
+		if old.shards[0].index != nil { // индекс строится по уже перенесенным записям
+			return ns.buildIndex()
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("reshard: %w", err)
+	}
+
+	if err = resharded.Sync(); err != nil {
+		return fmt.Errorf("reshard: %w", err)
+	}
+	if err = resharded.Close(); err != nil {
+		return fmt.Errorf("reshard: %w", err)
+	}
+
+	if err = writeManifest(dir, next); err != nil {
+		return fmt.Errorf("reshard: %w", err)
+	}
+	done = true
+
+	if err = old.Close(); err != nil {
+		return fmt.Errorf("reshard: %w", err)
+	}
+	for _, file := range m.Files { // старое поколение больше не используется
+		if err = os.Remove(filepath.Join(dir, file)); err != nil {
+			return fmt.Errorf("reshard: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Функция переноса всех записей шарда в новые шарды по хэшу ключа.
+// Предыдущие версии значения направляются в шард самого ключа.
+func (s *Store) reshardInto(shards []*Store) error {
+	copyTable := func(t *table, target func(ns *Store) (*table, error)) error {
+		for _, bucket := range t.buckets() {
+			values, err := bucket.GetBucketValues()
+			if err != nil {
+				return err
+			}
+			for _, kv := range values {
+				key := kv.Key
+				if t == &s.table && !s.isCurrentRecord(kv) {
+					key, _ = parseHistoryKey(key)
+				}
+
+				ns := shards[getShardID(key, len(shards))]
+				nt, err := target(ns)
+				if err != nil {
+					return err
+				}
+				if err = ns.putRecord(nt, kv.Key, kv.Val); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	err := copyTable(&s.table, func(ns *Store) (*table, error) { return &ns.table, nil })
+	if err != nil {
+		return err
+	}
+
+	for name, t := range s.keyspaces {
+		err = copyTable(t, func(ns *Store) (*table, error) {
+			if _, ok := ns.keyspaces[name]; !ok {
+				if err := ns.createKeyspace(name); err != nil {
+					return nil, err
+				}
+			}
+			return ns.keyspaces[name], nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Функция выбора шарда для ключа
+func (ss *ShardedStore) shard(key string) *Store {
+	return ss.shards[getShardID(key, len(ss.shards))]
+}
+
+// Функция параллельного выполнения fn для каждого шарда, ошибки всех шардов объединяются
+func (ss *ShardedStore) each(fn func(i int, s *Store) error) error {
+	errs := make([]error, len(ss.shards))
+	var wg sync.WaitGroup
+	for i, s := range ss.shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(i, s)
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// Функция формирования манифеста поколения generation из shards шардов
+func newManifest(generation, shards int) manifest {
+	m := manifest{Version: manifestVersion, Generation: generation}
+	for i := 0; i < shards; i++ {
+		m.Files = append(m.Files, fmt.Sprintf("shard-%d-%03d.data", generation, i))
+	}
+
+	return m
+}
+
+// Функция чтения манифеста из каталога dir
+func readManifest(dir string) (manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		return manifest{}, err
+	}
+
+	var m manifest
+	if err = json.Unmarshal(data, &m); err != nil {
+		return manifest{}, fmt.Errorf("%w: manifest: %w", ErrInvalidHeader, err)
+	}
+	if m.Version != manifestVersion || len(m.Files) == 0 {
+		return manifest{}, fmt.Errorf("%w: manifest version %d, %d shards", ErrUnsupportedFormat, m.Version, len(m.Files))
+	}
+
+	return m, nil
+}
+
+// Функция атомарной записи манифеста: через временный файл и переименование
+func writeManifest(dir string, m manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(dir, manifestName+".tmp")
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+
+	if err = os.Rename(tmp, filepath.Join(dir, manifestName)); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+
+	if err = syncDir(dir); err != nil { // без этого после сбоя каталог может вернуться к старому манифесту
+		return fmt.Errorf("write manifest: %w", err)
+	}
+
+	return nil
+}
+
+// Функция сброса на диск записи каталога dir, в том числе переименований файлов в нем
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"fmt"
+)
+
+// Stats - статистика хранилища
+type Stats struct {
+	Keys      int   // живые ключи основного пространства ключей
+	Records   int   // записи во всех пространствах ключей, включая предыдущие версии значений
+	Buckets   int   // бакеты всех пространств ключей вместе с бакетами переполнения
+	FreePages int   // освобожденные страницы, которые будут заняты заново
+	Size      int64 // размер файла бд в байтах
+}
+
+// Stats - собирает статистику хранилища. Читает все бакеты, поэтому не предназначена для частых вызовов.
+func (s *Store) Stats() (Stats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records, err := s.recordCount()
+	if err != nil {
+		return Stats{}, fmt.Errorf("store - Stats: %w", err)
+	}
+
+	stats := Stats{
+		Records:   records,
+		Buckets:   len(s.buckets()),
+		FreePages: len(s.free),
+		Size:      int64(s.endOffset),
+	}
+	for _, t := range s.keyspaces {
+		stats.Buckets += len(t.buckets())
+	}
+
+	for _, bucket := range s.buckets() {
+		values, err := bucket.GetBucketValues()
+		if err != nil {
+			return Stats{}, fmt.Errorf("store - Stats: %w", err)
+		}
+		for _, kv := range values {
+			if !s.isCurrentRecord(kv) {
+				continue
+			}
+			if meta, _, err := decodeVersion(kv.Val); s.opts.History && err == nil && meta.flags&versionDeleted != 0 { // надгробие
+				continue
+			}
+			stats.Keys++
+		}
+	}
+
+	return stats, nil
+}
+
+// Функция сложения статистики
+func (st *Stats) add(other Stats) {
+	st.Keys += other.Keys
+	st.Records += other.Records
+	st.Buckets += other.Buckets
+	st.FreePages += other.FreePages
+	st.Size += other.Size
+}
@@ -1,44 +1,62 @@
 package store
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math"
-	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"debildb/internal/btree"
 	bkt "debildb/internal/bucket"
+	"debildb/internal/pagestore"
 
 	"go.uber.org/zap"
 )
 
 const (
 	defaultGlobalDepth int = 1
-	defaultLocalDepth  int = 1
-	lenKV                  = 1365
 )
 
 var (
-	pageSize = os.Getpagesize()
+	ErrMaxDepthReached = errors.New("max global depth reached")
+	ErrReadOnly        = errors.New("store is opened read-only")
+	ErrFollower        = errors.New("store is following a primary")
+	ErrClosed          = errors.New("store is closed")
 )
 
 // Главня аструктура хранилища
 type Store struct {
-	dirList     []Directory
-	globalDepth int
-	pathToDB    string
-	endOffset   int
-	log         *zap.Logger
+	table                       // основное пространство ключей
+	keyspaces map[string]*table // именованные пространства ключей
+	free      []int             // освобожденные страницы, которые можно занять заново
+	pathToDB  string
+	pages     pagestore.PageStore
+	endOffset int
+	opts      Options
+	bktOpts   bkt.Options
+	committer *groupCommitter
+	seq       uint64      // номер последнего изменения
+	changes   *changeLog  // последние изменения для репликации
+	watchers  *watchers   // подписки на изменения ключей
+	index     *btree.Tree // упорядоченный индекс ключей, nil - индекс не ведется
+	log       *zap.Logger // события жизненного цикла: открытие, массовая загрузка, реплики
+	obs       Observer    // события операций
+	mu        sync.RWMutex
+	closed    atomic.Bool  // хранилище закрыто, записи отклоняются
+	health    atomic.Int32 // Health
+	following atomic.Bool  // хранилище применяет изменения основного, локальные записи отклоняются
 }
 
-// NewStore - инициализирует хранилище с базовыми значениями
+// NewStore - инициализирует хранилище с базовыми значениями, события хранилища пишутся в log
 func NewStore(pathDB string, log *zap.Logger) *Store {
-	store := &Store{
-		pathToDB:    pathDB,
-		globalDepth: defaultGlobalDepth,
-		log:         log,
-	}
+	opts := DefaultOptions()
+	opts.Logger = log
+	opts.Observer = NewZapObserver(log)
 
-	err := store.InitDefaultDirectoryList() // инициализация начального списка из двух директорий и двух бакетов
+	store, err := NewStoreWithOptions(pathDB, opts)
 	if err != nil {
 		log.Fatal("new store", zap.Error(err))
 	}
@@ -46,72 +64,469 @@ func NewStore(pathDB string, log *zap.Logger) *Store {
 	return store
 }
 
+// NewStoreWithOptions - создает новое хранилище с заданными параметрами.
+// Содержимое файла бд, если он уже существовал, отбрасывается.
+func NewStoreWithOptions(pathDB string, opts Options) (*Store, error) {
+	opts = opts.withDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, fmt.Errorf("new store: %w", err)
+	}
+	if opts.ReadOnly {
+		return nil, fmt.Errorf("new store: %w", ErrReadOnly)
+	}
+
+	pages, err := opts.openPages(pathDB)
+	if err != nil {
+		return nil, fmt.Errorf("new store: %w", err)
+	}
+
+	store, err := createStore(pathDB, pages, opts)
+	if err != nil {
+		opts.closePages(pages)
+		return nil, fmt.Errorf("new store: %w", err)
+	}
+
+	return store, nil
+}
+
+// OpenStore - открывает существующее хранилище.
+// Параметры бакетов берутся из заголовка файла, пустой или отсутствующий файл инициализируется как новое хранилище.
+func OpenStore(pathDB string, opts Options) (*Store, error) {
+	pages, err := opts.withDefaults().openPages(pathDB)
+	if err != nil {
+		return nil, fmt.Errorf("open store: %w", err)
+	}
+
+	store, err := openStore(pathDB, pages, opts)
+	if err != nil {
+		opts.closePages(pages)
+		return nil, fmt.Errorf("open store: %w", err)
+	}
+
+	return store, nil
+}
+
+// Функция инициализации нового хранилища поверх pages
+func createStore(pathDB string, pages pagestore.PageStore, opts Options) (*Store, error) {
+	store := newStore(pathDB, pages, opts)
+	store.globalDepth = opts.InitialGlobalDepth
+
+	if err := pages.Truncate(0); err != nil { // старые страницы больше не нужны
+		return nil, fmt.Errorf("truncate: %w", err)
+	}
+
+	if err := store.writeHeader(); err != nil {
+		return nil, err
+	}
+
+	err := store.InitDefaultDirectoryList() // инициализация начального списка директорий и бакетов
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.OrderedIndex {
+		if err = store.buildIndex(); err != nil {
+			return nil, err
+		}
+	}
+
+	store.startCommitter()
+
+	return store, nil
+}
+
+// Функция открытия хранилища, уже записанного в pages
+func openStore(pathDB string, pages pagestore.PageStore, opts Options) (*Store, error) {
+	size, err := pages.Size()
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 { // пустой файл - создаем новое хранилище
+		if opts.ReadOnly {
+			return nil, fmt.Errorf("%w: database is empty", ErrReadOnly)
+		}
+		opts = opts.withDefaults()
+		if err = opts.validate(); err != nil {
+			return nil, err
+		}
+		return createStore(pathDB, pages, opts)
+	}
+
+	h, err := readHeader(pages)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err = opts.applyHeader(h)
+	if err != nil {
+		return nil, err
+	}
+	if err = opts.validate(); err != nil {
+		return nil, err
+	}
+
+	store := newStore(pathDB, pages, opts)
+	store.globalDepth = h.globalDepth
+	store.split = h.split
+	store.endOffset = int(size)
+	store.seq = h.seq
+	if h.flags&headerAltBuckets != 0 { // последняя массовая загрузка записала бакеты с другим типом страниц
+		store.kind = bkt.KindBucketAlt
+	}
+
+	if err = store.loadKeyspaces(h.keyspaces); err != nil {
+		return nil, err
+	}
+	if err = store.loadDirectoryList(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case h.indexRoot != 0:
+		store.index = store.openIndex(h.indexRoot)
+	case opts.OrderedIndex: // индекс запрошен для хранилища, в котором его еще не было
+		if opts.ReadOnly {
+			return nil, fmt.Errorf("%w: ordered index is not built", ErrReadOnly)
+		}
+		if err = store.buildIndex(); err != nil {
+			return nil, err
+		}
+	}
+
+	store.startCommitter()
+
+	store.log.Info("Successful open store", zap.Int("globalDepth", store.globalDepth))
+
+	return store, nil
+}
+
+// Функция создания структуры хранилища без инициализации директорий
+func newStore(pathDB string, pages pagestore.PageStore, opts Options) *Store {
+	return &Store{
+		pathToDB:  pathDB,
+		pages:     pages,
+		endOffset: opts.PageSize, // первая страница файла занята заголовком
+		opts:      opts,
+		bktOpts:   opts.bucketOptions(),
+		keyspaces: make(map[string]*table),
+		changes:   newChangeLog(opts.ChangeLogSize),
+		watchers:  newWatchers(opts.WatchBuffer),
+		log:       opts.Logger,
+		obs:       opts.Observer,
+	}
+}
+
 // Структура директории
 type Directory struct {
-	index      byte
+	index      int
 	bucket     *bkt.Bucket
 	localDepth int
+	overflow   []*bkt.Bucket // цепочка переполнения, только для линейного хэширования
 }
 
-// Функция начальной инициализации списка диреткорий и бакетов
+// Функция начальной инициализации списка диреткорий и бакетов.
+// Создается по одному бакету на каждую директорию начальной глобальной глубины.
 func (s *Store) InitDefaultDirectoryList() error {
-	bkt1, endOffset, err := bkt.CreateBucket(s.pathToDB, s.endOffset)	// Создание первого бакета
-	if err != nil {
-		return fmt.Errorf("new default directory list: %w", err)
+	if err := s.initDirectoryList(&s.table); err != nil {
+		return err
 	}
-	s.endOffset = endOffset // обновляем указатель на конец бд
 
-	bkt2, endOffset, err := bkt.CreateBucket(s.pathToDB, s.endOffset) // Создание второго бакета
-	if err != nil {
-		return fmt.Errorf("new default directory list: %w", err)
+	s.log.Info("Successful init store")
+
+	return nil
+}
+
+// Функция начальной инициализации списка директорий и бакетов хэш-таблицы t
+func (s *Store) initDirectoryList(t *table) error {
+	t.globalDepth = s.opts.InitialGlobalDepth
+	countDir := 1 << t.globalDepth
+	t.dirList = make([]Directory, countDir)
+
+	for i := 0; i < countDir; i++ {
+		bucket, err := s.createBucket(t, t.globalDepth, i) // Создание бакета
+		if err != nil {
+			t.dirList = t.dirList[:i] // в списке остаются только созданные бакеты, что бы их можно было освободить
+			return fmt.Errorf("new default directory list: %w", err)
+		}
+
+		t.dirList[i] = Directory{
+			index:      i,
+			bucket:     bucket,
+			localDepth: t.globalDepth,
+		}
 	}
-	s.endOffset = endOffset // обновляем указатель на конец бд
 
-	s.dirList = []Directory{
-		{
-			index:      0,
-			bucket:     bkt1,
-			localDepth: defaultLocalDepth,
-		},
-		{
-			index:      1,
-			bucket:     bkt2,
-			localDepth: defaultLocalDepth,
-		},
+	return nil
+}
+
+// Функция восстановления списков директорий всех пространств ключей по заголовкам бакетов.
+// Бакет с локальной глубиной d и битами хэша p обслуживает все директории, у которых последние d бит равны p.
+func (s *Store) loadDirectoryList() error {
+	bucketSize := s.bktOpts.Size()
+	if s.endOffset < s.opts.PageSize {
+		return fmt.Errorf("load directory list: %w: file size %d", ErrInvalidHeader, s.endOffset)
+	}
+	if tail := (s.endOffset - s.opts.PageSize) % bucketSize; tail != 0 { // дописывание страницы в конец файла оборвал сбой
+		s.log.Warn("torn page at the end of file", zap.Int("offset", s.endOffset-tail), zap.Int("size", tail))
+		s.endOffset -= tail
+		if !s.opts.ReadOnly {
+			if err := s.pages.Truncate(int64(s.endOffset)); err != nil {
+				return fmt.Errorf("load directory list - truncate: %w", err)
+			}
+		}
 	}
 
-	s.log.Info("Successful init store")
+	tables := map[byte]*table{s.kind: &s.table}
+	for _, t := range s.keyspaces {
+		tables[t.kind] = t
+	}
+	for _, t := range tables {
+		t.dirList = make([]Directory, 1<<t.globalDepth+t.split)
+	}
+
+	for offset := s.opts.PageSize; offset < s.endOffset; offset += bucketSize {
+		kind, err := bkt.PageKind(s.pages, offset)
+		if err != nil {
+			return fmt.Errorf("load directory list: %w", err)
+		}
+		if kind == bkt.KindIndex { // страницы индекса пропускаем
+			continue
+		}
+
+		t, ok := tables[kind]
+		switch {
+		case kind == bkt.KindFree:
+			s.free = append(s.free, offset)
+			continue
+		case !ok: // бакет пространства ключей, удаление которого прервал сбой
+			if s.opts.ReadOnly { // освободит пишущий процесс
+				continue
+			}
+			if err = s.freePage(offset); err != nil {
+				return fmt.Errorf("load directory list: %w", err)
+			}
+			continue
+		}
+
+		bucket := bkt.OpenBucket(s.pages, offset, s.tableOptions(t))
+		localDepth, pattern, err := bucket.GetMeta()
+		if err != nil {
+			return fmt.Errorf("load directory list: %w", err)
+		}
+		if s.opts.Scheme == SchemeLinear { // в линейном хэшировании бакет обслуживает ровно один адрес
+			if err = t.addLinearBucket(bucket, localDepth, pattern); err != nil {
+				return fmt.Errorf("load directory list: %w", err)
+			}
+			continue
+		}
+		if localDepth > t.globalDepth {
+			return fmt.Errorf("load directory list: %w: local depth %d", ErrInvalidHeader, localDepth)
+		}
+
+		for index := pattern; index < len(t.dirList); index += 1 << localDepth {
+			t.dirList[index] = Directory{
+				index:      index,
+				bucket:     bucket,
+				localDepth: localDepth,
+			}
+		}
+	}
+
+	for _, t := range tables {
+		for i, dir := range t.dirList { // на каждую директорию должен найтись бакет
+			if dir.bucket == nil {
+				return fmt.Errorf("load directory list: %w: directory %d has no bucket", ErrInvalidHeader, i)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Функция сохранения заголовка бд с текущей глобальной глубиной
+func (s *Store) writeHeader() error {
+	return writeHeader(s.pages, s.header(), s.bktOpts.Sync)
+}
+
+// Функция формирования заголовка бд по текущему состоянию хранилища
+func (s *Store) header() header {
+	h := newHeader(s.opts, s.globalDepth, s.seq, s.indexRoot())
+	h.split = s.split
+	h.keyspaces = s.keyspaceMetas()
+	if s.kind == bkt.KindBucketAlt {
+		h.flags |= headerAltBuckets
+	}
+
+	return h
+}
+
+// Функция проверки, что хранилище принимает локальные записи
+func (s *Store) writable() error {
+	if s.following.Load() {
+		return ErrFollower
+	}
+	return s.storageWritable()
+}
+
+// Функция проверки, что хранилище открыто на запись и место на диске не закончилось
+func (s *Store) storageWritable() error {
+	if s.closed.Load() {
+		return ErrClosed
+	}
+	if s.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if s.Health() == HealthDiskFull {
+		return ErrDiskFull
+	}
+	return nil
+}
+
+// Функция захвата блокировки хранилища на запись для вызова с ctx.
+// Само ожидание блокировки не прерывается: RWMutex нельзя ждать с отменой, а опрос через TryLock
+// уступал бы читателям бесконечно. Вместо этого ctx проверяется до и после ожидания,
+// так что вызов, чей дедлайн истек в очереди, ничего не меняет.
+// Реплика проверяется еще раз под блокировкой: Follow включает ее тоже под блокировкой,
+// поэтому локальная запись, начавшаяся до Follow, не сдвинет номер изменений реплики.
+// Так же под блокировкой проверяется закрытие: запись, дождавшаяся блокировки после Close, не дойдет до закрытого файла.
+func (s *Store) lockContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if err := ctx.Err(); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if s.following.Load() {
+		s.mu.Unlock()
+		return ErrFollower
+	}
+	if s.closed.Load() {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+
+	return nil
+}
+
+// Функция захвата блокировки хранилища на чтение для вызова с ctx, аналогично lockContext
+func (s *Store) rlockContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	if err := ctx.Err(); err != nil {
+		s.mu.RUnlock()
+		return err
+	}
 
 	return nil
 }
 
 // Функция загрузки значения
 func (s *Store) SetValue(key, value string) error {
-	index := getDirID(key, s.globalDepth) // получаем id директории по ключу
+	return s.SetValueContext(context.Background(), key, value)
+}
+
+// SetValueContext - загрузка значения с отменой и дедлайном из ctx.
+// Отмена проверяется перед каждым сплитом бакета и глобальным ресайзом: прерванная запись оставляет
+// хранилище согласованным, уже выполненные сплиты сохраняются, а само значение не записывается.
+// Когда значение уже записано, вызов не прерывается и дожидается сброса на диск согласно политике.
+func (s *Store) SetValueContext(ctx context.Context, key, value string) (err error) {
+	defer s.observeWrite(time.Now(), "set", Change{Op: OpPut, Key: key, Val: value}, &err)
+
+	if err := s.writable(); err != nil {
+		return fmt.Errorf("store - SetValue: %w", err)
+	}
+
+	if err := s.lockContext(ctx); err != nil {
+		return fmt.Errorf("store - SetValue: %w", err)
+	}
+	old := s.watchedValue(key)
+	err = s.putValue(ctx, key, value)
+	if err == nil {
+		s.recordChange(Change{Op: OpPut, Key: key, Val: value}, old)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
 
-	if int(index) > len(s.dirList) { // проверка на то, что id директории валидный
+	return s.commit() // ждем, пока запись станет durable согласно политике сброса
+}
+
+// Функция загрузки значения без блокировки хранилища вместе с обновлением истории и индекса
+func (s *Store) putValue(ctx context.Context, key, value string) error {
+	if err := s.reservePut(key); err != nil {
+		return err
+	}
+
+	put := s.setValue
+	if s.opts.History {
+		put = s.putVersion
+	}
+	if err := put(ctx, key, value); err != nil {
+		return s.checkDiskFull(err)
+	}
+
+	return s.checkDiskFull(s.indexInsert(key))
+}
+
+// Функция загрузки значения в хэш-таблицу основного пространства ключей без блокировки хранилища
+func (s *Store) setValue(ctx context.Context, key, value string) error {
+	return s.putRecordContext(ctx, &s.table, key, value)
+}
+
+// Функция загрузки значения в хэш-таблицу t без блокировки хранилища
+func (s *Store) putRecord(t *table, key, value string) error {
+	return s.putRecordContext(context.Background(), t, key, value)
+}
+
+// Функция загрузки значения в хэш-таблицу t без блокировки хранилища с отменой из ctx.
+// Отмена проверяется только до изменения страниц и между сплитами, когда хэш-таблица согласована;
+// записи, переносимые при сплите, кладутся без отмены.
+func (s *Store) putRecordContext(ctx context.Context, t *table, key, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if s.opts.Scheme == SchemeLinear {
+		return s.linearPut(t, key, value)
+	}
+
+	index := getDirID(key, t.globalDepth) // получаем id директории по ключу
+
+	if index >= len(t.dirList) { // проверка на то, что id директории валидный
 		return fmt.Errorf("invalid index")
 	}
 
-	dir := s.dirList[int(index)]
+	dir := t.dirList[index]
 	err := dir.bucket.PutValue(&bkt.KV{Key: key, Val: value}) // Пытаемся положить значение
 	if err != nil {
 		if errors.Is(err, bkt.ErrBucketIsFull) { // Если получаем ошибку того, что бакет переполнен, значит нужен или глобальный ресайз или сплит
-			if dir.localDepth < s.globalDepth { // если local depth меньше чем global depth - значит можем просто сплитануть бакет без глобального ресайза
-				err := s.splitBucket(dir, dir.bucket) // сплитуем бакет
+			if dir.localDepth < t.globalDepth { // если local depth меньше чем global depth - значит можем просто сплитануть бакет без глобального ресайза
+				err := s.splitBucket(t, dir, dir.bucket) // сплитуем бакет
 				if err != nil {
 					return fmt.Errorf("store - SetValue: %w", err)
 				}
 
-				err = s.SetValue(key, value) // Снова пытаемся положить значение
+				err = s.putRecordContext(ctx, t, key, value) // Снова пытаемся положить значение
 				if err != nil {
 					return fmt.Errorf("recircive call set value 1: %w", err)
 				}
 				return nil
 			}
 			// если global depth == local depth значит требуется глобальный ресайз
-			s.globalResize() // выполняем глобальный ресайз
-			err = s.SetValue(key, value) // Заново пытаемся положить значнеие (на практике будет опять ошибка и уже в этот раз мы попадем на сплит бакета, в процессе которого уже значение положиться нормально)
+			if t.globalDepth >= maxGlobalDepth {
+				return fmt.Errorf("store - SetValue: %w", ErrMaxDepthReached)
+			}
+			if err = s.globalResize(t); err != nil { // выполняем глобальный ресайз
+				return fmt.Errorf("store - SetValue: %w", err)
+			}
+			err = s.putRecordContext(ctx, t, key, value) // Заново пытаемся положить значнеие (на практике будет опять ошибка и уже в этот раз мы попадем на сплит бакета, в процессе которого уже значение положиться нормально)
 			if err != nil {
 				return fmt.Errorf("recircive call set value 2: %w", err)
 			}
@@ -120,50 +535,53 @@ func (s *Store) SetValue(key, value string) error {
 		return fmt.Errorf("store - SetValue: %w", err)
 	}
 
-	s.log.Info("Save data", zap.Int("directory", int(dir.index)), zap.Int("bucket", dir.bucket.GetBucketID()), zap.String("key", key), zap.String("value", value))
-
 	return nil
 }
 
 // Функция глобального рейсайза директорий
-func (s *Store) globalResize() {
-	s.log.Info("global resize")
-	newGlobalDepth := s.globalDepth + 1                     // увеличиваем globalDepth
+func (s *Store) globalResize(t *table) error {
+	newGlobalDepth := t.globalDepth + 1                     // увеличиваем globalDepth
 	countDir := int(math.Pow(2.0, float64(newGlobalDepth))) // считываем кол-во директорий, которое будет после ресайза
 	newDirList := make([]Directory, countDir)
 
 	for i := 0; i < countDir; i++ { // цикл формирования новых директорий
-		index := byte(i)                  // получаем индекс директории (идентификатор)
-		mask := (1 << s.globalDepth) - 1  // считаем маску для последних битов, количество каторых равно старому globalDepth
-		targetIndex := index & byte(mask) // применяем маску что бы определить идентфикаторы диреткорий в старом списке директорий что бы понять на какой бакет должна указывать директория
+		mask := (1 << t.globalDepth) - 1 // считаем маску для последних битов, количество каторых равно старому globalDepth
+		targetIndex := i & mask          // применяем маску что бы определить идентфикаторы диреткорий в старом списке директорий что бы понять на какой бакет должна указывать директория
 
 		newDirList[i] = Directory{
-			index:      byte(i),
-			bucket:     s.dirList[targetIndex].bucket,
-			localDepth: s.dirList[targetIndex].localDepth,
+			index:      i,
+			bucket:     t.dirList[targetIndex].bucket,
+			localDepth: t.dirList[targetIndex].localDepth,
 		}
 	}
 
-	s.dirList = newDirList
-	s.globalDepth = newGlobalDepth
+	t.dirList = newDirList
+	t.globalDepth = newGlobalDepth
+
+	if err := s.writeHeader(); err != nil { // глобальная глубина хранится в заголовке
+		return fmt.Errorf("global resize: %w", err)
+	}
+
+	s.obs.OnResize(ResizeEvent{Keyspace: t.name, GlobalDepth: t.globalDepth})
+
+	return nil
 }
 
 // Функция разделения бакета
-func (s *Store) splitBucket(oldDir Directory, oldBucket *bkt.Bucket) error {
-	s.log.Info("split bucket", zap.Int("bucket", oldBucket.GetBucketID()))
+func (s *Store) splitBucket(t *table, oldDir Directory, oldBucket *bkt.Bucket) error {
+	pattern := oldDir.index & ((1 << oldDir.localDepth) - 1) // биты хэша, общие для ключей разделяемого бакета
 
-	newBkt, endOffset, err := bkt.CreateBucket(s.pathToDB, s.endOffset) // Создаем новый бакет
+	newBkt, err := s.createBucket(t, oldDir.localDepth+1, pattern|1<<oldDir.localDepth) // Создаем новый бакет
 	if err != nil {
 		return fmt.Errorf("split bucket: %w", err)
 	}
-	s.endOffset = endOffset
 
 	records, err := oldBucket.GetBucketValues() // Получаем значения из бакета для дальнейшего их перераспределения
 	if err != nil {
 		return fmt.Errorf("split bucket: %w", err)
 	}
 
-	err = oldBucket.SetBucketIsEmpty() // Отчищаем бакет, у которого только что вытащили значения
+	err = oldBucket.SetBucketIsEmpty(oldDir.localDepth+1, pattern) // Отчищаем бакет, у которого только что вытащили значения
 	if err != nil {
 		return fmt.Errorf("error in split - empty: %w", err)
 	}
@@ -171,46 +589,147 @@ func (s *Store) splitBucket(oldDir Directory, oldBucket *bkt.Bucket) error {
 	// Перестановка указателей
 	firstIndex := -1
 	mask := (1 << (oldDir.localDepth + 1)) - 1 // определяем маску, что бы определить какие директории указывали на бакет в старой версии списка директорий
-	for i := 0; i < len(s.dirList); i++ {
-		if s.dirList[i].bucket == oldBucket { // если мы находим директорию, которая указывала на бакет, и это впервые то помечаем ее как первый тип (т к в итоге у нас всегда будет два типа окончание битов)
+	for i := 0; i < len(t.dirList); i++ {
+		if t.dirList[i].bucket == oldBucket { // если мы находим директорию, которая указывала на бакет, и это впервые то помечаем ее как первый тип (т к в итоге у нас всегда будет два типа окончание битов)
 			if firstIndex == -1 {
-				firstIndex = int(s.dirList[i].index) & mask
+				firstIndex = t.dirList[i].index & mask
 			} else { // в случае если это не первый раз смотрим первый тип это или второй и в завимисоти от этого прикрепляем указатель на новый бакет или нет. Если первый тип - старый бакет. Второй тип - новый.
-				if ((s.dirList[i].index) & byte(mask)) != byte(firstIndex) {
-					s.dirList[i].bucket = newBkt
+				if (t.dirList[i].index & mask) != firstIndex {
+					t.dirList[i].bucket = newBkt
 				}
 			}
-			s.dirList[i].localDepth++ // В любом случае если мы находим нужную директорию нужно увеличить local depth т к по факту у бакета это уже новая версия
+			t.dirList[i].localDepth++ // В любом случае если мы находим нужную директорию нужно увеличить local depth т к по факту у бакета это уже новая версия
 		}
 	}
 
+	if s.opts.History && t == &s.table { // бакет переписывается - заодно удаляем версии, вышедшие за политику хранения
+		records = s.retainHistory(records, s.currentVersion)
+	}
+
 	for _, kv := range records { // Заново заполянем значения, которые до этого достали из переполненного бакета
-		err := s.SetValue(kv.Key, kv.Val)
+		err := s.putRecord(t, kv.Key, kv.Val)
 		if err != nil {
 			return fmt.Errorf("error in split - set value: %w", err)
 		}
 	}
 
-	s.log.Info("split bucket completed")
+	s.obs.OnSplit(SplitEvent{
+		Keyspace:   t.name,
+		Bucket:     oldBucket.GetBucketID(),
+		NewBucket:  newBkt.GetBucketID(),
+		LocalDepth: oldDir.localDepth + 1,
+		Records:    len(records),
+	})
 
 	return nil
 }
 
 // Функция получения значнеия по ключу
 func (s *Store) GetValue(key string) (string, error) {
-	index := getDirID(key, s.globalDepth) // высчитываем id дирекотрии где должна находиться запись
+	return s.GetValueContext(context.Background(), key)
+}
+
+// GetValueContext - получение значения по ключу с отменой и дедлайном из ctx
+func (s *Store) GetValueContext(ctx context.Context, key string) (val string, err error) {
+	defer s.observeGet(time.Now(), "", key, &err)
 
-	if int(index) > len(s.dirList) { // проверяем на всякий что индекс валиден
+	if err = s.rlockContext(ctx); err != nil {
+		return "", fmt.Errorf("store get value: %w", err)
+	}
+	defer s.mu.RUnlock()
+
+	return s.getValue(key)
+}
+
+// Функция получения записи из хэш-таблицы основного пространства ключей без блокировки хранилища
+func (s *Store) getRaw(key string) (string, error) {
+	return s.getRecord(&s.table, key)
+}
+
+// Функция получения записи из хэш-таблицы t без блокировки хранилища
+func (s *Store) getRecord(t *table, key string) (string, error) {
+	if s.opts.Scheme == SchemeLinear {
+		return s.linearGet(t, key)
+	}
+
+	index := getDirID(key, t.globalDepth) // высчитываем id дирекотрии где должна находиться запись
+
+	if index >= len(t.dirList) { // проверяем на всякий что индекс валиден
 		return "", fmt.Errorf("invalid index")
 	}
 
-	dir := s.dirList[int(index)] // получаем нужную директорию
-	kv, err := dir.bucket.GetValue(key) // получаем значение 
+	dir := t.dirList[index]             // получаем нужную директорию
+	kv, err := dir.bucket.GetValue(key) // получаем значение
 	if err != nil {
 		return "", fmt.Errorf("store get value: %w", err)
 	}
 
-	s.log.Info("Get data", zap.Int("directory", int(dir.index)), zap.Int("bucket", dir.bucket.GetBucketID()))
-
 	return kv.Val, nil
 }
+
+// DeleteValue - удаляет значение по ключу
+func (s *Store) DeleteValue(key string) error {
+	return s.DeleteValueContext(context.Background(), key)
+}
+
+// DeleteValueContext - удаление значения по ключу с отменой и дедлайном из ctx.
+// В режиме истории удаление пишет надгробие и может прерваться так же, как SetValueContext.
+func (s *Store) DeleteValueContext(ctx context.Context, key string) (err error) {
+	defer s.observeWrite(time.Now(), "delete", Change{Op: OpDelete, Key: key}, &err)
+
+	if err := s.writable(); err != nil {
+		return fmt.Errorf("store - DeleteValue: %w", err)
+	}
+
+	if err := s.lockContext(ctx); err != nil {
+		return fmt.Errorf("store - DeleteValue: %w", err)
+	}
+	old := s.watchedValue(key)
+	err = s.deleteValue(ctx, key)
+	if err == nil {
+		s.recordChange(Change{Op: OpDelete, Key: key}, old)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return s.commit()
+}
+
+// Функция удаления значения без блокировки хранилища
+func (s *Store) deleteValue(ctx context.Context, key string) error {
+	// удаление меняет одну страницу и не может вызвать сплит, поэтому отмена ему не нужна
+	del := func(_ context.Context, key string) error { return s.deleteRecord(&s.table, key) }
+	if s.opts.History { // в режиме истории запись не удаляется, вместо нее пишется надгробие
+		if err := s.reservePut(key); err != nil {
+			return fmt.Errorf("store delete value: %w", err)
+		}
+		del = s.deleteVersion
+	}
+	if err := del(ctx, key); err != nil {
+		return fmt.Errorf("store delete value: %w", s.checkDiskFull(err))
+	}
+
+	if err := s.indexDelete(key); err != nil {
+		return fmt.Errorf("store delete value: %w", err)
+	}
+
+	return nil
+}
+
+// Функция удаления записи из хэш-таблицы t без блокировки хранилища
+func (s *Store) deleteRecord(t *table, key string) error {
+	if s.opts.Scheme == SchemeLinear {
+		return s.linearDelete(t, key)
+	}
+
+	index := getDirID(key, t.globalDepth) // высчитываем id дирекотрии где должна находиться запись
+
+	if index >= len(t.dirList) {
+		return fmt.Errorf("invalid index")
+	}
+
+	dir := t.dirList[index]
+	return dir.bucket.DeleteValue(key)
+}
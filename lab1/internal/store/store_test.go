@@ -1,11 +1,33 @@
 package store
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
+
+	bkt "debildb/internal/bucket"
+	"debildb/internal/pagestore"
+	"debildb/internal/parser"
 
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 // Функция тестирования процесса иницализации хранилища
@@ -24,14 +46,17 @@ func TestNewStore(t *testing.T) {
 	require.NoError(t, err)
 
 	stor := NewStore(tmpDBFile.Name(), testLogger(t))
+	defer stor.Close()
 	require.Equal(t, stor.globalDepth, defaultGlobalDepth)
 	require.Equal(t, stor.pathToDB, tmpDBFile.Name())
 	require.Equal(t, len(stor.dirList), 2)
-	require.Equal(t, stor.endOffset, 2*pageSize)
+
+	pageSize := os.Getpagesize()
+	require.Equal(t, stor.endOffset, 3*pageSize) // заголовок + два бакета
 
 	tmpDBFile, err = os.Open(tmpDBFile.Name())
 	require.NoError(t, err)
-	require.Equal(t, getSizeFile(t, tmpDBFile), 2*int64(pageSize))
+	require.Equal(t, getSizeFile(t, tmpDBFile), 3*int64(pageSize))
 
 	err = tmpDBFile.Close()
 	require.NoError(t, err)
@@ -53,6 +78,7 @@ func TestStore(t *testing.T) {
 	require.NoError(t, err)
 
 	stor := NewStore(tmpDBFile.Name(), testLogger(t))
+	defer stor.Close()
 
 	for _, tc := range []struct {
 		name string
@@ -136,6 +162,1824 @@ func TestStore(t *testing.T) {
 	}
 }
 
+// Функция тестирования хранилища с параметрами и повторного открытия
+func TestOpenStore(t *testing.T) {
+	tmpDBFile, err := os.CreateTemp("", "example-*.data")
+	require.NoError(t, err)
+	defer func() {
+		err = os.Remove(tmpDBFile.Name())
+		require.NoError(t, err)
+	}()
+
+	err = tmpDBFile.Close()
+	require.NoError(t, err)
+
+	opts := Options{
+		BucketPages:        2,
+		InitialGlobalDepth: 2,
+		MaxKeySize:         32,
+		MaxValueSize:       200,
+		Logger:             testLogger(t),
+	}
+	stor, err := NewStoreWithOptions(tmpDBFile.Name(), opts)
+	require.NoError(t, err)
+	defer stor.Close()
+	require.Equal(t, 4, len(stor.dirList))
+	require.Equal(t, 2*os.Getpagesize(), stor.bktOpts.Size())
+
+	err = stor.SetValue(strings.Repeat("k", 33), "val") // ключ длиннее MaxKeySize
+	require.ErrorIs(t, err, parser.ErrKeyTooLong)
+
+	for i := 0; i < 200; i++ {
+		err = stor.SetValue(fmt.Sprintf("key-%d", i), fmt.Sprintf("val-%d", i))
+		require.NoError(t, err)
+	}
+
+	_, err = OpenStore(tmpDBFile.Name(), Options{}) // файл заблокирован открытым хранилищем
+	require.ErrorIs(t, err, pagestore.ErrLocked)
+	require.NoError(t, stor.Close())
+
+	reopened, err := OpenStore(tmpDBFile.Name(), Options{Logger: testLogger(t)}) // параметры берутся из заголовка
+	require.NoError(t, err)
+	defer reopened.Close()
+	require.Equal(t, stor.globalDepth, reopened.globalDepth)
+	require.Equal(t, stor.endOffset, reopened.endOffset)
+	require.Equal(t, stor.bktOpts, reopened.bktOpts)
+
+	for i := 0; i < 200; i++ {
+		val, err := reopened.GetValue(fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("val-%d", i), val)
+	}
+	require.NoError(t, reopened.Close())
+
+	_, err = OpenStore(tmpDBFile.Name(), Options{BucketPages: 1}) // расходится с заголовком
+	require.ErrorIs(t, err, ErrOptionsMismatch)
+
+	_, err = NewStoreWithOptions(tmpDBFile.Name(), Options{MaxValueSize: 1 << 20}) // запись не помещается в бакет
+	require.ErrorIs(t, err, ErrInvalidOptions)
+}
+
+// Функция тестирования политик сброса на диск при конкурентной записи
+func TestSyncPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy SyncPolicy
+	}{
+		{name: "always", policy: SyncAlways},
+		{name: "group", policy: SyncGroup},
+		{name: "none", policy: SyncNone},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDBFile, err := os.CreateTemp("", "example-*.data")
+			require.NoError(t, err)
+			defer func() {
+				err = os.Remove(tmpDBFile.Name())
+				require.NoError(t, err)
+			}()
+
+			err = tmpDBFile.Close()
+			require.NoError(t, err)
+
+			stor, err := NewStoreWithOptions(tmpDBFile.Name(), Options{SyncPolicy: tc.policy, GroupCommitOps: 4})
+			require.NoError(t, err)
+
+			var wg sync.WaitGroup
+			for w := 0; w < 8; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < 20; i++ {
+						err := stor.SetValue(fmt.Sprintf("key-%d-%d", w, i), fmt.Sprintf("val-%d-%d", w, i))
+						require.NoError(t, err)
+					}
+				}(w)
+			}
+			wg.Wait()
+
+			require.NoError(t, stor.Sync())
+			require.NoError(t, stor.Close())
+
+			reopened, err := OpenStore(tmpDBFile.Name(), Options{SyncPolicy: tc.policy})
+			require.NoError(t, err)
+			defer reopened.Close()
+
+			for w := 0; w < 8; w++ {
+				for i := 0; i < 20; i++ {
+					val, err := reopened.GetValue(fmt.Sprintf("key-%d-%d", w, i))
+					require.NoError(t, err)
+					require.Equal(t, fmt.Sprintf("val-%d-%d", w, i), val)
+				}
+			}
+		})
+	}
+}
+
+// Функция тестирования массовой загрузки
+func TestBulkLoad(t *testing.T) {
+	tmpDBFile, err := os.CreateTemp("", "example-*.data")
+	require.NoError(t, err)
+	defer func() {
+		err = os.Remove(tmpDBFile.Name())
+		require.NoError(t, err)
+	}()
+
+	err = tmpDBFile.Close()
+	require.NoError(t, err)
+
+	stor := NewStore(tmpDBFile.Name(), testLogger(t))
+	defer stor.Close()
+
+	err = stor.SetValue("roma", "dolznik") // значение, лежащее в хранилище до загрузки, должно сохраниться
+	require.NoError(t, err)
+
+	kvs := make([]bkt.KV, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		kvs = append(kvs, bkt.KV{Key: fmt.Sprintf("key-%d", i), Val: fmt.Sprintf("val-%d", i)})
+	}
+	kvs = append(kvs, bkt.KV{Key: "key-1", Val: "last"}) // повтор ключа - побеждает последнее значение
+
+	err = stor.BulkLoad(NewSliceIterator(kvs))
+	require.NoError(t, err)
+	require.Equal(t, 1<<stor.globalDepth, len(stor.dirList))
+
+	val, err := stor.GetValue("roma")
+	require.NoError(t, err)
+	require.Equal(t, "dolznik", val)
+
+	val, err = stor.GetValue("key-1")
+	require.NoError(t, err)
+	require.Equal(t, "last", val)
+
+	for i := 2; i < 1000; i++ {
+		val, err := stor.GetValue(fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("val-%d", i), val)
+	}
+
+	err = stor.SetValue("key-2", "updated") // повторная запись ключа перезаписывает значение
+	require.NoError(t, err)
+
+	val, err = stor.GetValue("key-2")
+	require.NoError(t, err)
+	require.Equal(t, "updated", val)
+
+	err = stor.SetValue("after-bulk", "value") // после загрузки хранилище продолжает работать в обычном режиме
+	require.NoError(t, err)
+
+	val, err = stor.GetValue("after-bulk")
+	require.NoError(t, err)
+	require.Equal(t, "value", val)
+}
+
+// Функция тестирования удаления значений
+func TestDeleteValue(t *testing.T) {
+	stor := newTestStore(t, Options{})
+
+	for i := 0; i < 50; i++ {
+		err := stor.SetValue(fmt.Sprintf("key-%d", i), fmt.Sprintf("val-%d", i))
+		require.NoError(t, err)
+	}
+
+	for i := 0; i < 50; i += 2 {
+		err := stor.DeleteValue(fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+	}
+
+	for i := 0; i < 50; i++ {
+		val, err := stor.GetValue(fmt.Sprintf("key-%d", i))
+		if i%2 == 0 {
+			require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("val-%d", i), val)
+	}
+
+	err := stor.DeleteValue("key-0")
+	require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+}
+
+// Функция тестирования упорядоченного индекса: диапазоны, префиксы, удаление и переоткрытие
+func TestOrderedIndex(t *testing.T) {
+	tmpDBFile, err := os.CreateTemp("", "example-*.data")
+	require.NoError(t, err)
+	require.NoError(t, tmpDBFile.Close())
+	defer os.Remove(tmpDBFile.Name())
+
+	plain, err := NewStoreWithOptions(tmpDBFile.Name(), Options{})
+	require.NoError(t, err)
+	_, err = plain.Range("", "")
+	require.ErrorIs(t, err, ErrNoIndex)
+
+	for i := 0; i < 300; i++ { // индекс строится по уже записанным данным при открытии
+		require.NoError(t, plain.SetValue(fmt.Sprintf("user:%03d", i), fmt.Sprintf("val-%d", i)))
+	}
+	require.NoError(t, plain.SetValue("item:1", "x"))
+	require.NoError(t, plain.Close())
+
+	stor, err := OpenStore(tmpDBFile.Name(), Options{OrderedIndex: true})
+	require.NoError(t, err)
+
+	for i := 300; i < 500; i++ {
+		require.NoError(t, stor.SetValue(fmt.Sprintf("user:%03d", i), fmt.Sprintf("val-%d", i)))
+	}
+	for i := 0; i < 500; i += 5 {
+		require.NoError(t, stor.DeleteValue(fmt.Sprintf("user:%03d", i)))
+	}
+
+	collect := func(s *Store, it *RangeIterator, err error) []string {
+		require.NoError(t, err)
+		var keys []string
+		for kv, ok := it.Next(); ok; kv, ok = it.Next() {
+			val, err := s.GetValue(kv.Key)
+			require.NoError(t, err)
+			require.Equal(t, val, kv.Val)
+			keys = append(keys, kv.Key)
+		}
+		require.NoError(t, it.Err())
+		return keys
+	}
+
+	it, err := stor.Range("user:100", "user:110")
+	require.Equal(t, []string{"user:101", "user:102", "user:103", "user:104", "user:106", "user:107", "user:108", "user:109"}, collect(stor, it, err))
+
+	it, err = stor.Prefix("item:")
+	require.Equal(t, []string{"item:1"}, collect(stor, it, err))
+
+	it, err = stor.Prefix("user:")
+	keys := collect(stor, it, err)
+	require.Len(t, keys, 400)
+	require.True(t, sort.StringsAreSorted(keys))
+
+	it, err = stor.Range("user:498", "") // пустой конец - до последнего ключа
+	require.NoError(t, err)
+	require.NoError(t, stor.DeleteValue("user:499")) // удаленный после создания итератора ключ пропускается
+	require.Equal(t, []string{"user:498"}, collect(stor, it, err))
+	require.NoError(t, stor.Close())
+
+	reopened, err := OpenStore(tmpDBFile.Name(), Options{}) // индекс, записанный в файл, открывается без опции
+	require.NoError(t, err)
+	it, err = reopened.Prefix("user:4")
+	keys = collect(reopened, it, err)
+	require.Len(t, keys, 79)
+	require.Equal(t, "user:401", keys[0])
+
+	kvs := []bkt.KV{{Key: "bulk:b", Val: "2"}, {Key: "bulk:a", Val: "1"}}
+	require.NoError(t, reopened.BulkLoad(NewSliceIterator(kvs))) // после загрузки индекс перестраивается
+	it, err = reopened.Prefix("bulk:")
+	require.Equal(t, []string{"bulk:a", "bulk:b"}, collect(reopened, it, err))
+	require.NoError(t, reopened.Close())
+}
+
+// Функция тестирования истории версий: чтение на момент версии, удаление и политика хранения
+func TestHistory(t *testing.T) {
+	plain := newTestStore(t, Options{})
+	_, err := plain.History("key")
+	require.ErrorIs(t, err, ErrHistoryDisabled)
+	require.NoError(t, plain.Close())
+	_, err = OpenStore(plain.pathToDB, Options{History: true}) // значения записаны без версий
+	require.ErrorIs(t, err, ErrOptionsMismatch)
+
+	stor := newTestStore(t, Options{
+		BucketPages:      2,
+		MaxKeySize:       32,
+		MaxValueSize:     64,
+		History:          true,
+		HistoryRetention: Retention{MaxVersions: 3},
+	})
+
+	for v := 1; v <= 5; v++ {
+		require.NoError(t, stor.SetValue("key", fmt.Sprintf("val-%d", v)))
+	}
+	require.NoError(t, stor.DeleteValue("key"))
+	require.NoError(t, stor.SetValue("key", "reborn"))
+
+	val, err := stor.GetValue("key")
+	require.NoError(t, err)
+	require.Equal(t, "reborn", val)
+
+	val, err = stor.GetAt("key", 2)
+	require.NoError(t, err)
+	require.Equal(t, "val-2", val)
+
+	_, err = stor.GetAt("key", 6) // в этой версии ключ был удален
+	require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+	_, err = stor.GetAt("key", 8)
+	require.ErrorIs(t, err, ErrVersionNotFound)
+
+	history, err := stor.History("key")
+	require.NoError(t, err)
+	require.Len(t, history, 7)
+	for i, v := range history {
+		require.Equal(t, uint64(i+1), v.Version)
+	}
+	require.True(t, history[5].Deleted)
+	require.Equal(t, "reborn", history[6].Value)
+	require.False(t, history[0].Time.After(history[6].Time))
+
+	err = stor.SetValue(historyKeyPrefix+"key", "val")
+	require.ErrorIs(t, err, ErrReservedKey)
+	err = stor.DeleteValue("key-missing")
+	require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+
+	for i := 0; i < 300; i++ { // сплиты переписывают бакеты и применяют политику хранения
+		require.NoError(t, stor.SetValue(fmt.Sprintf("other-%d", i), "x"))
+	}
+
+	history, err = stor.History("key")
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), history[len(history)-1].Version)
+	require.GreaterOrEqual(t, history[0].Version, uint64(4))
+
+	err = stor.BulkLoad(NewSliceIterator([]bkt.KV{{Key: "key", Val: "bulk"}})) // BulkLoad переписывает все бакеты
+	require.NoError(t, err)
+	history, err = stor.History("key")
+	require.NoError(t, err)
+	require.Len(t, history, 4)
+	require.Equal(t, uint64(5), history[0].Version)
+	require.Equal(t, "bulk", history[3].Value)
+
+	require.NoError(t, stor.Close())
+	reopened, err := OpenStore(stor.pathToDB, Options{}) // режим истории берется из заголовка
+	require.NoError(t, err)
+	defer reopened.Close()
+	val, err = reopened.GetAt("key", 7)
+	require.NoError(t, err)
+	require.Equal(t, "reborn", val)
+}
+
+// Функция тестирования подписок на изменения ключей и префиксов
+func TestWatch(t *testing.T) {
+	stor := newTestStore(t, Options{WatchBuffer: 4})
+	require.NoError(t, stor.SetValue("user:1", "old"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	keyEvents := stor.Watch(ctx, "user:1")
+	prefixEvents := stor.WatchPrefix(ctx, "user:")
+
+	require.NoError(t, stor.SetValue("user:1", "new"))
+	require.NoError(t, stor.SetValue("user:2", "x"))
+	require.NoError(t, stor.SetValue("item:1", "y")) // не попадает ни в одну подписку
+	require.NoError(t, stor.DeleteValue("user:1"))
+
+	require.Equal(t, Event{Seq: 2, Op: OpPut, Key: "user:1", Old: "old", OldExists: true, New: "new"}, <-keyEvents)
+	require.Equal(t, Event{Seq: 5, Op: OpDelete, Key: "user:1", Old: "new", OldExists: true}, <-keyEvents)
+
+	for _, want := range []Event{
+		{Seq: 2, Op: OpPut, Key: "user:1", Old: "old", OldExists: true, New: "new"},
+		{Seq: 3, Op: OpPut, Key: "user:2", New: "x"},
+		{Seq: 5, Op: OpDelete, Key: "user:1", Old: "new", OldExists: true},
+	} {
+		require.Equal(t, want, <-prefixEvents)
+	}
+
+	for i := 0; i < 10; i++ { // подписчик не читает события - буфер переполняется
+		require.NoError(t, stor.SetValue("user:2", fmt.Sprintf("v%d", i)))
+	}
+	var events []Event
+	for event := range prefixEvents {
+		events = append(events, event)
+	}
+	require.Len(t, events, 5)
+	require.True(t, events[4].Overflow)
+	require.Equal(t, "v3", events[3].New)
+
+	cancel() // отмена контекста закрывает канал
+	for range keyEvents {
+	}
+
+	other, err := NewStoreWithOptions(filepath.Join(t.TempDir(), "watch.data"), Options{})
+	require.NoError(t, err)
+	closed := other.Watch(context.Background(), "key")
+	require.NoError(t, other.Close()) // закрытие хранилища закрывает все подписки
+	_, ok := <-closed
+	require.False(t, ok)
+}
+
+// Функция тестирования атомарных операций при конкурентных вызовах и сплитах бакетов
+func TestAtomic(t *testing.T) {
+	stor := newTestStore(t, Options{SyncPolicy: SyncNone})
+
+	ok, err := stor.SetIfAbsent("lock", "owner-1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = stor.SetIfAbsent("lock", "owner-2")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ok, err = stor.CompareAndSwap("lock", "owner-2", "owner-3")
+	require.NoError(t, err)
+	require.False(t, ok)
+	ok, err = stor.CompareAndSwap("lock", "owner-1", "owner-3")
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = stor.CompareAndSwap("missing", "", "x")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	val, err := stor.GetAndDelete("lock")
+	require.NoError(t, err)
+	require.Equal(t, "owner-3", val)
+	_, err = stor.GetAndDelete("lock")
+	require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+
+	n, err := stor.Increment("counter", 5)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), n)
+	require.NoError(t, stor.SetValue("text", "abc"))
+	_, err = stor.Increment("text", 1)
+	require.ErrorIs(t, err, ErrNotInteger)
+
+	require.NoError(t, stor.SetValue("big", strconv.FormatInt(math.MaxInt64-1, 10)))
+	_, err = stor.Increment("big", 2)
+	require.ErrorIs(t, err, ErrOverflow)
+	require.NoError(t, stor.SetValue("small", strconv.FormatInt(math.MinInt64, 10)))
+	_, err = stor.Increment("small", -1)
+	require.ErrorIs(t, err, ErrOverflow)
+	n, err = stor.Increment("big", 1) // значение после переполнения не изменилось
+	require.NoError(t, err)
+	require.Equal(t, int64(math.MaxInt64), n)
+
+	const workers, iterations = 8, 100
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				_, err := stor.Increment("counter", 1)
+				require.NoError(t, err)
+
+				// записи других ключей вызывают сплиты и глобальные ресайзы между атомарными операциями
+				require.NoError(t, stor.SetValue(fmt.Sprintf("fill-%d-%d", w, i), "x"))
+
+				for { // счетчик через CompareAndSwap
+					cur, err := stor.GetValue("cas")
+					var swapped bool
+					if errors.Is(err, bkt.ErrKeyNotFound) {
+						swapped, err = stor.SetIfAbsent("cas", "1")
+					} else {
+						require.NoError(t, err)
+						next, _ := strconv.Atoi(cur)
+						swapped, err = stor.CompareAndSwap("cas", cur, strconv.Itoa(next+1))
+					}
+					require.NoError(t, err)
+					if swapped {
+						break
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	n, err = stor.Increment("counter", 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(5+workers*iterations), n)
+
+	val, err = stor.GetValue("cas")
+	require.NoError(t, err)
+	require.Equal(t, strconv.Itoa(workers*iterations), val)
+
+	require.Greater(t, stor.globalDepth, defaultGlobalDepth)
+
+	var set, got atomic.Int64 // из конкурентных вызовов ровно один создает ключ и ровно один его забирает
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			ok, err := stor.SetIfAbsent("leader", fmt.Sprintf("worker-%d", w))
+			require.NoError(t, err)
+			if ok {
+				set.Add(1)
+			}
+		}(w)
+	}
+	wg.Wait()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := stor.GetAndDelete("leader"); err == nil {
+				got.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int64(1), set.Load())
+	require.Equal(t, int64(1), got.Load())
+}
+
+// Функция тестирования именованных пространств ключей в одном файле
+func TestKeyspaces(t *testing.T) {
+	stor := newTestStore(t, Options{})
+
+	users := stor.Keyspace("users")
+	err := users.SetValue("1", "roma")
+	require.ErrorIs(t, err, ErrKeyspaceNotFound)
+
+	require.NoError(t, stor.CreateKeyspace("users"))
+	require.NoError(t, stor.CreateKeyspace("orders"))
+	require.ErrorIs(t, stor.CreateKeyspace("users"), ErrKeyspaceExists)
+	require.ErrorIs(t, stor.CreateKeyspace(""), ErrInvalidKeyspace)
+	require.Equal(t, []string{"orders", "users"}, stor.Keyspaces())
+
+	require.NoError(t, stor.SetValue("1", "default"))
+	for i := 0; i < 200; i++ { // сплиты и ресайзы внутри пространства не затрагивают остальные
+		require.NoError(t, users.SetValue(fmt.Sprintf("%d", i), fmt.Sprintf("user-%d", i)))
+	}
+	require.NoError(t, stor.Keyspace("orders").SetValue("1", "order"))
+	require.NoError(t, users.DeleteValue("2"))
+
+	val, err := stor.GetValue("1")
+	require.NoError(t, err)
+	require.Equal(t, "default", val)
+	val, err = users.GetValue("1")
+	require.NoError(t, err)
+	require.Equal(t, "user-1", val)
+	_, err = users.GetValue("2")
+	require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+	_, err = stor.GetValue("150")
+	require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+	require.Greater(t, stor.keyspaces["users"].globalDepth, stor.globalDepth)
+
+	follower := newTestStore(t, Options{}) // изменения пространств ключей реплицируются
+	ctx, cancel := context.WithCancel(context.Background())
+	primaryConn, followerConn := net.Pipe()
+	errs := make(chan error, 2)
+	go func() { errs <- stor.ServeFollower(ctx, primaryConn) }()
+	go func() { errs <- follower.Follow(ctx, followerConn) }()
+	require.Eventually(t, func() bool { return follower.LastSeq() == stor.LastSeq() }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-errs
+	<-errs
+	val, err = follower.Keyspace("users").GetValue("150")
+	require.NoError(t, err)
+	require.Equal(t, "user-150", val)
+
+	err = stor.BulkLoad(NewSliceIterator([]bkt.KV{{Key: "bulk", Val: "x"}})) // массовая загрузка не трогает пространства ключей
+	require.NoError(t, err)
+	val, err = users.GetValue("150")
+	require.NoError(t, err)
+	require.Equal(t, "user-150", val)
+
+	require.NoError(t, stor.DropKeyspace("users"))
+	_, err = users.GetValue("1")
+	require.ErrorIs(t, err, ErrKeyspaceNotFound)
+	require.ErrorIs(t, stor.DropKeyspace("users"), ErrKeyspaceNotFound)
+
+	endOffset := stor.endOffset
+	require.NoError(t, stor.CreateKeyspace("users")) // освобожденные страницы используются заново
+	for i := 0; i < 100; i++ {
+		require.NoError(t, users.SetValue(fmt.Sprintf("%d", i), "new"))
+	}
+	require.Equal(t, endOffset, stor.endOffset)
+	require.NoError(t, stor.Close())
+
+	reopened, err := OpenStore(stor.pathToDB, Options{})
+	require.NoError(t, err)
+	defer reopened.Close()
+	require.Equal(t, []string{"orders", "users"}, reopened.Keyspaces())
+	val, err = reopened.Keyspace("users").GetValue("99")
+	require.NoError(t, err)
+	require.Equal(t, "new", val)
+	_, err = reopened.Keyspace("users").GetValue("150") // значения удаленного пространства не возвращаются
+	require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+	val, err = reopened.Keyspace("orders").GetValue("1")
+	require.NoError(t, err)
+	require.Equal(t, "order", val)
+	val, err = reopened.GetValue("bulk")
+	require.NoError(t, err)
+	require.Equal(t, "x", val)
+}
+
+// Функция тестирования репликации: реплика догоняет основное хранилище после разрыва соединения
+func TestLinearHashing(t *testing.T) {
+	stor := newTestStore(t, Options{Scheme: SchemeLinear, SyncPolicy: SyncNone, History: true})
+	require.NoError(t, stor.CreateKeyspace("users"))
+
+	const n = 3000
+	for i := 0; i < n; i++ {
+		require.NoError(t, stor.SetValue(fmt.Sprintf("key-%d", i), fmt.Sprintf("val-%d", i)))
+	}
+	require.NoError(t, stor.SetValue("key-7", "rewritten")) // перезапись ключа из цепочки переполнения не дублирует его
+	require.NoError(t, stor.DeleteValue("key-8"))
+	require.Equal(t, 1<<stor.globalDepth+stor.split, len(stor.dirList))
+
+	users := stor.Keyspace("users")
+	for i := 0; i < 500; i++ {
+		require.NoError(t, users.SetValue(strconv.Itoa(i), "user"))
+	}
+
+	check := func(stor *Store) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			val, err := stor.GetValue(fmt.Sprintf("key-%d", i))
+			switch i {
+			case 7:
+				require.NoError(t, err)
+				require.Equal(t, "rewritten", val)
+			case 8:
+				require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+			default:
+				require.NoError(t, err)
+				require.Equal(t, fmt.Sprintf("val-%d", i), val)
+			}
+		}
+		history, err := stor.History("key-7")
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+
+		val, err := stor.Keyspace("users").GetValue("499")
+		require.NoError(t, err)
+		require.Equal(t, "user", val)
+	}
+	check(stor)
+
+	require.NoError(t, stor.Close())
+	reopened, err := OpenStore(stor.pathToDB, Options{}) // схема берется из заголовка
+	require.NoError(t, err)
+	require.Equal(t, SchemeLinear, reopened.opts.Scheme)
+	require.Equal(t, stor.split, reopened.split)
+	check(reopened)
+	require.NoError(t, reopened.Close())
+
+	bulk, err := NewStoreWithOptions(filepath.Join(t.TempDir(), "bulk.data"), Options{Scheme: SchemeLinear, SyncPolicy: SyncNone})
+	require.NoError(t, err)
+	kvs := make([]bkt.KV, 0, n)
+	for i := 0; i < n; i++ {
+		kvs = append(kvs, bkt.KV{Key: fmt.Sprintf("key-%d", i), Val: fmt.Sprintf("val-%d", i)})
+	}
+	require.NoError(t, bulk.BulkLoad(NewSliceIterator(kvs)))
+	require.Equal(t, 0, bulk.split)
+	require.NoError(t, bulk.SetValue("extra", "value")) // после загрузки таблица продолжает расти по одному бакету
+	for i := 0; i < n; i++ {
+		val, err := bulk.GetValue(fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("val-%d", i), val)
+	}
+
+	require.NoError(t, bulk.Close())
+	reopened, err = OpenStore(bulk.pathToDB, Options{Scheme: SchemeLinear})
+	require.NoError(t, err)
+	require.NoError(t, reopened.Close())
+
+	plain := newTestStore(t, Options{})
+	require.NoError(t, plain.Close())
+	_, err = OpenStore(plain.pathToDB, Options{Scheme: SchemeLinear})
+	require.ErrorIs(t, err, ErrOptionsMismatch)
+}
+
+func TestReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ro.data")
+	_, err := OpenStore(path, Options{ReadOnly: true}) // только для чтения файл не создается
+	require.ErrorIs(t, err, os.ErrNotExist)
+	_, err = NewStoreWithOptions(path, Options{ReadOnly: true})
+	require.ErrorIs(t, err, ErrReadOnly)
+
+	writer, err := NewStoreWithOptions(path, Options{})
+	require.NoError(t, err)
+	require.NoError(t, writer.SetValue("key", "val"))
+	require.NoError(t, writer.CreateKeyspace("users"))
+
+	_, err = OpenStore(path, Options{ReadOnly: true})
+	require.ErrorIs(t, err, pagestore.ErrLocked)
+
+	go func() { // писатель закрывает бд, пока читатель ждет блокировку
+		time.Sleep(50 * time.Millisecond)
+		writer.Close()
+	}()
+	reader, err := OpenStore(path, Options{ReadOnly: true, LockTimeout: 5 * time.Second})
+	require.NoError(t, err)
+	defer reader.Close()
+
+	other, err := OpenStore(path, Options{ReadOnly: true, Backend: pagestore.BackendFile}) // читателей может быть несколько
+	require.NoError(t, err)
+	defer other.Close()
+
+	val, err := reader.GetValue("key")
+	require.NoError(t, err)
+	require.Equal(t, "val", val)
+	val, err = other.GetValue("key")
+	require.NoError(t, err)
+	require.Equal(t, "val", val)
+	require.Equal(t, []string{"users"}, reader.Keyspaces())
+
+	require.ErrorIs(t, reader.SetValue("key", "new"), ErrReadOnly)
+	require.ErrorIs(t, reader.DeleteValue("key"), ErrReadOnly)
+	require.ErrorIs(t, reader.BulkLoad(NewSliceIterator(nil)), ErrReadOnly)
+	require.ErrorIs(t, reader.CreateKeyspace("orders"), ErrReadOnly)
+	require.ErrorIs(t, reader.Keyspace("users").SetValue("1", "x"), ErrReadOnly)
+	_, err = reader.Increment("counter", 1)
+	require.ErrorIs(t, err, ErrReadOnly)
+	require.NoError(t, reader.Sync())
+
+	_, err = OpenStore(path, Options{LockTimeout: 20 * time.Millisecond}) // писатель не дождался читателей
+	require.ErrorIs(t, err, pagestore.ErrLocked)
+	_, err = OpenStore(path, Options{ReadOnly: true, OrderedIndex: true}) // индекс нельзя построить без записи
+	require.ErrorIs(t, err, ErrReadOnly)
+}
+
+// Функция тестирования вместимости бакета по умолчанию: в страницу 4096 байт помещаются 3 записи, как до появления заголовка бакета
+func TestDefaultCapacity(t *testing.T) {
+	if os.Getpagesize() != 4096 {
+		t.Skip("system page size is not 4096")
+	}
+
+	for _, format := range []int{formatV2, formatV1} {
+		stor, err := NewStoreWithOptions(filepath.Join(t.TempDir(), "capacity.data"), Options{SyncPolicy: SyncNone, format: format})
+		require.NoError(t, err)
+		require.Equal(t, 3, stor.bktOpts.Capacity())
+		require.Equal(t, 3, bkt.DefaultGeometry().Capacity())
+
+		require.NoError(t, stor.SetValue(strings.Repeat("k", parser.DefaultMaxKeySize), strings.Repeat("v", bkt.DefaultMaxValueSize)))
+		require.ErrorIs(t, stor.SetValue("key", strings.Repeat("v", bkt.DefaultMaxValueSize+1)), parser.ErrValueTooLong)
+		require.NoError(t, stor.Close())
+	}
+}
+
+// Функция тестирования перезаписи ключа: запись заменяется на месте, бакет не получает дубликатов и не сплитится
+func TestOverwrite(t *testing.T) {
+	for _, scheme := range []Scheme{SchemeExtendible, SchemeLinear} {
+		path := filepath.Join(t.TempDir(), "overwrite.data")
+		stor, err := NewStoreWithOptions(path, Options{MaxKeySize: 16, MaxValueSize: 16, SyncPolicy: SyncNone, Scheme: scheme})
+		require.NoError(t, err)
+
+		capacity := stor.bktOpts.Capacity()
+		for i := 0; i < 10*capacity; i++ { // бакеты заполнены, новые ключи вызвали бы сплиты
+			require.NoError(t, stor.SetValue(fmt.Sprintf("key-%d", i), "val"))
+		}
+		before, err := stor.Stats()
+		require.NoError(t, err)
+
+		for i := 0; i < 10*capacity; i++ {
+			for j := 0; j < 5; j++ {
+				require.NoError(t, stor.SetValue(fmt.Sprintf("key-%d", i), fmt.Sprintf("val-%d-%d", i, j)))
+			}
+		}
+		after, err := stor.Stats()
+		require.NoError(t, err)
+		require.Equal(t, before, after) // ни новых записей, ни новых бакетов
+
+		require.NoError(t, stor.Close())
+		reopened, err := OpenStore(path, Options{})
+		require.NoError(t, err)
+		for i := 0; i < 10*capacity; i++ {
+			val, err := reopened.GetValue(fmt.Sprintf("key-%d", i))
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("val-%d-4", i), val) // последнее значение, а не первая запись
+		}
+		require.NoError(t, reopened.Close())
+	}
+}
+
+// Функция тестирования отпечатков ключей в бакетах: после сплитов, удалений и переоткрытия бд поиск находит все записи
+func TestFingerprints(t *testing.T) {
+	for _, format := range []int{formatV2, formatV1} {
+		path := filepath.Join(t.TempDir(), "fp.data")
+		stor, err := NewStoreWithOptions(path, Options{MaxKeySize: 16, MaxValueSize: 16, SyncPolicy: SyncNone, format: format})
+		require.NoError(t, err)
+
+		for i := 0; i < 1000; i++ { // сплиты и удаления должны сохранять отпечатки согласованными с записями
+			require.NoError(t, stor.SetValue(fmt.Sprintf("key-%d", i), fmt.Sprintf("val-%d", i)))
+		}
+		for i := 0; i < 1000; i += 3 {
+			require.NoError(t, stor.DeleteValue(fmt.Sprintf("key-%d", i)))
+		}
+		require.NoError(t, stor.SetValue("key-1", "rewritten"))
+		require.NoError(t, stor.Close())
+
+		reopened, err := OpenStore(path, Options{}) // формат бакетов берется из заголовка
+		require.NoError(t, err)
+		require.Equal(t, format, reopened.opts.format)
+		for i := 0; i < 1000; i++ {
+			val, err := reopened.GetValue(fmt.Sprintf("key-%d", i))
+			switch {
+			case i == 1:
+				require.NoError(t, err)
+				require.Equal(t, "rewritten", val)
+			case i%3 == 0:
+				require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+			default:
+				require.NoError(t, err)
+				require.Equal(t, fmt.Sprintf("val-%d", i), val)
+			}
+			_, err = reopened.GetValue(fmt.Sprintf("miss-%d", i))
+			require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+		}
+		require.NoError(t, reopened.Close())
+	}
+}
+
+func TestTyped(t *testing.T) {
+	stor := newTestStore(t, Options{SyncPolicy: SyncNone})
+
+	user := map[string]any{
+		"name":    "roma",
+		"age":     int64(23),
+		"balance": -1.5,
+		"created": time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC),
+		"roles":   []any{"admin", "dolznik"},
+		"avatar":  []byte{0x89, 'P', 'N', 'G'},
+	}
+	require.NoError(t, stor.SetTyped("user:1", user))
+	got, err := stor.GetTyped("user:1")
+	require.NoError(t, err)
+	require.Equal(t, user, got)
+
+	require.NoError(t, stor.SetTyped("counter", uint64(7)))
+	got, err = stor.GetTyped("counter")
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), got)
+
+	require.ErrorIs(t, stor.SetTyped("bad", struct{}{}), parser.ErrUnsupportedType)
+	require.ErrorIs(t, stor.SetTyped("big", strings.Repeat("x", parser.DefaultMaxValueSize)), parser.ErrValueTooLong)
+
+	require.NoError(t, stor.SetValue("plain", "text"))
+	_, err = stor.GetTyped("plain")
+	require.ErrorIs(t, err, parser.ErrInvalidValue)
+	_, err = stor.GetTyped("missing")
+	require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+}
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "v1.data")
+	stor, err := NewStoreWithOptions(path, Options{MaxKeySize: 32, MaxValueSize: 64, SyncPolicy: SyncNone, History: true, OrderedIndex: true, format: formatV1})
+	require.NoError(t, err)
+	for i := 0; i < 300; i++ {
+		require.NoError(t, stor.SetValue(fmt.Sprintf("key-%03d", i), fmt.Sprintf("val-%d", i)))
+	}
+	require.NoError(t, stor.SetValue("key-001", "rewritten"))
+	require.NoError(t, stor.CreateKeyspace("users"))
+	require.NoError(t, stor.Keyspace("users").SetValue("roma", "dolznik"))
+	require.NoError(t, stor.Close())
+
+	data, err := os.ReadFile(path) // файл без версии формата читается как первая версия
+	require.NoError(t, err)
+	data[27] = 0
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	copied := filepath.Join(t.TempDir(), "v2.data")
+	res, err := Migrate(path, copied, Options{})
+	require.NoError(t, err)
+	require.Equal(t, formatV1, res.FromFormat)
+	require.Equal(t, currentFormat, res.ToFormat)
+	require.Equal(t, 302, res.Records) // 300 ключей, предыдущая версия key-001 и ключ пространства users
+
+	res, err = Migrate(path, "", Options{}) // на месте
+	require.NoError(t, err)
+	require.Equal(t, formatV1, res.FromFormat)
+	_, err = os.Stat(path + ".migrate")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	for _, p := range []string{path, copied} {
+		migrated, err := OpenStore(p, Options{})
+		require.NoError(t, err)
+		require.Equal(t, currentFormat, migrated.opts.format)
+		require.True(t, migrated.bktOpts.Fingerprints)
+
+		val, err := migrated.GetValue("key-299")
+		require.NoError(t, err)
+		require.Equal(t, "val-299", val)
+		history, err := migrated.History("key-001")
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		val, err = migrated.Keyspace("users").GetValue("roma")
+		require.NoError(t, err)
+		require.Equal(t, "dolznik", val)
+
+		it, err := migrated.Prefix("key-29")
+		require.NoError(t, err)
+		count := 0
+		for _, ok := it.Next(); ok; _, ok = it.Next() {
+			count++
+		}
+		require.Equal(t, 10, count)
+		require.NoError(t, migrated.Close())
+	}
+
+	data, err = os.ReadFile(path) // файл из будущей версии не открывается
+	require.NoError(t, err)
+	data[27] = currentFormat + 1
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	_, err = OpenStore(path, Options{})
+	require.ErrorIs(t, err, ErrUnsupportedFormat)
+	_, err = Migrate(path, "", Options{})
+	require.ErrorIs(t, err, ErrUnsupportedFormat)
+
+	_, err = Migrate(filepath.Join(t.TempDir(), "missing.data"), "", Options{})
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// Функция тестирования миграции файла формата 0 без заголовка. testdata/legacy.data записан первой версией
+// хранилища: 20 ключей key-N со значениями val-N, ключ roma со значением максимальной длины
+// и повторная запись key-3, которую первая версия дописала в бакет, но не возвращала при поиске
+func TestMigrateLegacy(t *testing.T) {
+	fixture, err := os.ReadFile(filepath.Join("testdata", "legacy.data"))
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "legacy.data")
+	require.NoError(t, os.WriteFile(path, fixture, 0644))
+
+	_, err = OpenStore(path, Options{})
+	require.ErrorIs(t, err, ErrInvalidHeader)
+
+	locked, err := pagestore.LockFile(path, pagestore.OpenOptions{}) // файл держит другой писатель
+	require.NoError(t, err)
+	_, err = Migrate(path, "", Options{})
+	require.ErrorIs(t, err, pagestore.ErrLocked)
+	require.NoError(t, locked.Close())
+
+	copied := filepath.Join(t.TempDir(), "migrated.data")
+	res, err := Migrate(path, copied, Options{})
+	require.NoError(t, err)
+	require.Equal(t, MigrateResult{FromFormat: formatLegacy, ToFormat: currentFormat, Records: 21}, res)
+
+	res, err = Migrate(path, "", Options{}) // на месте
+	require.NoError(t, err)
+	require.Equal(t, formatLegacy, res.FromFormat)
+
+	for _, p := range []string{path, copied} {
+		migrated, err := OpenStore(p, Options{})
+		require.NoError(t, err)
+		require.Equal(t, currentFormat, migrated.opts.format)
+		require.Equal(t, parser.DefaultMaxValueSize, migrated.opts.MaxValueSize) // значение roma не влезло бы в новый размер
+
+		for i := 0; i < 20; i++ {
+			val, err := migrated.GetValue(fmt.Sprintf("key-%d", i))
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("val-%d", i), val)
+		}
+		val, err := migrated.GetValue("roma")
+		require.NoError(t, err)
+		require.Equal(t, strings.Repeat("d", parser.DefaultMaxValueSize), val)
+
+		require.NoError(t, migrated.SetValue("after", "migrate")) // бд работает в обычном режиме
+		require.NoError(t, migrated.Close())
+	}
+
+	broken := filepath.Join(t.TempDir(), "broken.data") // обрезанный файл не принимается за бд формата 0
+	require.NoError(t, os.WriteFile(broken, fixture[:legacyPageSize+100], 0644))
+	_, err = Migrate(broken, "", Options{})
+	require.ErrorIs(t, err, ErrInvalidHeader)
+	_, err = os.Stat(broken + ".migrate")
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSharded(t *testing.T) {
+	dir := t.TempDir()
+	ss, err := OpenSharded(dir, 4, Options{SyncPolicy: SyncNone, History: true})
+	require.NoError(t, err)
+	require.Equal(t, 4, ss.Shards())
+
+	var kvs []bkt.KV
+	for i := 0; i < 400; i++ {
+		kvs = append(kvs, bkt.KV{Key: fmt.Sprintf("key-%03d", i), Val: fmt.Sprintf("val-%d", i)})
+	}
+	require.NoError(t, ss.BulkLoad(NewSliceIterator(kvs)))
+	require.NoError(t, ss.SetValue("key-001", "rewritten"))
+	require.NoError(t, ss.DeleteValue("key-002"))
+	require.NoError(t, ss.Shard(0).CreateKeyspace("users"))
+	require.NoError(t, ss.Shard(0).Keyspace("users").SetValue("roma", "dolznik"))
+
+	stats, err := ss.Stats()
+	require.NoError(t, err)
+	require.Equal(t, 399, stats.Keys)
+	var sum Stats
+	for i, st := range stats.Shards {
+		require.NotZero(t, st.Keys, "shard %d", i) // ключи расходятся по всем шардам
+		sum.add(st)
+	}
+	require.Equal(t, sum, stats.Stats)
+	require.NoError(t, ss.Close())
+
+	_, err = OpenSharded(dir, 8, Options{})
+	require.ErrorIs(t, err, ErrOptionsMismatch)
+
+	require.NoError(t, Reshard(dir, 8, Options{}))
+	m, err := readManifest(dir)
+	require.NoError(t, err)
+	require.Equal(t, 2, m.Generation)
+	files, err := filepath.Glob(filepath.Join(dir, "shard-1-*"))
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	ss, err = OpenSharded(dir, 0, Options{})
+	require.NoError(t, err)
+	defer ss.Close()
+	require.Equal(t, 8, ss.Shards())
+
+	val, err := ss.GetValue("key-399")
+	require.NoError(t, err)
+	require.Equal(t, "val-399", val)
+	_, err = ss.GetValue("key-002")
+	require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+	history, err := ss.shard("key-001").History("key-001")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	val, err = ss.shard("roma").Keyspace("users").GetValue("roma")
+	require.NoError(t, err)
+	require.Equal(t, "dolznik", val)
+
+	stats, err = ss.Stats()
+	require.NoError(t, err)
+	require.Equal(t, 399, stats.Keys)
+}
+
+func TestDumpLayout(t *testing.T) {
+	stor, err := NewStoreWithOptions(filepath.Join(t.TempDir(), "layout.data"), Options{MaxKeySize: 16, MaxValueSize: 16, SyncPolicy: SyncNone})
+	require.NoError(t, err)
+	defer stor.Close()
+	for i := 0; i < 500; i++ {
+		require.NoError(t, stor.SetValue(fmt.Sprintf("key-%03d", i), "val"))
+	}
+	require.NoError(t, stor.CreateKeyspace("users"))
+	require.NoError(t, stor.Keyspace("users").SetValue("roma", "dolznik"))
+
+	var bf bytes.Buffer
+	require.NoError(t, stor.DumpLayout(&bf, LayoutJSON|LayoutKeys))
+	var l layout
+	require.NoError(t, json.Unmarshal(bf.Bytes(), &l))
+	require.Equal(t, "extendible", l.Scheme)
+	require.Len(t, l.Tables, 2)
+	require.Equal(t, "users", l.Tables[1].Keyspace)
+	var userKeys []string
+	for _, bl := range l.Tables[1].Buckets {
+		userKeys = append(userKeys, bl.Keys...)
+	}
+	require.Equal(t, []string{"roma"}, userKeys)
+
+	tl := l.Tables[0]
+	require.Len(t, tl.Directories, 1<<stor.globalDepth)
+	buckets := make(map[int]bucketLayout)
+	records, keys := 0, 0
+	for _, bl := range tl.Buckets {
+		buckets[bl.ID] = bl
+		records += bl.Records
+		keys += len(bl.Keys)
+		require.Len(t, bl.Directories, 1<<(tl.GlobalDepth-bl.LocalDepth)) // бакет делят директории с общими младшими битами
+	}
+	require.Equal(t, 500, records)
+	require.Equal(t, 500, keys)
+	for _, dl := range tl.Directories {
+		require.Contains(t, buckets[dl.Bucket].Directories, dl.Index)
+		require.Equal(t, dl.LocalDepth, buckets[dl.Bucket].LocalDepth)
+	}
+
+	bf.Reset()
+	require.NoError(t, stor.DumpLayout(&bf, LayoutDOT))
+	dot := bf.String()
+	require.True(t, strings.HasPrefix(dot, "digraph layout {"))
+	require.Equal(t, len(tl.Directories)+len(l.Tables[1].Directories), strings.Count(dot, " -> ")) // по стрелке от каждой директории
+	require.NotContains(t, dot, "key-001")
+
+	require.ErrorIs(t, stor.DumpLayout(&bf, LayoutFormat(7)), ErrInvalidLayoutFormat)
+}
+
+// Контекст, который отменяется после n проверок
+type countdownCtx struct {
+	context.Context
+	n int
+}
+
+func (c *countdownCtx) Err() error {
+	c.n--
+	if c.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestContext(t *testing.T) {
+	for _, history := range []bool{false, true} {
+		t.Run(fmt.Sprintf("history=%v", history), func(t *testing.T) {
+			stor, err := NewStoreWithOptions(filepath.Join(t.TempDir(), "ctx.data"), Options{MaxKeySize: 32, MaxValueSize: 32, SyncPolicy: SyncNone, History: history})
+			require.NoError(t, err)
+			defer stor.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			require.ErrorIs(t, stor.SetValueContext(ctx, "key", "val"), context.Canceled)
+			_, err = stor.GetValueContext(ctx, "key")
+			require.ErrorIs(t, err, context.Canceled)
+			ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+			defer cancel()
+			_, err = stor.IncrementContext(ctx, "counter", 1)
+			require.ErrorIs(t, err, context.DeadlineExceeded)
+			require.ErrorIs(t, stor.BulkLoadContext(ctx, NewSliceIterator([]bkt.KV{{Key: "key", Val: "val"}})), context.DeadlineExceeded)
+			_, err = stor.GetValue("key")
+			require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+
+			// две проверки при захвате блокировки и одна перед записью, следующая - уже после сплита или ресайза
+			aborted := 0
+			for i := 0; i < 300; i++ {
+				key := fmt.Sprintf("key-%03d", i)
+				err := stor.SetValueContext(&countdownCtx{Context: context.Background(), n: 3}, key, "val")
+				if errors.Is(err, context.Canceled) {
+					aborted++
+					_, err = stor.GetValue(key) // прерванная запись не оставляет значения
+					require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+					require.NoError(t, stor.SetValue(key, "val"))
+					continue
+				}
+				require.NoError(t, err)
+			}
+			require.NotZero(t, aborted)
+
+			for i := 0; i < 300; i++ { // сплиты, выполненные до отмены, не потеряли записей
+				val, err := stor.GetValue(fmt.Sprintf("key-%03d", i))
+				require.NoError(t, err)
+				require.Equal(t, "val", val)
+			}
+			stats, err := stor.Stats()
+			require.NoError(t, err)
+			require.Equal(t, 300, stats.Keys)
+		})
+	}
+}
+
+func TestQuota(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts Options
+	}{
+		{name: "buckets", opts: Options{MaxBuckets: 6}},
+		{name: "file size", opts: Options{MaxFileSize: 7 * int64(os.Getpagesize())}},
+		{name: "linear", opts: Options{MaxBuckets: 6, Scheme: SchemeLinear}},
+		{name: "history and index", opts: Options{MaxBuckets: 8, History: true, OrderedIndex: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := tc.opts
+			opts.MaxKeySize, opts.MaxValueSize, opts.SyncPolicy = 32, 32, SyncNone
+			stor, err := NewStoreWithOptions(filepath.Join(t.TempDir(), "quota.data"), opts)
+			require.NoError(t, err)
+			defer stor.Close()
+
+			i := 0
+			for ; err == nil; i++ {
+				err = stor.SetValue(fmt.Sprintf("key-%04d", i), "val")
+				if i > 10000 {
+					t.Fatal("quota is never reached")
+				}
+			}
+			require.ErrorIs(t, err, ErrQuotaExceeded)
+			last := fmt.Sprintf("key-%04d", i-1)
+
+			before, err := stor.Stats()
+			require.NoError(t, err)
+			for j := 0; j < 3; j++ { // отказ ничего не меняет и повторяется
+				require.ErrorIs(t, stor.SetValue(last, "val"), ErrQuotaExceeded)
+				after, err := stor.Stats()
+				require.NoError(t, err)
+				require.Equal(t, before, after)
+			}
+			_, err = stor.GetValue(last)
+			require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+			if opts.MaxFileSize > 0 {
+				require.LessOrEqual(t, before.Size, opts.MaxFileSize)
+			}
+			if opts.MaxBuckets > 0 {
+				require.LessOrEqual(t, stor.usedPages(), opts.MaxBuckets)
+			}
+
+			if !opts.History { // перезапись на месте страниц не требует; в истории она пишет новую версию
+				require.NoError(t, stor.SetValue("key-0000", "new"))
+			}
+			require.NoError(t, stor.DeleteValue("key-0001"))
+			require.Equal(t, HealthOK, stor.Health())
+		})
+	}
+
+	t.Run("bulk load", func(t *testing.T) {
+		stor, err := NewStoreWithOptions(filepath.Join(t.TempDir(), "quota.data"), Options{MaxKeySize: 32, MaxValueSize: 32, SyncPolicy: SyncNone, MaxBuckets: 4})
+		require.NoError(t, err)
+		defer stor.Close()
+
+		var kvs []bkt.KV
+		for i := 0; i < 1000; i++ {
+			kvs = append(kvs, bkt.KV{Key: fmt.Sprintf("key-%04d", i), Val: "val"})
+		}
+		require.ErrorIs(t, stor.BulkLoad(NewSliceIterator(kvs)), ErrQuotaExceeded)
+		require.NoError(t, stor.BulkLoad(NewSliceIterator(kvs[:100])))
+	})
+
+	t.Run("disk full", func(t *testing.T) {
+		faulty := pagestore.NewFaulty(pagestore.NewMemory())
+		stor, err := NewStoreWithOptions("", Options{PageStore: faulty, MaxKeySize: 32, MaxValueSize: 32, SyncPolicy: SyncNone})
+		require.NoError(t, err)
+
+		faulty.FailReserve(pagestore.ErrNoSpace)
+		i := 0
+		for ; err == nil; i++ {
+			err = stor.SetValue(fmt.Sprintf("key-%04d", i), "val")
+		}
+		require.ErrorIs(t, err, ErrDiskFull)
+		require.ErrorIs(t, err, syscall.ENOSPC)
+		require.Equal(t, HealthDiskFull, stor.Health())
+
+		// место закончилось до сплита: все записанное на месте, новые записи отклоняются
+		for j := 0; j < i-1; j++ {
+			_, err = stor.GetValue(fmt.Sprintf("key-%04d", j))
+			require.NoError(t, err)
+		}
+		require.ErrorIs(t, stor.SetValue("key-0000", "new"), ErrDiskFull)
+		require.ErrorIs(t, stor.DeleteValue("key-0000"), ErrDiskFull)
+	})
+}
+
+// Наблюдатель, который запоминает события для проверки в тестах; события приходят в горутине операции
+type recordingObserver struct {
+	NopObserver
+	gets    []GetEvent
+	sets    []SetEvent
+	deletes []DeleteEvent
+	splits  []SplitEvent
+	resizes []ResizeEvent
+	errs    []ErrorEvent
+}
+
+func (o *recordingObserver) OnGet(e GetEvent)       { o.gets = append(o.gets, e) }
+func (o *recordingObserver) OnSet(e SetEvent)       { o.sets = append(o.sets, e) }
+func (o *recordingObserver) OnDelete(e DeleteEvent) { o.deletes = append(o.deletes, e) }
+func (o *recordingObserver) OnSplit(e SplitEvent)   { o.splits = append(o.splits, e) }
+func (o *recordingObserver) OnResize(e ResizeEvent) { o.resizes = append(o.resizes, e) }
+func (o *recordingObserver) OnError(e ErrorEvent)   { o.errs = append(o.errs, e) }
+
+// Функция тестирования событий наблюдателя
+func TestObserver(t *testing.T) {
+	for _, scheme := range []Scheme{SchemeExtendible, SchemeLinear} {
+		obs := &recordingObserver{}
+		stor := newTestStore(t, Options{Observer: obs, Scheme: scheme, MaxKeySize: 16, MaxValueSize: 16, SyncPolicy: SyncNone})
+
+		require.NoError(t, stor.SetValue("key", "secret"))
+		_, err := stor.GetValue("key")
+		require.NoError(t, err)
+		_, err = stor.GetValue("missing")
+		require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+		require.NoError(t, stor.DeleteValue("key"))
+		require.ErrorIs(t, stor.DeleteValue("key"), bkt.ErrKeyNotFound)
+
+		require.Equal(t, "key", obs.sets[0].Key)
+		require.Equal(t, "secret", obs.sets[0].Value)
+		require.Len(t, obs.gets, 2)
+		require.True(t, obs.gets[0].Found)
+		require.False(t, obs.gets[1].Found)
+		require.Len(t, obs.deletes, 1)
+		require.Empty(t, obs.errs) // промахи ошибками не считаются
+
+		require.Error(t, stor.SetValue(strings.Repeat("k", 100), "val"))
+		require.Len(t, obs.errs, 1)
+		require.Equal(t, "set", obs.errs[0].Op)
+		require.ErrorIs(t, obs.errs[0].Err, parser.ErrKeyTooLong)
+
+		_, err = stor.Increment("counter", 2)
+		require.NoError(t, err)
+		swapped, err := stor.CompareAndSwap("counter", "1", "3") // без изменения события нет
+		require.NoError(t, err)
+		require.False(t, swapped)
+		require.Equal(t, SetEvent{Key: "counter", Value: "2", Duration: obs.sets[1].Duration}, obs.sets[1])
+		require.Len(t, obs.sets, 2)
+
+		for i := 0; i < 2000; i++ {
+			require.NoError(t, stor.SetValue(fmt.Sprintf("key-%04d", i), "val"))
+		}
+		require.NotEmpty(t, obs.splits)
+		require.NotEmpty(t, obs.resizes)
+		require.Equal(t, stor.globalDepth, obs.resizes[len(obs.resizes)-1].GlobalDepth)
+
+		require.NoError(t, stor.CreateKeyspace("users"))
+		ks := stor.Keyspace("users")
+		require.NoError(t, ks.SetValue("alice", "1"))
+		_, err = ks.GetValue("bob")
+		require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+		require.Equal(t, "users", obs.sets[len(obs.sets)-1].Keyspace)
+		require.Equal(t, GetEvent{Keyspace: "users", Key: "bob", Duration: obs.gets[len(obs.gets)-1].Duration}, obs.gets[len(obs.gets)-1])
+	}
+
+	t.Run("zap", func(t *testing.T) {
+		core, logs := observer.New(zap.InfoLevel)
+		stor := newTestStore(t, Options{Observer: NewZapObserver(zap.New(core)), SyncPolicy: SyncNone})
+
+		require.NoError(t, stor.SetValue("key", "secret"))
+		_, err := stor.GetValue("key")
+		require.NoError(t, err)
+
+		entries := logs.FilterMessage("Save data").All()
+		require.Len(t, entries, 1)
+		require.Equal(t, int64(len("secret")), entries[0].ContextMap()["valueSize"])
+		for _, entry := range logs.All() { // значение не попадает в лог
+			for _, v := range entry.ContextMap() {
+				require.NotEqual(t, "secret", v)
+			}
+		}
+		require.Len(t, logs.FilterMessage("Get data").All(), 1)
+	})
+}
+
+// Функция тестирования записей в закрытое хранилище: все они отклоняются с ErrClosed, не доходя до файла
+func TestClosedStore(t *testing.T) {
+	stor, err := NewStoreWithOptions(filepath.Join(t.TempDir(), "closed.data"), Options{SyncPolicy: SyncNone})
+	require.NoError(t, err)
+	require.NoError(t, stor.CreateKeyspace("users"))
+	require.NoError(t, stor.Close())
+	require.NoError(t, stor.Close()) // повторное закрытие ничего не делает
+
+	require.ErrorIs(t, stor.SetValue("key", "val"), ErrClosed)
+	require.ErrorIs(t, stor.DeleteValue("key"), ErrClosed)
+	_, err = stor.Increment("counter", 1)
+	require.ErrorIs(t, err, ErrClosed)
+	require.ErrorIs(t, stor.BulkLoad(NewSliceIterator([]bkt.KV{{Key: "key", Val: "val"}})), ErrClosed)
+	require.ErrorIs(t, stor.CreateKeyspace("orders"), ErrClosed)
+	require.ErrorIs(t, stor.DropKeyspace("users"), ErrClosed)
+	require.ErrorIs(t, stor.Keyspace("users").SetValue("roma", "dolznik"), ErrClosed)
+	require.ErrorIs(t, stor.applyChange(Change{Seq: 100, Op: OpPut, Key: "key", Val: "val"}), ErrClosed)
+}
+
+// Функция тестирования репликации через net.Pipe: поток изменений, переподключение follower и догон пропущенного
+func TestReplication(t *testing.T) {
+	primary := newTestStore(t, Options{})
+	follower := newTestStore(t, Options{})
+
+	connect := func() (context.CancelFunc, chan error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		primaryConn, followerConn := net.Pipe()
+		errs := make(chan error, 2)
+		go func() { errs <- primary.ServeFollower(ctx, primaryConn) }()
+		go func() { errs <- follower.Follow(ctx, followerConn) }()
+		return cancel, errs
+	}
+	disconnect := func(cancel context.CancelFunc, errs chan error) {
+		cancel()
+		require.ErrorIs(t, <-errs, context.Canceled)
+		require.ErrorIs(t, <-errs, context.Canceled)
+	}
+
+	for i := 0; i < 20; i++ { // изменения до подключения реплики
+		require.NoError(t, primary.SetValue(fmt.Sprintf("key-%d", i), fmt.Sprintf("val-%d", i)))
+	}
+
+	cancel, errs := connect()
+	require.NoError(t, primary.DeleteValue("key-0"))
+	require.NoError(t, primary.SetValue("key-1", "updated"))
+	require.Eventually(t, func() bool { return follower.LastSeq() == primary.LastSeq() }, 5*time.Second, 10*time.Millisecond)
+	disconnect(cancel, errs)
+
+	for i := 20; i < 40; i++ { // изменения во время разрыва соединения
+		require.NoError(t, primary.SetValue(fmt.Sprintf("key-%d", i), fmt.Sprintf("val-%d", i)))
+	}
+	require.NoError(t, primary.DeleteValue("key-2"))
+
+	cancel, errs = connect()
+	require.Eventually(t, func() bool { return follower.LastSeq() == primary.LastSeq() }, 5*time.Second, 10*time.Millisecond)
+	disconnect(cancel, errs)
+
+	for i := 0; i < 40; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		want, wantErr := primary.GetValue(key)
+		got, err := follower.GetValue(key)
+		require.Equal(t, wantErr == nil, err == nil, key)
+		require.Equal(t, want, got, key)
+	}
+
+	changes, err := follower.ChangesSince(0) // реплика хранит изменения с теми же номерами
+	require.NoError(t, err)
+	require.Equal(t, primary.LastSeq(), changes[len(changes)-1].Seq)
+}
+
+// Функция тестирования режима реплики: локальные записи отклоняются, номер примененного изменения
+// сохраняется без Sync, слишком длинные строки в потоке изменений отвергаются до выделения памяти
+func TestFollower(t *testing.T) {
+	t.Run("local writes", func(t *testing.T) {
+		primary := newTestStore(t, Options{})
+		follower := newTestStore(t, Options{})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		primaryConn, followerConn := net.Pipe()
+		errs := make(chan error, 2)
+		go func() { errs <- primary.ServeFollower(ctx, primaryConn) }()
+		go func() { errs <- follower.Follow(ctx, followerConn) }()
+
+		require.NoError(t, primary.SetValue("key", "primary"))
+		require.Eventually(t, func() bool { return follower.LastSeq() == primary.LastSeq() }, 5*time.Second, 10*time.Millisecond)
+
+		require.ErrorIs(t, follower.SetValue("local", "val"), ErrFollower)
+		require.ErrorIs(t, follower.DeleteValue("key"), ErrFollower)
+		require.ErrorIs(t, follower.CreateKeyspace("orders"), ErrFollower)
+		require.ErrorIs(t, follower.Follow(ctx, &bytes.Buffer{}), ErrFollower) // второй поток изменений не нужен
+
+		require.NoError(t, primary.SetValue("key", "after")) // изменения основного хранилища не теряются
+		require.Eventually(t, func() bool { return follower.LastSeq() == primary.LastSeq() }, 5*time.Second, 10*time.Millisecond)
+		val, err := follower.GetValue("key")
+		require.NoError(t, err)
+		require.Equal(t, "after", val)
+
+		cancel()
+		require.ErrorIs(t, <-errs, context.Canceled)
+		require.ErrorIs(t, <-errs, context.Canceled)
+
+		require.NoError(t, follower.SetValue("local", "val")) // после остановки Follow хранилище снова принимает записи
+	})
+
+	t.Run("applied seq persisted", func(t *testing.T) {
+		primary := newTestStore(t, Options{})
+		mem := pagestore.NewMemory()
+		follower, err := NewStoreWithOptions("", Options{PageStore: mem, SyncPolicy: SyncNone})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		primaryConn, followerConn := net.Pipe()
+		go func() { _ = primary.ServeFollower(ctx, primaryConn) }()
+		go func() { _ = follower.Follow(ctx, followerConn) }()
+
+		for i := 0; i < 20; i++ {
+			require.NoError(t, primary.SetValue(fmt.Sprintf("key-%d", i), fmt.Sprintf("val-%d", i)))
+		}
+		require.Eventually(t, func() bool { return follower.LastSeq() == primary.LastSeq() }, 5*time.Second, 10*time.Millisecond)
+
+		reopened, err := OpenStore("", Options{PageStore: mem}) // реплика упала, не вызвав Sync
+		require.NoError(t, err)
+		defer reopened.Close()
+		require.Equal(t, primary.LastSeq(), reopened.LastSeq())
+	})
+
+	t.Run("primary seq persisted", func(t *testing.T) {
+		mem := pagestore.NewMemory()
+		primary, err := NewStoreWithOptions("", Options{PageStore: mem, SyncPolicy: SyncAlways})
+		require.NoError(t, err)
+
+		for i := 0; i < 20; i++ {
+			require.NoError(t, primary.SetValue(fmt.Sprintf("key-%d", i), fmt.Sprintf("val-%d", i)))
+		}
+
+		reopened, err := OpenStore("", Options{PageStore: mem}) // основное хранилище упало, не вызвав Sync
+		require.NoError(t, err)
+		defer reopened.Close()
+		require.Equal(t, primary.LastSeq(), reopened.LastSeq()) // номера изменений не будут выданы повторно
+	})
+
+	t.Run("follower ahead of primary", func(t *testing.T) {
+		mem := pagestore.NewMemory()
+		faulty := pagestore.NewFaulty(mem)
+		faulty.CrashAt(-1, true)
+		primary, err := NewStoreWithOptions("", Options{PageStore: faulty, SyncPolicy: SyncNone})
+		require.NoError(t, err)
+		follower := newTestStore(t, Options{})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		primaryConn, followerConn := net.Pipe()
+		errs := make(chan error, 2)
+		go func() { errs <- primary.ServeFollower(ctx, primaryConn) }()
+		go func() { errs <- follower.Follow(ctx, followerConn) }()
+
+		for i := 0; i < 10; i++ {
+			require.NoError(t, primary.SetValue(fmt.Sprintf("key-%d", i), fmt.Sprintf("val-%d", i)))
+		}
+		require.NoError(t, primary.Sync())
+		for i := 10; i < 15; i++ { // реплика получит эти изменения, а основное хранилище их потеряет
+			require.NoError(t, primary.SetValue(fmt.Sprintf("key-%d", i), fmt.Sprintf("val-%d", i)))
+		}
+		require.Eventually(t, func() bool { return follower.LastSeq() == primary.LastSeq() }, 5*time.Second, 10*time.Millisecond)
+		cancel()
+		<-errs
+		<-errs
+
+		faulty.CrashAt(faulty.Writes()+1, true) // падение откатывает все после Sync
+		require.ErrorIs(t, primary.SetValue("key-0", "lost"), pagestore.ErrCrashed)
+
+		restarted, err := OpenStore("", Options{PageStore: mem})
+		require.NoError(t, err)
+		defer restarted.Close()
+		require.Less(t, restarted.LastSeq(), follower.LastSeq())
+		require.NoError(t, restarted.SetValue("key-0", "new")) // тот же номер, что у потерянного изменения
+
+		ctx, cancel = context.WithCancel(context.Background())
+		primaryConn, followerConn = net.Pipe()
+		go func() { errs <- restarted.ServeFollower(ctx, primaryConn) }()
+		go func() { errs <- follower.Follow(ctx, followerConn) }()
+		require.Eventually(t, func() bool { return follower.LastSeq() == restarted.LastSeq() }, 5*time.Second, 10*time.Millisecond)
+		cancel()
+		require.ErrorIs(t, <-errs, context.Canceled)
+		require.ErrorIs(t, <-errs, context.Canceled)
+
+		val, err := follower.GetValue("key-0")
+		require.NoError(t, err)
+		require.Equal(t, "new", val)
+		for i := 10; i < 15; i++ { // потерянные основным хранилищем изменения удалены и с реплики
+			_, err = follower.GetValue(fmt.Sprintf("key-%d", i))
+			require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+		}
+	})
+
+	t.Run("oversized change", func(t *testing.T) {
+		follower := newTestStore(t, Options{MaxKeySize: 16, MaxValueSize: 16})
+
+		primaryConn, followerConn := net.Pipe()
+		errs := make(chan error, 1)
+		go func() { errs <- follower.Follow(context.Background(), followerConn) }()
+
+		var handshake [8]byte
+		_, err := io.ReadFull(primaryConn, handshake[:])
+		require.NoError(t, err)
+
+		change := []byte{replStatusOK}
+		change = binary.BigEndian.AppendUint64(change, 1)
+		change = append(change, byte(OpPut), 0)      // основное пространство ключей
+		change = binary.AppendUvarint(change, 1<<40) // длина ключа, под которую нельзя выделять память
+		_, err = primaryConn.Write(change)
+		require.NoError(t, err)
+
+		require.ErrorIs(t, <-errs, ErrInvalidChange)
+		require.NoError(t, primaryConn.Close())
+	})
+}
+
+// Функция тестирования репликации по TCP и пересинхронизации по снимку при вытесненных из журнала изменениях
+func TestReplicationTCP(t *testing.T) {
+	primary := newTestStore(t, Options{ChangeLogSize: 4})
+	follower := newTestStore(t, Options{})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = primary.ServeReplication(ctx, ln) }()
+
+	require.NoError(t, primary.CreateKeyspace("users"))
+	require.NoError(t, primary.Keyspace("users").SetValue("roma", "dolznik"))
+	for i := 0; i < 10; i++ { // журнал хранит только 4 последних изменения
+		require.NoError(t, primary.SetValue(fmt.Sprintf("key-%d", i), fmt.Sprintf("val-%d", i)))
+	}
+	require.NoError(t, follower.SetValue("stale", "val")) // чего нет на основном хранилище, после снимка не останется
+	require.NoError(t, follower.CreateKeyspace("orders"))
+
+	followCtx, stopFollow := context.WithCancel(ctx)
+	followErr := make(chan error, 1)
+	go func() { followErr <- follower.FollowTCP(followCtx, ln.Addr().String()) }()
+
+	require.Eventually(t, func() bool { return follower.LastSeq() == primary.LastSeq() }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, primary.SetValue("key-0", "after snapshot")) // после снимка идут обычные изменения
+	require.Eventually(t, func() bool { return follower.LastSeq() == primary.LastSeq() }, 5*time.Second, 10*time.Millisecond)
+	stopFollow()
+	require.ErrorIs(t, <-followErr, context.Canceled)
+
+	for i := 1; i < 10; i++ {
+		val, err := follower.GetValue(fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("val-%d", i), val)
+	}
+	val, err := follower.GetValue("key-0")
+	require.NoError(t, err)
+	require.Equal(t, "after snapshot", val)
+	_, err = follower.GetValue("stale")
+	require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+	require.Equal(t, []string{"users"}, follower.Keyspaces())
+	val, err = follower.Keyspace("users").GetValue("roma")
+	require.NoError(t, err)
+	require.Equal(t, "dolznik", val)
+}
+
+// Функция тестирования основных операций на всех реализациях хранилища страниц
+func TestBackends(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		backend pagestore.Backend
+	}{
+		{name: "mmap", backend: pagestore.BackendMmap},
+		{name: "file", backend: pagestore.BackendFile},
+		{name: "memory", backend: pagestore.BackendMemory},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stor := newTestStore(t, Options{Backend: tc.backend})
+
+			for i := 0; i < 100; i++ {
+				require.NoError(t, stor.SetValue(fmt.Sprintf("key-%d", i), fmt.Sprintf("val-%d", i)))
+			}
+			require.NoError(t, stor.DeleteValue("key-0"))
+
+			_, err := stor.GetValue("key-0")
+			require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+			for i := 1; i < 100; i++ {
+				val, err := stor.GetValue(fmt.Sprintf("key-%d", i))
+				require.NoError(t, err)
+				require.Equal(t, fmt.Sprintf("val-%d", i), val)
+			}
+		})
+	}
+}
+
+// Функция тестирования поведения хранилища при сбоях диска
+func TestStoreFaults(t *testing.T) {
+	t.Run("no space", func(t *testing.T) {
+		faulty := pagestore.NewFaulty(pagestore.NewMemory())
+		stor, err := NewStoreWithOptions("", Options{PageStore: faulty})
+		require.NoError(t, err)
+
+		faulty.FailWrites(faulty.Writes()+1, pagestore.ErrNoSpace)
+		err = stor.SetValue("key", "val")
+		require.ErrorIs(t, err, syscall.ENOSPC)
+		require.Equal(t, HealthDiskFull, stor.Health()) // запись могла оборваться посреди страницы
+		require.ErrorIs(t, stor.SetValue("key", "val"), ErrDiskFull)
+	})
+
+	t.Run("bulk load no space", func(t *testing.T) {
+		mem := pagestore.NewMemory()
+		faulty := pagestore.NewFaulty(mem)
+		stor, err := NewStoreWithOptions("", Options{PageStore: faulty, MaxKeySize: 32, MaxValueSize: 32, SyncPolicy: SyncNone})
+		require.NoError(t, err)
+
+		for i := 0; i < 200; i++ {
+			require.NoError(t, stor.SetValue(fmt.Sprintf("old-%d", i), fmt.Sprintf("val-%d", i)))
+		}
+		require.NoError(t, stor.Sync())
+
+		var kvs []bkt.KV
+		for i := 0; i < 1000; i++ {
+			kvs = append(kvs, bkt.KV{Key: fmt.Sprintf("key-%d", i), Val: "val"})
+		}
+		faulty.FailWrites(faulty.Writes()+3, pagestore.ErrNoSpace) // место кончается посреди записи бакетов
+		require.ErrorIs(t, stor.BulkLoad(NewSliceIterator(kvs)), ErrDiskFull)
+
+		for i := 0; i < 200; i++ { // старые бакеты не тронуты
+			val, err := stor.GetValue(fmt.Sprintf("old-%d", i))
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("val-%d", i), val)
+		}
+
+		reopened, err := OpenStore("", Options{PageStore: mem}) // недописанные страницы при открытии бд свободны
+		require.NoError(t, err)
+		defer reopened.Close()
+
+		for i := 0; i < 200; i++ {
+			val, err := reopened.GetValue(fmt.Sprintf("old-%d", i))
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("val-%d", i), val)
+		}
+		_, err = reopened.GetValue("key-0")
+		require.ErrorIs(t, err, bkt.ErrKeyNotFound)
+	})
+
+	t.Run("repeated bulk load", func(t *testing.T) {
+		mem := pagestore.NewMemory()
+		stor, err := NewStoreWithOptions("", Options{PageStore: mem, MaxKeySize: 32, MaxValueSize: 32, SyncPolicy: SyncNone})
+		require.NoError(t, err)
+		defer stor.Close()
+
+		var kvs []bkt.KV
+		for i := 0; i < 1000; i++ {
+			kvs = append(kvs, bkt.KV{Key: fmt.Sprintf("key-%d", i), Val: "val"})
+		}
+
+		var sizes []int64
+		for i := 0; i < 4; i++ {
+			require.NoError(t, stor.BulkLoad(NewSliceIterator(kvs)))
+			size, err := mem.Size()
+			require.NoError(t, err)
+			sizes = append(sizes, size)
+		}
+		require.Equal(t, sizes[1], sizes[3]) // новые бакеты пишутся на место старых, файл не растет
+	})
+
+	t.Run("repeated bulk load with index", func(t *testing.T) {
+		mem := pagestore.NewMemory()
+		stor, err := NewStoreWithOptions("", Options{PageStore: mem, MaxKeySize: 32, MaxValueSize: 32, SyncPolicy: SyncNone, OrderedIndex: true})
+		require.NoError(t, err)
+		defer stor.Close()
+
+		var kvs []bkt.KV
+		for i := 0; i < 1000; i++ {
+			kvs = append(kvs, bkt.KV{Key: fmt.Sprintf("key-%d", i), Val: "val"})
+		}
+
+		var sizes []int64
+		for i := 0; i < 6; i++ {
+			require.NoError(t, stor.BulkLoad(NewSliceIterator(kvs)))
+			size, err := mem.Size()
+			require.NoError(t, err)
+			sizes = append(sizes, size)
+		}
+		require.Equal(t, sizes[3], sizes[5]) // страницы старого индекса освобождаются, где бы они ни лежали
+	})
+
+	t.Run("bulk load crash", func(t *testing.T) {
+		var kvs []bkt.KV
+		for i := 0; i < 200; i++ {
+			kvs = append(kvs, bkt.KV{Key: fmt.Sprintf("key-%d", i), Val: "new"})
+		}
+
+		for n := 1; ; n++ { // падаем на каждой записи по очереди, пока загрузка не пройдет целиком
+			mem := pagestore.NewMemory()
+			faulty := pagestore.NewFaulty(mem)
+			faulty.CrashAt(-1, true)
+			stor, err := NewStoreWithOptions("", Options{PageStore: faulty, MaxKeySize: 32, MaxValueSize: 32, SyncPolicy: SyncNone, OrderedIndex: true})
+			require.NoError(t, err)
+
+			require.NoError(t, stor.BulkLoad(NewSliceIterator(kvs[:50]))) // бакеты уже записаны массовой загрузкой
+			for i := 0; i < 100; i++ {
+				require.NoError(t, stor.SetValue(fmt.Sprintf("old-%d", i), fmt.Sprintf("val-%d", i)))
+			}
+			require.NoError(t, stor.Sync())
+
+			faulty.CrashAt(faulty.Writes()+n, false) // падение процесса: сделанные записи остаются в файле
+			loadErr := stor.BulkLoad(NewSliceIterator(kvs))
+
+			reopened, err := OpenStore("", Options{PageStore: mem})
+			require.NoError(t, err, "crash at write %d", n)
+
+			for i := 0; i < 100; i++ { // старые значения переживают падение в любой момент загрузки
+				val, err := reopened.GetValue(fmt.Sprintf("old-%d", i))
+				require.NoError(t, err, "crash at write %d", n)
+				require.Equal(t, fmt.Sprintf("val-%d", i), val)
+			}
+			_, err = reopened.GetValue("key-199")
+			loaded := err == nil
+			for _, kv := range kvs[50:] { // загрузка видна целиком или не видна совсем
+				_, err = reopened.GetValue(kv.Key)
+				require.Equal(t, loaded, err == nil, "crash at write %d, key %s", n, kv.Key)
+			}
+			require.NoError(t, reopened.SetValue("after", "crash"))
+			require.NoError(t, reopened.Close())
+
+			if loadErr == nil {
+				require.True(t, loaded)
+				break
+			}
+			require.ErrorIs(t, loadErr, pagestore.ErrCrashed)
+		}
+	})
+
+	t.Run("create keyspace failure", func(t *testing.T) {
+		mem := pagestore.NewMemory()
+		faulty := pagestore.NewFaulty(mem)
+		stor, err := NewStoreWithOptions("", Options{PageStore: faulty, InitialGlobalDepth: 2, SyncPolicy: SyncNone})
+		require.NoError(t, err)
+		defer stor.Close()
+
+		before, err := mem.Size()
+		require.NoError(t, err)
+		faulty.ShortWrite(faulty.Writes()+3, 0) // обрывается запись третьего из четырех бакетов
+		require.ErrorIs(t, stor.CreateKeyspace("users"), io.ErrShortWrite)
+		require.Empty(t, stor.Keyspaces())
+
+		require.NoError(t, stor.CreateKeyspace("users"))
+		after, err := mem.Size()
+		require.NoError(t, err)
+		require.Equal(t, before+4*int64(stor.bktOpts.Size()), after) // страницы неудачной попытки заняты заново
+	})
+
+	t.Run("crash after sync", func(t *testing.T) {
+		mem := pagestore.NewMemory()
+		faulty := pagestore.NewFaulty(mem)
+		faulty.CrashAt(-1, true) // откат несброшенных записей при падении
+
+		stor, err := NewStoreWithOptions("", Options{PageStore: faulty, SyncPolicy: SyncNone})
+		require.NoError(t, err)
+
+		for i := 0; i < 30; i++ {
+			require.NoError(t, stor.SetValue(fmt.Sprintf("key-%d", i), fmt.Sprintf("val-%d", i)))
+		}
+		require.NoError(t, stor.Sync())
+
+		faulty.CrashAt(faulty.Writes()+5, true) // падаем посреди следующих записей
+		for i := 30; i < 60; i++ {
+			if err = stor.SetValue(fmt.Sprintf("key-%d", i), fmt.Sprintf("val-%d", i)); err != nil {
+				break
+			}
+		}
+		require.ErrorIs(t, err, pagestore.ErrCrashed)
+
+		reopened, err := OpenStore("", Options{PageStore: mem}) // открываем то, что пережило падение
+		require.NoError(t, err)
+		defer reopened.Close()
+
+		for i := 0; i < 30; i++ {
+			val, err := reopened.GetValue(fmt.Sprintf("key-%d", i))
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("val-%d", i), val)
+		}
+	})
+}
+
+// Функция помошник для создания хранилища во временном файле
+func newTestStore(t *testing.T, opts Options) *Store {
+	tmpDBFile, err := os.CreateTemp("", "example-*.data")
+	require.NoError(t, err)
+	require.NoError(t, tmpDBFile.Close())
+
+	stor, err := NewStoreWithOptions(tmpDBFile.Name(), opts)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		require.NoError(t, stor.Close())
+		require.NoError(t, os.Remove(tmpDBFile.Name()))
+	})
+
+	return stor
+}
+
 // Функция помошник для опеределения размера файла
 func getSizeFile(t *testing.T, file *os.File) int64 {
 	fInfo, err := file.Stat()
@@ -0,0 +1,185 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Групповой коммит: записи не сбрасываются на диск по одной,
+// вместо этого вызывающие ждут общего fsync, который выполняется раз в interval
+// или сразу, как только набралось maxOps ожидающих записей.
+type groupCommitter struct {
+	mu      sync.Mutex
+	waiters []chan error // вызывающие, ожидающие следующего сброса
+
+	interval time.Duration
+	maxOps   int
+	sync     func() error
+
+	kick chan struct{} // сигнал на внеочередной сброс
+	done chan struct{} // сигнал на остановку
+	wg   sync.WaitGroup
+}
+
+// Функция создания и запуска группового коммита
+func newGroupCommitter(interval time.Duration, maxOps int, sync func() error) *groupCommitter {
+	g := &groupCommitter{
+		interval: interval,
+		maxOps:   maxOps,
+		sync:     sync,
+		kick:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	g.wg.Add(1)
+	go g.run()
+
+	return g
+}
+
+// Функция ожидания сброса записи на диск
+func (g *groupCommitter) wait() error {
+	ch := make(chan error, 1)
+
+	g.mu.Lock()
+	g.waiters = append(g.waiters, ch)
+	full := len(g.waiters) >= g.maxOps
+	g.mu.Unlock()
+
+	if full { // набралась группа - сбрасываем не дожидаясь таймера
+		select {
+		case g.kick <- struct{}{}:
+		default:
+		}
+	}
+
+	return <-ch
+}
+
+// Цикл группового коммита
+func (g *groupCommitter) run() {
+	defer g.wg.Done()
+
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-g.kick:
+		case <-g.done:
+			g.flush()
+			return
+		}
+		g.flush()
+	}
+}
+
+// Функция сброса: один fsync на всю группу, результат отдается всем ожидающим
+func (g *groupCommitter) flush() {
+	g.mu.Lock()
+	waiters := g.waiters
+	g.waiters = nil
+	g.mu.Unlock()
+
+	if len(waiters) == 0 {
+		return
+	}
+
+	err := g.sync()
+	for _, ch := range waiters {
+		ch <- err
+	}
+}
+
+// Функция остановки группового коммита, ожидающие записи сбрасываются
+func (g *groupCommitter) close() {
+	close(g.done)
+	g.wg.Wait()
+}
+
+// Функция запуска группового коммита, если он выбран политикой сброса
+func (s *Store) startCommitter() {
+	if s.opts.SyncPolicy == SyncGroup && !s.opts.ReadOnly {
+		s.committer = newGroupCommitter(s.opts.GroupCommitInterval, s.opts.GroupCommitOps, s.Sync)
+	}
+}
+
+// Функция подтверждения записи согласно политике сброса.
+// Для SyncAlways страница уже сброшена бакетом, следом сбрасывается заголовок с номером последнего изменения:
+// иначе после падения основное хранилище выдало бы те же номера другим изменениям, а реплики, успевшие
+// их получить, посчитали бы новые изменения уже примененными. Для SyncNone сброс остается на ОС.
+func (s *Store) commit() error {
+	if s.committer != nil {
+		if err := s.committer.wait(); err != nil {
+			return fmt.Errorf("store - commit: %w", err)
+		}
+		return nil
+	}
+
+	if !s.bktOpts.Sync {
+		return nil
+	}
+
+	s.mu.RLock()
+	err := s.writeHeader()
+	s.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("store - commit: %w", s.checkDiskFull(err))
+	}
+
+	return nil
+}
+
+// Sync - принудительно сбрасывает все изменения хранилища на диск независимо от политики сброса.
+// Вместе с данными в заголовке сохраняется номер последнего изменения.
+// Для хранилища только для чтения сбрасывать нечего.
+func (s *Store) Sync() (err error) {
+	defer func() { s.observeError("sync", "", err) }()
+
+	if s.opts.ReadOnly {
+		return nil
+	}
+
+	s.mu.RLock()
+	err = s.writeHeader()
+	s.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("store - Sync: %w", s.checkDiskFull(err))
+	}
+
+	if err = s.pages.Sync(); err != nil {
+		return fmt.Errorf("store - Sync: %w", s.checkDiskFull(err))
+	}
+
+	return nil
+}
+
+// Close - останавливает групповой коммит, сбрасывает изменения на диск и закрывает хранилище страниц,
+// вместе с ним снимается блокировка файла. Повторный вызов ничего не делает, записи после Close возвращают ErrClosed.
+func (s *Store) Close() error {
+	s.mu.Lock() // дожидаемся записей, которые уже держат блокировку
+	closed := s.closed.Swap(true)
+	s.mu.Unlock()
+	if closed {
+		return nil
+	}
+
+	s.watchers.close()
+
+	if s.committer != nil {
+		s.committer.close()
+		s.committer = nil
+	}
+
+	if err := s.Sync(); err != nil {
+		return err
+	}
+
+	if err := s.pages.Close(); err != nil {
+		return fmt.Errorf("store - Close: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"debildb/internal/parser"
+)
+
+// SetTyped - записывает значение с тегом типа в кодировке parser.MarshalValue.
+// Закодированное значение должно поместиться в MaxValueSize.
+func (s *Store) SetTyped(key string, value any) error {
+	return s.SetTypedContext(context.Background(), key, value)
+}
+
+// SetTypedContext - SetTyped с отменой и дедлайном из ctx
+func (s *Store) SetTypedContext(ctx context.Context, key string, value any) error {
+	data, err := parser.MarshalValue(value)
+	if err != nil {
+		return fmt.Errorf("store - SetTyped: %w", err)
+	}
+
+	return s.SetValueContext(ctx, key, string(data))
+}
+
+// GetTyped - читает значение, записанное SetTyped.
+// Для значения, записанного как строка через SetValue, возвращается ошибка parser.ErrInvalidValue.
+func (s *Store) GetTyped(key string) (any, error) {
+	return s.GetTypedContext(context.Background(), key)
+}
+
+// GetTypedContext - GetTyped с отменой и дедлайном из ctx
+func (s *Store) GetTypedContext(ctx context.Context, key string) (any, error) {
+	data, err := s.GetValueContext(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := parser.UnmarshalValue([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("store - GetTyped %q: %w", key, err)
+	}
+
+	return value, nil
+}
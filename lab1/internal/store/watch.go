@@ -0,0 +1,220 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	bkt "debildb/internal/bucket"
+)
+
+const (
+	defaultWatchBuffer = 256
+)
+
+// Event - изменение ключа, о котором уведомляется подписчик
+type Event struct {
+	Seq       uint64 // номер изменения, совпадает с номером в журнале изменений
+	Op        OpType
+	Key       string
+	Old       string // значение до изменения
+	OldExists bool   // ключ существовал до изменения
+	New       string // значение после изменения, пустое для удаления
+
+	// Overflow - подписчик не успевал читать события и был отключен.
+	// Это последнее событие в канале, остальные поля пустые; после него канал закрывается.
+	Overflow bool
+}
+
+// Подписка на изменения ключа или префикса
+type watcher struct {
+	key    string
+	prefix bool
+	ch     chan Event
+	limit  int           // сколько событий помещается в буфер, одно место в канале оставлено под событие переполнения
+	done   chan struct{} // закрывается при снятии подписки
+}
+
+// Реестр подписок хранилища.
+// События рассылаются без блокировки пишущего: медленный подписчик не тормозит запись.
+type watchers struct {
+	mu    sync.Mutex
+	set   map[*watcher]struct{}
+	limit int
+}
+
+// Watch - канал изменений ключа key.
+// Канал закрывается при отмене ctx, закрытии хранилища или переполнении буфера.
+// Буфер подписчика ограничен Options.WatchBuffer событиями: если подписчик не успевает их читать,
+// в канал отправляется событие с Overflow = true и подписка прекращается,
+// после чего подписчику нужно перечитать актуальные значения и подписаться заново.
+func (s *Store) Watch(ctx context.Context, key string) <-chan Event {
+	return s.watch(ctx, &watcher{key: key})
+}
+
+// WatchPrefix - канал изменений всех ключей, начинающихся с prefix, с теми же правилами, что и Watch
+func (s *Store) WatchPrefix(ctx context.Context, prefix string) <-chan Event {
+	return s.watch(ctx, &watcher{key: prefix, prefix: true})
+}
+
+// Функция регистрации подписки и ее снятия при отмене ctx
+func (s *Store) watch(ctx context.Context, w *watcher) <-chan Event {
+	w.limit = s.watchers.limit
+	w.ch = make(chan Event, w.limit+1)
+	w.done = make(chan struct{})
+	s.watchers.add(w)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.watchers.remove(w)
+		case <-w.done: // подписка снята из-за переполнения или закрытия хранилища
+		}
+	}()
+
+	return w.ch
+}
+
+// Функция создания реестра подписок
+func newWatchers(limit int) *watchers {
+	return &watchers{set: make(map[*watcher]struct{}), limit: limit}
+}
+
+// Функция проверки, что подписка следит за ключом
+func (w *watcher) matches(key string) bool {
+	if w.prefix {
+		return strings.HasPrefix(key, w.key)
+	}
+	return key == w.key
+}
+
+// Функция завершения подписки: закрывается канал событий. Вызывается под блокировкой реестра.
+func (w *watcher) stop() {
+	close(w.ch)
+	close(w.done)
+}
+
+// Функция добавления подписки
+func (ws *watchers) add(w *watcher) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.set == nil { // хранилище уже закрыто
+		w.stop()
+		return
+	}
+	ws.set[w] = struct{}{}
+}
+
+// Функция снятия подписки, канал закрывается
+func (ws *watchers) remove(w *watcher) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if _, ok := ws.set[w]; ok {
+		delete(ws.set, w)
+		w.stop()
+	}
+}
+
+// Функция проверки, есть ли подписчики на ключ. Позволяет не читать старое значение, если оно никому не нужно.
+func (ws *watchers) watched(key string) bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for w := range ws.set {
+		if w.matches(key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Функция рассылки события подписчикам ключа
+func (ws *watchers) publish(event Event) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for w := range ws.set {
+		if !w.matches(event.Key) {
+			continue
+		}
+
+		if len(w.ch) >= w.limit { // подписчик отстал - отключаем его
+			w.ch <- Event{Overflow: true}
+			delete(ws.set, w)
+			w.stop()
+			continue
+		}
+		w.ch <- event
+	}
+}
+
+// Функция закрытия всех подписок при закрытии хранилища
+func (ws *watchers) close() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for w := range ws.set {
+		w.stop()
+	}
+	ws.set = nil
+}
+
+// Значение ключа до изменения
+type oldValue struct {
+	val    string
+	exists bool
+}
+
+// Функция чтения значения ключа до изменения, если на ключ есть подписчики.
+// Вызывается под блокировкой хранилища.
+func (s *Store) watchedValue(key string) oldValue {
+	if !s.watchers.watched(key) {
+		return oldValue{}
+	}
+
+	val, err := s.getValue(key)
+	if err != nil {
+		return oldValue{}
+	}
+
+	return oldValue{val: val, exists: true}
+}
+
+// Функция чтения значений до массовой загрузки: для повторяющегося ключа старым считается предыдущее значение из kvs
+func (s *Store) watchedValues(kvs []bkt.KV) []oldValue {
+	olds := make([]oldValue, len(kvs))
+	latest := make(map[string]string)
+	for i, kv := range kvs {
+		if !s.watchers.watched(kv.Key) {
+			continue
+		}
+
+		if val, ok := latest[kv.Key]; ok {
+			olds[i] = oldValue{val: val, exists: true}
+		} else {
+			olds[i] = s.watchedValue(kv.Key)
+		}
+		latest[kv.Key] = kv.Val
+	}
+
+	return olds
+}
+
+// Функция уведомления подписчиков о зарегистрированном изменении основного пространства ключей
+func (s *Store) publishChange(change Change, old oldValue) {
+	if change.Keyspace != "" {
+		return
+	}
+
+	s.watchers.publish(Event{
+		Seq:       change.Seq,
+		Op:        change.Op,
+		Key:       change.Key,
+		Old:       old.val,
+		OldExists: old.exists,
+		New:       change.Val,
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"laba2/intrenal/kdtree"
+)
+
+// main демонстрирует использование KD-дерева и диапазонного поиска
+func main() {
+	// Пример точек для KD-дерева
+	points := []kdtree.Point{
+		{Coordinates: []float64{2, 3}},
+		{Coordinates: []float64{5, 4}},
+		{Coordinates: []float64{9, 6}},
+		{Coordinates: []float64{4, 7}},
+		{Coordinates: []float64{8, 1}},
+		{Coordinates: []float64{7, 2}},
+	}
+
+	// Создаем двумерное KD-дерево и вставляем в него точки
+	tree := kdtree.New(2)
+	for _, point := range points {
+		if err := tree.Insert(point); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Целевая точка для поиска ближайшего соседа
+	target := kdtree.Point{Coordinates: []float64{9, 2}}
+
+	// Поиск ближайшего соседа
+	nearest, dist, err := tree.FindNearest(target)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Печать результатов
+	fmt.Printf("Ближайшая точка к %v: %v на расстоянии %v\n", target.Coordinates, nearest.Coordinates, dist)
+
+	// Диапазонный поиск: ищем точки в прямоугольнике [3, 2] - [10, 8]
+	targetMin := kdtree.Point{Coordinates: []float64{3, 2}}
+	targetMax := kdtree.Point{Coordinates: []float64{10, 8}}
+	results, err := tree.RangeSearch(targetMin, targetMax)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Точки в диапазоне [%v, %v]:\n", targetMin.Coordinates, targetMax.Coordinates)
+	for _, point := range results {
+		fmt.Println(point.Coordinates) // Печать точек, найденных в диапазоне
+	}
+}
@@ -0,0 +1,175 @@
+package kdtree
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	defaultParallelThreshold = 4096 // поддеревья меньше этого не стоят отдельной горутины
+)
+
+// BuildOptions - параметры построения сбалансированного KD-дерева
+type BuildOptions struct {
+	Dims   int    // размерность дерева; 0 - по первой точке
+	Metric Metric // метрика поиска; nil - Euclidean
+
+	// SplitBySpread - делить узел по оси с наибольшим разбросом координат его точек, а не по depth % dims.
+	// Для вытянутых наборов точек, например GPS-треков вдоль дороги, дает более компактные поддеревья.
+	SplitBySpread bool
+
+	// ParallelThreshold - поддеревья от стольких точек строятся в отдельной горутине.
+	// 0 - значение по умолчанию, отрицательное значение - построение в одной горутине.
+	ParallelThreshold int
+}
+
+// Build строит сбалансированное KD-дерево из точек: каждый узел - медиана своих точек по оси разбиения,
+// поэтому высота дерева не больше log2(n)+1 независимо от порядка точек. Порядок points не меняется.
+func Build(points []Point) (*Tree, error) {
+	return BuildWithOptions(points, BuildOptions{})
+}
+
+// BuildWithOptions строит сбалансированное KD-дерево из точек с параметрами opts.
+// Медиана ищется quickselect-ом, поэтому построение занимает O(n log n) в среднем.
+func BuildWithOptions(points []Point, opts BuildOptions) (*Tree, error) {
+	dims := opts.Dims
+	if dims == 0 && len(points) > 0 {
+		dims = len(points[0].Coordinates)
+	}
+	if dims < 1 {
+		return nil, fmt.Errorf("kdtree - Build: %w: dimension %d", ErrDimensionMismatch, dims)
+	}
+
+	metric := opts.Metric
+	if metric == nil {
+		metric = Euclidean{}
+	}
+	if err := checkMetric(metric, dims); err != nil {
+		return nil, fmt.Errorf("kdtree - Build: %w", err)
+	}
+
+	t := NewWithMetric(dims, metric)
+	for i, point := range points {
+		if err := t.checkDims(point); err != nil {
+			return nil, fmt.Errorf("kdtree - Build: point %d: %w", i, err)
+		}
+	}
+
+	threshold := opts.ParallelThreshold
+	if threshold == 0 {
+		threshold = defaultParallelThreshold
+	}
+
+	b := builder{dims: dims, spread: opts.SplitBySpread, threshold: threshold}
+	pts := append([]Point(nil), points...) // quickselect переставляет точки, копируем, что бы не трогать points
+	t.root = b.build(pts, 0)
+	t.size = len(pts)
+
+	return t, nil
+}
+
+// builder - параметры рекурсивного построения
+type builder struct {
+	dims      int
+	spread    bool
+	threshold int // < 0 - без горутин
+}
+
+// build строит поддерево из pts на глубине depth.
+// Точки левее медианы не больше ее по оси узла, правее - не меньше.
+func (b builder) build(pts []Point, depth int) *node {
+	if len(pts) == 0 {
+		return nil
+	}
+
+	axis := depth % b.dims
+	if b.spread {
+		axis = widestAxis(pts, b.dims)
+	}
+
+	m := len(pts) / 2
+	selectNth(pts, m, axis)
+	n := &node{point: pts[m], axis: axis}
+
+	if b.threshold >= 0 && len(pts) >= b.threshold { // левое поддерево строим в отдельной горутине
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.left = b.build(pts[:m], depth+1)
+		}()
+		n.right = b.build(pts[m+1:], depth+1)
+		wg.Wait()
+
+		return n
+	}
+
+	n.left = b.build(pts[:m], depth+1)
+	n.right = b.build(pts[m+1:], depth+1)
+
+	return n
+}
+
+// widestAxis возвращает ось, по которой разброс координат точек наибольший
+func widestAxis(pts []Point, dims int) int {
+	best, bestSpread := 0, -1.0
+	for axis := 0; axis < dims; axis++ {
+		lo, hi := pts[0].Coordinates[axis], pts[0].Coordinates[axis]
+		for _, p := range pts[1:] {
+			lo = min(lo, p.Coordinates[axis])
+			hi = max(hi, p.Coordinates[axis])
+		}
+		if hi-lo > bestSpread {
+			best, bestSpread = axis, hi-lo
+		}
+	}
+
+	return best
+}
+
+// selectNth переставляет pts так, что pts[k] - k-я по порядку точка по оси axis,
+// левее нее точки не больше, правее - не меньше (quickselect).
+// Разбиение на три части не дает quickselect-у деградировать на повторяющихся координатах.
+func selectNth(pts []Point, k, axis int) {
+	lo, hi := 0, len(pts)-1
+	for lo < hi {
+		pivot := medianOfThree(pts[lo].Coordinates[axis], pts[(lo+hi)/2].Coordinates[axis], pts[hi].Coordinates[axis])
+
+		// [lo, lt) - меньше опорного, [lt, i) - равны ему, (gt, hi] - больше
+		lt, i, gt := lo, lo, hi
+		for i <= gt {
+			switch v := pts[i].Coordinates[axis]; {
+			case v < pivot:
+				pts[lt], pts[i] = pts[i], pts[lt]
+				lt++
+				i++
+			case v > pivot:
+				pts[i], pts[gt] = pts[gt], pts[i]
+				gt--
+			default:
+				i++
+			}
+		}
+
+		switch {
+		case k < lt:
+			hi = lt - 1
+		case k > gt:
+			lo = gt + 1
+		default:
+			return // k попал в равные опорному
+		}
+	}
+}
+
+// medianOfThree возвращает среднее из трех значений; защищает quickselect от отсортированных данных
+func medianOfThree(a, b, c float64) float64 {
+	if a > b {
+		a, b = b, a
+	}
+	if b > c {
+		b = c
+	}
+
+	return max(a, b)
+}
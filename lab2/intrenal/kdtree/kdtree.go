@@ -1,175 +1,204 @@
-package main
+package kdtree
 
 import (
-    "fmt"
-    "math"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	ErrDimensionMismatch = errors.New("point dimension mismatch")
+	ErrEmptyTree         = errors.New("tree is empty")
 )
 
 // Point представляет точку в многомерном пространстве
 type Point struct {
-    Coordinates []float64
+	Coordinates []float64
+}
+
+// Tree - KD-дерево точек фиксированной размерности. Пустое дерево готово к работе.
+// Для готового набора точек Build строит сбалансированное дерево, Insert его со временем разбалансирует.
+// Дерево не копирует координаты: вставленную точку нельзя менять.
+// Методы не потокобезопасны, но читать дерево из нескольких горутин можно, пока в него не пишут.
+// Поиск считает расстояния метрикой дерева, по умолчанию евклидовой.
+type Tree struct {
+	root   *node
+	dims   int
+	size   int
+	metric Metric
+}
+
+// node - узел KD-дерева, axis - ось, по которой узел делит пространство.
+// В левом поддереве координаты по этой оси не больше, чем у узла, в правом - не меньше.
+type node struct {
+	point Point
+	left  *node
+	right *node
+	axis  int
+}
+
+// New создает пустое KD-дерево точек размерности dims с евклидовой метрикой
+func New(dims int) *Tree {
+	return NewWithMetric(dims, Euclidean{})
+}
+
+// NewWithMetric создает пустое KD-дерево точек размерности dims, поиск в котором использует metric
+func NewWithMetric(dims int, metric Metric) *Tree {
+	if dims < 1 {
+		panic(fmt.Sprintf("kdtree: invalid dimension %d", dims))
+	}
+	if err := checkMetric(metric, dims); err != nil {
+		panic(fmt.Sprintf("kdtree: %v", err))
+	}
+
+	return &Tree{dims: dims, metric: metric}
 }
 
-// Node представляет узел KD-дерева
-type Node struct {
-    Point  Point
-    Left   *Node
-    Right  *Node
-    Axis   int
+// Dims возвращает размерность точек дерева
+func (t *Tree) Dims() int {
+	return t.dims
 }
 
-// NewNode создает новый узел KD-дерева
-func NewNode(point Point, axis int) *Node {
-    return &Node{Point: point, Axis: axis} // Инициализация узла с заданной точкой и осью
+// Metric возвращает метрику, которой дерево считает расстояния
+func (t *Tree) Metric() Metric {
+	return t.metric
+}
+
+// Len возвращает количество точек в дереве
+func (t *Tree) Len() int {
+	return t.size
 }
 
 // Insert добавляет точку в KD-дерево
-func (node *Node) Insert(point Point, depth int) {
-    axis := depth % len(point.Coordinates) // Вычисляем ось разбиения в зависимости от текущей глубины
-    if point.Coordinates[axis] < node.Point.Coordinates[axis] { // Сравниваем координату точки с координатой узла по текущей оси
-        if node.Left == nil {
-            node.Left = NewNode(point, axis) // Создаем новый левый узел, если он отсутствует
-        } else {
-            node.Left.Insert(point, depth+1) // Рекурсивно вставляем точку в левое поддерево
-        }
-    } else {
-        if node.Right == nil {
-            node.Right = NewNode(point, axis) // Создаем новый правый узел, если он отсутствует
-        } else {
-            node.Right.Insert(point, depth+1) // Рекурсивно вставляем точку в правое поддерево
-        }
-    }
+func (t *Tree) Insert(point Point) error {
+	if err := t.checkDims(point); err != nil {
+		return fmt.Errorf("kdtree - Insert: %w", err)
+	}
+
+	t.size++
+	if t.root == nil {
+		t.root = &node{point: point}
+		return nil
+	}
+
+	cur := t.root
+	for {
+		next := &cur.right
+		if point.Coordinates[cur.axis] < cur.point.Coordinates[cur.axis] { // Сравниваем координату точки с координатой узла по его оси
+			next = &cur.left
+		}
+		if *next == nil {
+			*next = &node{point: point, axis: (cur.axis + 1) % t.dims} // Новый узел делит пространство по следующей оси
+			return nil
+		}
+		cur = *next
+	}
+}
+
+// FindNearest ищет ближайшую к target точку и возвращает ее вместе с расстоянием в единицах метрики дерева
+func (t *Tree) FindNearest(target Point) (Point, float64, error) {
+	if err := t.checkDims(target); err != nil {
+		return Point{}, 0, fmt.Errorf("kdtree - FindNearest: %w", err)
+	}
+	if t.root == nil {
+		return Point{}, 0, fmt.Errorf("kdtree - FindNearest: %w", ErrEmptyTree)
+	}
+
+	best, bestDist := t.root.findNearest(target, t.metric)
+
+	return best.point, bestDist, nil
 }
 
-// FindNearest ищет ближайшую точку к заданной
-func (node *Node) FindNearest(target Point, depth int) (*Node, float64) {
-    if node == nil {
-        return nil, math.Inf(1) // Если узел пустой, возвращаем бесконечность как расстояние
-    }
-
-    axis := depth % len(target.Coordinates) // Определяем ось разбиения
-
-    var next, other *Node
-    if target.Coordinates[axis] < node.Point.Coordinates[axis] {
-        next, other = node.Left, node.Right // Определяем, в какое поддерево двигаться дальше
-    } else {
-        next, other = node.Right, node.Left
-    }
-
-    bestNode, _ := next.FindNearest(target, depth+1) // Ищем ближайшую точку в выбранном поддереве
-
-    best, bestDist := closerDistance(
-        node,
-        bestNode,
-        target,
-    )
-
-    // Проверка другой ветки, если текущий лучший результат не является наилучшим
-    if math.Abs(node.Point.Coordinates[axis]-target.Coordinates[axis]) < bestDist {
-        bestNode, _ := other.FindNearest(target, depth+1)
-        
-        best, bestDist = closerDistance(
-            best,
-            bestNode,
-            target,
-        )
-    }
-
-    return best, bestDist
+// RangeSearch возвращает все точки в прямоугольнике [targetMin, targetMax], границы включаются
+func (t *Tree) RangeSearch(targetMin, targetMax Point) ([]Point, error) {
+	if err := t.checkDims(targetMin); err != nil {
+		return nil, fmt.Errorf("kdtree - RangeSearch: %w", err)
+	}
+	if err := t.checkDims(targetMax); err != nil {
+		return nil, fmt.Errorf("kdtree - RangeSearch: %w", err)
+	}
+
+	results := []Point{}
+	t.root.rangeSearch(targetMin, targetMax, &results)
+
+	return results, nil
 }
 
-// RangeSearch выполняет поиск всех точек в пределах заданного диапазона
-func (node *Node) RangeSearch(targetMin, targetMax Point, depth int, results *[]Point) {
-    if node == nil {
-        return
-    }
-
-    // Проверяем, находится ли точка в диапазоне
-    isInRange := true
-    for i := 0; i < len(targetMin.Coordinates); i++ {
-        if node.Point.Coordinates[i] < targetMin.Coordinates[i] || node.Point.Coordinates[i] > targetMax.Coordinates[i] {
-            isInRange = false // Если хотя бы одна координата вне диапазона, то точка не подходит
-            break
-        }
-    }
-
-    if isInRange {
-        *results = append(*results, node.Point) // Добавляем точку в результаты поиска, если она в диапазоне
-    }
-
-    axis := depth % len(targetMin.Coordinates)
-
-    // Проверяем левую ветвь, если есть вероятность пересечения диапазона
-    if targetMin.Coordinates[axis] <= node.Point.Coordinates[axis] {
-        node.Left.RangeSearch(targetMin, targetMax, depth+1, results)
-    }
-
-    // Проверяем правую ветвь, если есть вероятность пересечения диапазона
-    if targetMax.Coordinates[axis] >= node.Point.Coordinates[axis] {
-        node.Right.RangeSearch(targetMin, targetMax, depth+1, results)
-    }
+// checkDims проверяет, что размерность точки совпадает с размерностью дерева
+func (t *Tree) checkDims(point Point) error {
+	if len(point.Coordinates) != t.dims {
+		return fmt.Errorf("%w: got %d, want %d", ErrDimensionMismatch, len(point.Coordinates), t.dims)
+	}
+
+	return nil
 }
 
-// closerDistance возвращает ближайший узел и расстояние между узлами
-func closerDistance(node *Node, bestNode *Node, target Point) (*Node, float64) {
-    if bestNode == nil {
-        return node, distance(node.Point, target) // Если лучший узел не найден, возвращаем текущий узел и его расстояние
-    }
-    d1 := distance(node.Point, target) // Расстояние от текущего узла до цели
-    d2 := distance(bestNode.Point, target) // Расстояние от лучшего узла до цели
-    if d1 < d2 {
-        return node, d1 // Возвращаем текущий узел, если он ближе
-    }
-    return bestNode, d2 // Возвращаем лучший узел, если он ближе
+// findNearest ищет ближайший к target по метрике m узел поддерева
+func (n *node) findNearest(target Point, m Metric) (*node, float64) {
+	if n == nil {
+		return nil, math.Inf(1) // Если узел пустой, возвращаем бесконечность как расстояние
+	}
+
+	var next, other *node
+	if target.Coordinates[n.axis] < n.point.Coordinates[n.axis] {
+		next, other = n.left, n.right // Определяем, в какое поддерево двигаться дальше
+	} else {
+		next, other = n.right, n.left
+	}
+
+	bestNode, _ := next.findNearest(target, m) // Ищем ближайшую точку в выбранном поддереве
+
+	best, bestDist := closerDistance(n, bestNode, target, m)
+
+	// Проверка другой ветки, если шар вокруг цели пересекает разделяющую плоскость
+	if m.AxisBound(n.axis, n.point.Coordinates[n.axis]-target.Coordinates[n.axis]) < bestDist {
+		bestNode, _ := other.findNearest(target, m)
+
+		best, bestDist = closerDistance(best, bestNode, target, m)
+	}
+
+	return best, bestDist
 }
 
-// distance вычисляет евклидово расстояние между двумя точками
-func distance(a, b Point) float64 {
-    var sum float64
-    for i := range a.Coordinates { // Проходим по всем координатам и считаем сумму квадратов разностей
-        diff := a.Coordinates[i] - b.Coordinates[i]
-        sum += diff * diff
-    }
-    return math.Sqrt(sum) // Возвращаем квадратный корень из суммы квадратов разностей
+// rangeSearch добавляет в results все точки поддерева в пределах заданного диапазона
+func (n *node) rangeSearch(targetMin, targetMax Point, results *[]Point) {
+	if n == nil {
+		return
+	}
+
+	// Проверяем, находится ли точка в диапазоне
+	isInRange := true
+	for i := range targetMin.Coordinates {
+		if n.point.Coordinates[i] < targetMin.Coordinates[i] || n.point.Coordinates[i] > targetMax.Coordinates[i] {
+			isInRange = false // Если хотя бы одна координата вне диапазона, то точка не подходит
+			break
+		}
+	}
+
+	if isInRange {
+		*results = append(*results, n.point) // Добавляем точку в результаты поиска, если она в диапазоне
+	}
+
+	// Проверяем левую ветвь, если есть вероятность пересечения диапазона
+	if targetMin.Coordinates[n.axis] <= n.point.Coordinates[n.axis] {
+		n.left.rangeSearch(targetMin, targetMax, results)
+	}
+
+	// Проверяем правую ветвь, если есть вероятность пересечения диапазона
+	if targetMax.Coordinates[n.axis] >= n.point.Coordinates[n.axis] {
+		n.right.rangeSearch(targetMin, targetMax, results)
+	}
 }
 
-// main демонстрирует использование KD-дерева и диапазонного поиска
-func main() {
-    // Пример точек для KD-дерева
-    points := []Point{
-        {[]float64{2, 3}},
-        {[]float64{5, 4}},
-        {[]float64{9, 6}},
-        {[]float64{4, 7}},
-        {[]float64{8, 1}},
-        {[]float64{7, 2}},
-    }
-
-    // Создаем корневой узел KD-дерева
-    root := NewNode(points[0], 0)
-
-    // Вставляем остальные точки в дерево
-    for i := 1; i < len(points); i++ {
-        root.Insert(points[i], 0) // Вставка каждой точки с начальной глубиной 0
-    }
-
-    // Целевая точка для поиска ближайшего соседа
-    target := Point{[]float64{9, 2}}
-
-    // Поиск ближайшего соседа
-    nearest, dist := root.FindNearest(target, 0)
-
-    // Печать результатов
-    fmt.Printf("Ближайшая точка к %v: %v на расстоянии %v\n", target.Coordinates, nearest.Point.Coordinates, dist)
-
-    // Диапазонный поиск: ищем точки в прямоугольнике [3, 2] - [9, 5]
-    targetMin := Point{Coordinates: []float64{3, 2}}
-    targetMax := Point{Coordinates: []float64{10, 8}}
-    results := []Point{}
-    root.RangeSearch(targetMin, targetMax, 0, &results)
-
-    fmt.Printf("Точки в диапазоне [%v, %v]:\n", targetMin.Coordinates, targetMax.Coordinates)
-    for _, point := range results {
-        fmt.Println(point.Coordinates) // Печать точек, найденных в диапазоне
-    }
+// closerDistance возвращает ближайший узел и расстояние между узлами
+func closerDistance(n *node, bestNode *node, target Point, m Metric) (*node, float64) {
+	if bestNode == nil {
+		return n, m.Distance(n.point, target) // Если лучший узел не найден, возвращаем текущий узел и его расстояние
+	}
+	d1 := m.Distance(n.point, target)        // Расстояние от текущего узла до цели
+	d2 := m.Distance(bestNode.point, target) // Расстояние от лучшего узла до цели
+	if d1 < d2 {
+		return n, d1 // Возвращаем текущий узел, если он ближе
+	}
+	return bestNode, d2 // Возвращаем лучший узел, если он ближе
 }
@@ -1,218 +1,274 @@
-package main
+package kdtree
 
 import (
-    "testing"
+	"testing"
 )
 
 // Бенчмарк для функции Insert c 10 точками
 func BenchmarkInsert10(b *testing.B) {
-    points := generateRandomPoints(10) // Генерируем 10 случайных точек для вставки
-    root := NewNode(points[0], 0)
+	points := generateRandomPoints(10) // Генерируем 10 случайных точек для вставки
+	tree := New(2)
 
-    b.ResetTimer() // Сбрасываем таймер перед началом бенчмарка
+	b.ResetTimer() // Сбрасываем таймер перед началом бенчмарка
 
-    for i := 0; i < b.N; i++ {
-        // Вставляем все точки в дерево
-        for j := 1; j < len(points); j++ {
-            root.Insert(points[j], 0)
-        }
-    }
+	for i := 0; i < b.N; i++ {
+		// Вставляем все точки в дерево
+		for j := range points {
+			tree.Insert(points[j])
+		}
+	}
 }
 
 // Бенчмарк для функции Insert с 100 точками
 func BenchmarkInsert100(b *testing.B) {
-    points := generateRandomPoints(100) // Генерируем 100 случайных точек для вставки
-    root := NewNode(points[0], 0)
+	points := generateRandomPoints(100) // Генерируем 100 случайных точек для вставки
+	tree := New(2)
 
-    b.ResetTimer() // Сбрасываем таймер перед началом бенчмарка
+	b.ResetTimer() // Сбрасываем таймер перед началом бенчмарка
 
-    for i := 0; i < b.N; i++ {
-        // Вставляем все точки в дерево
-        for j := 1; j < len(points); j++ {
-            root.Insert(points[j], 0)
-        }
-    }
+	for i := 0; i < b.N; i++ {
+		// Вставляем все точки в дерево
+		for j := range points {
+			tree.Insert(points[j])
+		}
+	}
 }
 
 // Бенчмарк для функции Insert с 1000 точками
 func BenchmarkInsert1000(b *testing.B) {
-    points := generateRandomPoints(1000) // Генерируем 1000 случайных точек для вставки
-    root := NewNode(points[0], 0)
+	points := generateRandomPoints(1000) // Генерируем 1000 случайных точек для вставки
+	tree := New(2)
 
-    b.ResetTimer() // Сбрасываем таймер перед началом бенчмарка
+	b.ResetTimer() // Сбрасываем таймер перед началом бенчмарка
 
-    for i := 0; i < b.N; i++ {
-        // Вставляем все точки в дерево
-        for j := 1; j < len(points); j++ {
-            root.Insert(points[j], 0)
-        }
-    }
+	for i := 0; i < b.N; i++ {
+		// Вставляем все точки в дерево
+		for j := range points {
+			tree.Insert(points[j])
+		}
+	}
 }
 
 // Бенчмарк для функции Insert с 10000 точками
 func BenchmarkInsert10000(b *testing.B) {
-    points := generateRandomPoints(10000) // Генерируем 10000 случайных точек для вставки
-    root := NewNode(points[0], 0)
+	points := generateRandomPoints(10000) // Генерируем 10000 случайных точек для вставки
+	tree := New(2)
 
-    b.ResetTimer() // Сбрасываем таймер перед началом бенчмарка
+	b.ResetTimer() // Сбрасываем таймер перед началом бенчмарка
 
-    for i := 0; i < b.N; i++ {
-        // Вставляем все точки в дерево
-        for j := 1; j < len(points); j++ {
-            root.Insert(points[j], 0)
-        }
-    }
+	for i := 0; i < b.N; i++ {
+		// Вставляем все точки в дерево
+		for j := range points {
+			tree.Insert(points[j])
+		}
+	}
 }
 
 // Бенчмарк для функции FindNearest с 10 точками
 func BenchmarkFindNearest10(b *testing.B) {
-    points := generateRandomPoints(10)
-    root := NewNode(points[0], 0)
-    for i := 1; i < len(points); i++ {
-        root.Insert(points[i], 0)
-    }
+	points := generateRandomPoints(10)
+	tree := benchTree(points)
 
-    target := Point{Coordinates: []float64{50, 50}}
+	target := Point{Coordinates: []float64{50, 50}}
 
-    b.ResetTimer()
+	b.ResetTimer()
 
-    for i := 0; i < b.N; i++ {
-        root.FindNearest(target, 0)
-    }
+	for i := 0; i < b.N; i++ {
+		tree.FindNearest(target)
+	}
 }
 
 // Бенчмарк для функции FindNearest с 100 точками
 func BenchmarkFindNearest100(b *testing.B) {
-    points := generateRandomPoints(100)
-    root := NewNode(points[0], 0)
-    for i := 1; i < len(points); i++ {
-        root.Insert(points[i], 0)
-    }
+	points := generateRandomPoints(100)
+	tree := benchTree(points)
 
-    target := Point{Coordinates: []float64{50, 50}}
+	target := Point{Coordinates: []float64{50, 50}}
 
-    b.ResetTimer()
+	b.ResetTimer()
 
-    for i := 0; i < b.N; i++ {
-        root.FindNearest(target, 0)
-    }
+	for i := 0; i < b.N; i++ {
+		tree.FindNearest(target)
+	}
 }
 
 // Бенчмарк для функции FindNearest с 1000 точками
 func BenchmarkFindNearest1000(b *testing.B) {
-    points := generateRandomPoints(1000)
-    root := NewNode(points[0], 0)
-    for i := 1; i < len(points); i++ {
-        root.Insert(points[i], 0)
-    }
+	points := generateRandomPoints(1000)
+	tree := benchTree(points)
 
-    target := Point{Coordinates: []float64{50, 50}}
+	target := Point{Coordinates: []float64{50, 50}}
 
-    b.ResetTimer()
+	b.ResetTimer()
 
-    for i := 0; i < b.N; i++ {
-        root.FindNearest(target, 0)
-    }
+	for i := 0; i < b.N; i++ {
+		tree.FindNearest(target)
+	}
 }
 
 // Бенчмарк для функции FindNearest с 10000 точками
 func BenchmarkFindNearest10000(b *testing.B) {
-    points := generateRandomPoints(10000)
-    root := NewNode(points[0], 0)
-    for i := 1; i < len(points); i++ {
-        root.Insert(points[i], 0)
-    }
+	points := generateRandomPoints(10000)
+	tree := benchTree(points)
 
-    target := Point{Coordinates: []float64{50, 50}}
+	target := Point{Coordinates: []float64{50, 50}}
 
-    b.ResetTimer()
+	b.ResetTimer()
 
-    for i := 0; i < b.N; i++ {
-        root.FindNearest(target, 0)
-    }
+	for i := 0; i < b.N; i++ {
+		tree.FindNearest(target)
+	}
 }
 
 // Бенчмарк для функции RangeSearch с 10 точками
 func BenchmarkRangeSearch10(b *testing.B) {
-    points := generateRandomPoints(10)
-    root := NewNode(points[0], 0)
-    for i := 1; i < len(points); i++ {
-        root.Insert(points[i], 0)
-    }
+	points := generateRandomPoints(10)
+	tree := benchTree(points)
 
-    targetMin := Point{Coordinates: []float64{30, 30}}
-    targetMax := Point{Coordinates: []float64{70, 70}}
+	targetMin := Point{Coordinates: []float64{30, 30}}
+	targetMax := Point{Coordinates: []float64{70, 70}}
 
-    b.ResetTimer()
+	b.ResetTimer()
 
-    for i := 0; i < b.N; i++ {
-        results := []Point{}
-        root.RangeSearch(targetMin, targetMax, 0, &results)
-    }
+	for i := 0; i < b.N; i++ {
+		tree.RangeSearch(targetMin, targetMax)
+	}
 }
 
 // Бенчмарк для функции RangeSearch с 100 точками
 func BenchmarkRangeSearch100(b *testing.B) {
-    points := generateRandomPoints(100)
-    root := NewNode(points[0], 0)
-    for i := 1; i < len(points); i++ {
-        root.Insert(points[i], 0)
-    }
+	points := generateRandomPoints(100)
+	tree := benchTree(points)
 
-    targetMin := Point{Coordinates: []float64{30, 30}}
-    targetMax := Point{Coordinates: []float64{70, 70}}
+	targetMin := Point{Coordinates: []float64{30, 30}}
+	targetMax := Point{Coordinates: []float64{70, 70}}
 
-    b.ResetTimer()
+	b.ResetTimer()
 
-    for i := 0; i < b.N; i++ {
-        results := []Point{}
-        root.RangeSearch(targetMin, targetMax, 0, &results)
-    }
+	for i := 0; i < b.N; i++ {
+		tree.RangeSearch(targetMin, targetMax)
+	}
 }
 
 // Бенчмарк для функции RangeSearch с 1000 точками
 func BenchmarkRangeSearch1000(b *testing.B) {
-    points := generateRandomPoints(1000)
-    root := NewNode(points[0], 0)
-    for i := 1; i < len(points); i++ {
-        root.Insert(points[i], 0)
-    }
+	points := generateRandomPoints(1000)
+	tree := benchTree(points)
 
-    targetMin := Point{Coordinates: []float64{30, 30}}
-    targetMax := Point{Coordinates: []float64{70, 70}}
+	targetMin := Point{Coordinates: []float64{30, 30}}
+	targetMax := Point{Coordinates: []float64{70, 70}}
 
-    b.ResetTimer()
+	b.ResetTimer()
 
-    for i := 0; i < b.N; i++ {
-        results := []Point{}
-        root.RangeSearch(targetMin, targetMax, 0, &results)
-    }
+	for i := 0; i < b.N; i++ {
+		tree.RangeSearch(targetMin, targetMax)
+	}
 }
 
 // Бенчмарк для функции RangeSearch с 10000 точками
 func BenchmarkRangeSearch10000(b *testing.B) {
-    points := generateRandomPoints(10000)
-    root := NewNode(points[0], 0)
-    for i := 1; i < len(points); i++ {
-        root.Insert(points[i], 0)
-    }
+	points := generateRandomPoints(10000)
+	tree := benchTree(points)
 
-    targetMin := Point{Coordinates: []float64{30, 30}}
-    targetMax := Point{Coordinates: []float64{70, 70}}
+	targetMin := Point{Coordinates: []float64{30, 30}}
+	targetMax := Point{Coordinates: []float64{70, 70}}
 
-    b.ResetTimer()
+	b.ResetTimer()
 
-    for i := 0; i < b.N; i++ {
-        results := []Point{}
-        root.RangeSearch(targetMin, targetMax, 0, &results)
-    }
+	for i := 0; i < b.N; i++ {
+		tree.RangeSearch(targetMin, targetMax)
+	}
 }
 
 // Вспомогательная функция для генерации случайных точек
 func generateRandomPoints(num int) []Point {
-    points := make([]Point, num)
-    for i := 0; i < num; i++ {
-        points[i] = Point{Coordinates: []float64{float64(i), float64(i * i % 100)}}
-    }
-    return points
-}
\ No newline at end of file
+	points := make([]Point, num)
+	for i := 0; i < num; i++ {
+		points[i] = Point{Coordinates: []float64{float64(i), float64(i * i % 100)}}
+	}
+	return points
+}
+
+// Бенчмарк для функции Build с 10000 точками
+func BenchmarkBuild10000(b *testing.B) {
+	points := generateRandomPoints(10000)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		BuildWithOptions(points, BuildOptions{ParallelThreshold: -1})
+	}
+}
+
+// Бенчмарк для параллельной функции Build со 100000 точками
+func BenchmarkBuildParallel100000(b *testing.B) {
+	points := generateRandomPoints(100000)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		Build(points)
+	}
+}
+
+// Бенчмарк для функции FindNearest в сбалансированном дереве с 10000 точками;
+// generateRandomPoints упорядочены по x, поэтому вставкой по одной дерево вырождается
+func BenchmarkFindNearestBalanced10000(b *testing.B) {
+	tree, _ := Build(generateRandomPoints(10000))
+
+	target := Point{Coordinates: []float64{50, 50}}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		tree.FindNearest(target)
+	}
+}
+
+// Бенчмарк для функции KNearest с k = 10 в сбалансированном дереве с 10000 точками
+func BenchmarkKNearest10000(b *testing.B) {
+	tree, _ := Build(generateRandomPoints(10000))
+
+	target := Point{Coordinates: []float64{50, 50}}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		tree.KNearest(target, 10)
+	}
+}
+
+// Бенчмарк для функции KNearest с k = 10 и метрикой SquaredEuclidean, без корня на каждом сравнении
+func BenchmarkKNearestSquared10000(b *testing.B) {
+	tree, _ := BuildWithOptions(generateRandomPoints(10000), BuildOptions{Metric: SquaredEuclidean{}})
+
+	target := Point{Coordinates: []float64{50, 50}}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		tree.KNearest(target, 10)
+	}
+}
+
+// Бенчмарк для функции CountWithinRadius в сбалансированном дереве с 10000 точками
+func BenchmarkCountWithinRadius10000(b *testing.B) {
+	tree, _ := Build(generateRandomPoints(10000))
+
+	center := Point{Coordinates: []float64{50, 50}}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		tree.CountWithinRadius(center, 20)
+	}
+}
+
+// Вспомогательная функция для построения дерева из точек
+func benchTree(points []Point) *Tree {
+	tree := New(2)
+	for _, point := range points {
+		tree.Insert(point)
+	}
+	return tree
+}
@@ -1,8 +1,10 @@
-package main
+package kdtree
 
 import (
 	"math"
+	"math/rand"
 	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -19,28 +21,46 @@ func TestInsert(t *testing.T) {
 		{[]float64{7, 2}},
 	}
 
-	// Создаем корневой узел
-	root := NewNode(points[0], 0)
-
-	// Вставляем остальные точки в дерево
-	for i := 1; i < len(points); i++ {
-		root.Insert(points[i], 0)
-	}
+	tree := newTestTree(t, points)
 
 	// Проверяем наличие всех точек
-	require.True(t, treeContains(root, points[1]), "Точка %v не была найдена в KD-дереве после вставки", points[1])
-	require.True(t, treeContains(root, points[5]), "Точка %v не была найдена в KD-дереве после вставки", points[5])
+	require.Equal(t, len(points), tree.Len())
+	require.True(t, treeContains(tree.root, points[1]), "Точка %v не была найдена в KD-дереве после вставки", points[1])
+	require.True(t, treeContains(tree.root, points[5]), "Точка %v не была найдена в KD-дереве после вставки", points[5])
+
+	err := tree.Insert(Point{[]float64{1, 2, 3}})
+	require.ErrorIs(t, err, ErrDimensionMismatch)
+	require.Equal(t, len(points), tree.Len())
 }
 
 // Вспомогательная функция для проверки наличия точки в KD-дереве
-func treeContains(root *Node, target Point) bool {
+func treeContains(root *node, target Point) bool {
 	if root == nil {
 		return false
 	}
-	if reflect.DeepEqual(root.Point, target) {
+	if reflect.DeepEqual(root.point, target) {
 		return true
 	}
-	return treeContains(root.Left, target) || treeContains(root.Right, target)
+	return treeContains(root.left, target) || treeContains(root.right, target)
+}
+
+// Тестируем пустое дерево
+func TestEmptyTree(t *testing.T) {
+	tree := New(2)
+	require.Equal(t, 0, tree.Len())
+	require.Equal(t, 2, tree.Dims())
+
+	_, _, err := tree.FindNearest(Point{[]float64{1, 1}})
+	require.ErrorIs(t, err, ErrEmptyTree)
+
+	results, err := tree.RangeSearch(Point{[]float64{0, 0}}, Point{[]float64{10, 10}})
+	require.NoError(t, err)
+	require.Empty(t, results)
+
+	_, err = tree.RangeSearch(Point{[]float64{0}}, Point{[]float64{10, 10}})
+	require.ErrorIs(t, err, ErrDimensionMismatch)
+
+	require.Panics(t, func() { New(0) })
 }
 
 // Тестируем поиск ближайшей точки в KD-дереве
@@ -54,21 +74,19 @@ func TestFindNearest(t *testing.T) {
 		{[]float64{7, 2}},
 	}
 
-	// Создаем корневой узел
-	root := NewNode(points[0], 0)
-
-	// Вставляем остальные точки в дерево
-	for i := 1; i < len(points); i++ {
-		root.Insert(points[i], 0)
-	}
+	tree := newTestTree(t, points)
 
 	target := Point{[]float64{9, 2}}
 	expected := Point{[]float64{8, 1}}
 
-	nearest, _ := root.FindNearest(target, 0)
+	nearest, dist, err := tree.FindNearest(target)
+
+	require.NoError(t, err, "Ближайший узел не найден")
+	require.Equal(t, expected, nearest, "Ожидаемая ближайшая точка %v, полученная %v", expected.Coordinates, nearest.Coordinates)
+	require.Equal(t, math.Sqrt(2), dist)
 
-	require.NotNil(t, nearest, "Ближайший узел не найден")
-	require.Equal(t, expected, nearest.Point, "Ожидаемая ближайшая точка %v, полученная %v", expected.Coordinates, nearest.Point.Coordinates)
+	_, _, err = tree.FindNearest(Point{[]float64{9}})
+	require.ErrorIs(t, err, ErrDimensionMismatch)
 }
 
 // Тестируем диапазонный поиск в KD-дереве
@@ -82,13 +100,7 @@ func TestRangeSearch(t *testing.T) {
 		{[]float64{7, 2}},
 	}
 
-	// Создаем корневой узел
-	root := NewNode(points[0], 0)
-
-	// Вставляем остальные точки в дерево
-	for i := 1; i < len(points); i++ {
-		root.Insert(points[i], 0)
-	}
+	tree := newTestTree(t, points)
 
 	targetMin := Point{Coordinates: []float64{3, 2}}
 	targetMax := Point{Coordinates: []float64{10, 8}}
@@ -99,8 +111,8 @@ func TestRangeSearch(t *testing.T) {
 		{[]float64{7, 2}},
 	}
 
-	results := []Point{}
-	root.RangeSearch(targetMin, targetMax, 0, &results)
+	results, err := tree.RangeSearch(targetMin, targetMax)
+	require.NoError(t, err)
 
 	require.Len(t, results, len(expectedResults), "Ожидаемое количество точек %v, полученное количество %v", len(expectedResults), len(results))
 
@@ -109,6 +121,16 @@ func TestRangeSearch(t *testing.T) {
 	}
 }
 
+// Вспомогательная функция для построения KD-дерева из точек
+func newTestTree(t *testing.T, points []Point) *Tree {
+	tree := New(len(points[0].Coordinates))
+	for _, point := range points {
+		require.NoError(t, tree.Insert(point))
+	}
+
+	return tree
+}
+
 // Вспомогательная функция для проверки наличия точки в результатах поиска
 func contains(points []Point, target Point) bool {
 	for _, p := range points {
@@ -119,13 +141,316 @@ func contains(points []Point, target Point) bool {
 	return false
 }
 
+// Тестируем построение сбалансированного KD-дерева
+func TestBuild(t *testing.T) {
+	track := make([]Point, 10000) // отсортированный по времени трек: вставкой по одной получилась бы цепочка
+	for i := range track {
+		track[i] = Point{[]float64{float64(i), float64(i % 7)}}
+	}
+	input := append([]Point(nil), track...)
+
+	for _, opts := range []BuildOptions{
+		{},
+		{SplitBySpread: true},
+		{ParallelThreshold: 100},
+		{ParallelThreshold: -1},
+	} {
+		tree, err := BuildWithOptions(track, opts)
+		require.NoError(t, err)
+		require.Equal(t, input, track, "Build не должен менять порядок точек")
+		require.Equal(t, len(track), tree.Len())
+		require.LessOrEqual(t, height(tree.root), int(math.Log2(float64(len(track))))+1)
+		checkInvariant(t, tree.root)
+
+		for _, target := range []Point{{[]float64{-5, 3}}, {[]float64{5000.4, 6}}, {[]float64{20000, -1}}} {
+			nearest, dist, err := tree.FindNearest(target)
+			require.NoError(t, err)
+			require.Equal(t, bruteNearest(track, target), dist, "Ближайшая точка к %v: %v", target.Coordinates, nearest.Coordinates)
+		}
+
+		require.NoError(t, tree.Insert(Point{[]float64{0.5, 0.5}})) // в построенное дерево можно вставлять
+		require.True(t, treeContains(tree.root, Point{[]float64{0.5, 0.5}}))
+	}
+
+	// при наибольшем разбросе корень делит по оси x, хотя трек почти не меняется по y
+	spread, err := BuildWithOptions(track, BuildOptions{SplitBySpread: true})
+	require.NoError(t, err)
+	require.Equal(t, 0, spread.root.axis)
+	vertical := []Point{{[]float64{0, 0}}, {[]float64{0, 10}}, {[]float64{1, 20}}, {[]float64{0, 30}}}
+	spread, err = BuildWithOptions(vertical, BuildOptions{SplitBySpread: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, spread.root.axis)
+
+	// параллельное построение дает то же дерево, что и последовательное
+	seq, err := BuildWithOptions(track, BuildOptions{ParallelThreshold: -1})
+	require.NoError(t, err)
+	par, err := BuildWithOptions(track, BuildOptions{ParallelThreshold: 16})
+	require.NoError(t, err)
+	require.Equal(t, seq, par)
+
+	same := make([]Point, 5000) // одинаковые координаты не должны вырождать quickselect
+	for i := range same {
+		same[i] = Point{[]float64{1, 1}}
+	}
+	tree, err := Build(same)
+	require.NoError(t, err)
+	require.Equal(t, len(same), tree.Len())
+	require.LessOrEqual(t, height(tree.root), int(math.Log2(float64(len(same))))+1)
+
+	tree, err = BuildWithOptions(nil, BuildOptions{Dims: 3})
+	require.NoError(t, err)
+	require.Equal(t, 0, tree.Len())
+	require.Equal(t, 3, tree.Dims())
+
+	_, err = Build(nil) // размерность не из чего взять
+	require.ErrorIs(t, err, ErrDimensionMismatch)
+	_, err = Build([]Point{{[]float64{1, 2}}, {[]float64{1}}})
+	require.ErrorIs(t, err, ErrDimensionMismatch)
+}
+
+// Вспомогательная функция для подсчета высоты поддерева
+func height(n *node) int {
+	if n == nil {
+		return 0
+	}
+	return 1 + max(height(n.left), height(n.right))
+}
+
+// Вспомогательная функция для проверки, что каждый узел разделяет свои поддеревья по своей оси
+func checkInvariant(t *testing.T, n *node) {
+	if n == nil {
+		return
+	}
+	var walk func(sub *node, left bool)
+	walk = func(sub *node, left bool) {
+		if sub == nil {
+			return
+		}
+		if left {
+			require.LessOrEqual(t, sub.point.Coordinates[n.axis], n.point.Coordinates[n.axis])
+		} else {
+			require.GreaterOrEqual(t, sub.point.Coordinates[n.axis], n.point.Coordinates[n.axis])
+		}
+		walk(sub.left, left)
+		walk(sub.right, left)
+	}
+	walk(n.left, true)
+	walk(n.right, false)
+	checkInvariant(t, n.left)
+	checkInvariant(t, n.right)
+}
+
+// Вспомогательная функция для поиска расстояния до ближайшей точки перебором
+func bruteNearest(points []Point, target Point) float64 {
+	best := math.Inf(1)
+	for _, p := range points {
+		best = min(best, Euclidean{}.Distance(p, target))
+	}
+	return best
+}
+
+// Тестируем поиск k ближайших соседей
+func TestKNearest(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	random := make([]Point, 2000)
+	for i := range random {
+		random[i] = Point{[]float64{rnd.Float64() * 100, rnd.Float64() * 100, rnd.Float64() * 100}}
+	}
+	var grid []Point // на решетке у многих точек одинаковое расстояние до цели
+	for x := 0; x < 20; x++ {
+		for y := 0; y < 20; y++ {
+			grid = append(grid, Point{[]float64{float64(x), float64(y)}})
+		}
+	}
+
+	for _, points := range [][]Point{random, grid} {
+		built, err := Build(points)
+		require.NoError(t, err)
+		inserted := newTestTree(t, points)
+
+		targets := []Point{points[0], points[len(points)/2]}
+		for _, target := range targets {
+			for _, k := range []int{1, 10, 50, len(points) + 5} {
+				expected := bruteKNearest(points, target, k, Euclidean{})
+				for _, tree := range []*Tree{built, inserted} {
+					result, err := tree.KNearest(target, k)
+					require.NoError(t, err)
+					require.Equal(t, expected, result, "k = %d, цель %v", k, target.Coordinates)
+				}
+			}
+		}
+	}
+
+	result, err := New(2).KNearest(Point{[]float64{1, 1}}, 5)
+	require.NoError(t, err)
+	require.Empty(t, result)
+
+	tree := newTestTree(t, grid)
+	result, err = tree.KNearest(Point{[]float64{1, 1}}, 0)
+	require.NoError(t, err)
+	require.Empty(t, result)
+	_, err = tree.KNearest(Point{[]float64{1, 1}}, -1)
+	require.ErrorIs(t, err, ErrInvalidK)
+	_, err = tree.KNearest(Point{[]float64{1}}, 1)
+	require.ErrorIs(t, err, ErrDimensionMismatch)
+}
+
+// Вспомогательная функция для поиска k ближайших по метрике m соседей перебором
+func bruteKNearest(points []Point, target Point, k int, m Metric) []Neighbor {
+	all := make([]Neighbor, 0, len(points))
+	for _, p := range points {
+		all = append(all, Neighbor{Point: p, Distance: m.Distance(p, target)})
+	}
+	sort.Slice(all, func(i, j int) bool { return closer(all[i], all[j]) })
+	return all[:min(k, len(all))]
+}
+
+// Тестируем поиск в шаре
+func TestWithinRadius(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	points := make([]Point, 3000)
+	for i := range points {
+		points[i] = Point{[]float64{rnd.Float64() * 100, rnd.Float64() * 100}}
+	}
+	points = append(points, Point{[]float64{53, 50}}) // ровно на границе шара радиуса 3 вокруг (50, 50)
+
+	built, err := Build(points)
+	require.NoError(t, err)
+	for _, tree := range []*Tree{built, newTestTree(t, points)} {
+		for _, r := range []float64{0, 3, 10, 200, math.Inf(1)} {
+			center := Point{[]float64{50, 50}}
+			var expected []Neighbor
+			for _, p := range points {
+				if d := (Euclidean{}).Distance(p, center); d <= r {
+					expected = append(expected, Neighbor{Point: p, Distance: d})
+				}
+			}
+			sort.Slice(expected, func(i, j int) bool { return closer(expected[i], expected[j]) })
+
+			sorted, err := tree.WithinRadiusSorted(center, r)
+			require.NoError(t, err)
+			require.Equal(t, len(expected), len(sorted), "r = %v", r)
+			if len(expected) > 0 {
+				require.Equal(t, expected, sorted, "r = %v", r)
+			}
+
+			unsorted, err := tree.WithinRadius(center, r)
+			require.NoError(t, err)
+			sort.Slice(unsorted, func(i, j int) bool { return closer(unsorted[i], unsorted[j]) })
+			require.Equal(t, sorted, unsorted)
+
+			count, err := tree.CountWithinRadius(center, r)
+			require.NoError(t, err)
+			require.Equal(t, len(expected), count)
+		}
+	}
+
+	found, err := built.WithinRadius(Point{[]float64{53, 50}}, 0) // точка в центре шара нулевого радиуса находится
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+
+	found, err = New(2).WithinRadius(Point{[]float64{0, 0}}, 10)
+	require.NoError(t, err)
+	require.Empty(t, found)
+
+	for _, r := range []float64{-1, math.NaN()} {
+		_, err = built.WithinRadius(Point{[]float64{0, 0}}, r)
+		require.ErrorIs(t, err, ErrInvalidRadius)
+		_, err = built.CountWithinRadius(Point{[]float64{0, 0}}, r)
+		require.ErrorIs(t, err, ErrInvalidRadius)
+	}
+	_, err = built.WithinRadiusSorted(Point{[]float64{0}}, 1)
+	require.ErrorIs(t, err, ErrDimensionMismatch)
+}
+
 // Тестируем расчет расстояния между двумя точками
 func TestDistance(t *testing.T) {
 	pointA := Point{[]float64{1, 2}}
 	pointB := Point{[]float64{4, 6}}
 	expected := math.Sqrt(25) // 5.0
 
-	result := distance(pointA, pointB)
+	result := Euclidean{}.Distance(pointA, pointB)
 
 	require.Equal(t, expected, result, "Ожидаемое расстояние %v, полученное %v", expected, result)
 }
+
+// Тестируем поиск с разными метриками
+func TestMetrics(t *testing.T) {
+	rnd := rand.New(rand.NewSource(3))
+	points := make([]Point, 1500)
+	for i := range points {
+		points[i] = Point{[]float64{rnd.Float64() * 100, rnd.Float64() * 10, float64(rnd.Intn(20))}}
+	}
+
+	metrics := []Metric{
+		Euclidean{},
+		SquaredEuclidean{},
+		Manhattan{},
+		Chebyshev{},
+		WeightedEuclidean{Weights: []float64{1, 25, 0.5}},
+		WeightedEuclidean{Weights: []float64{1, 0, 1}}, // ось с нулевым весом не влияет на расстояние
+		Minkowski{P: 3},
+		Minkowski{P: math.Inf(1)},
+	}
+	for _, m := range metrics {
+		built, err := BuildWithOptions(points, BuildOptions{Metric: m, SplitBySpread: true})
+		require.NoError(t, err)
+		inserted := NewWithMetric(3, m)
+		for _, p := range points {
+			require.NoError(t, inserted.Insert(p))
+		}
+
+		for _, tree := range []*Tree{built, inserted} {
+			require.Equal(t, m, tree.Metric())
+			for _, target := range []Point{{[]float64{50, 5, 10}}, {[]float64{-10, 20, 3}}} {
+				expected := bruteKNearest(points, target, 30, m)
+
+				_, dist, err := tree.FindNearest(target)
+				require.NoError(t, err)
+				require.Equal(t, expected[0].Distance, dist, "%T", m)
+
+				result, err := tree.KNearest(target, 30)
+				require.NoError(t, err)
+				require.Equal(t, expected, result, "%T", m)
+
+				r := expected[len(expected)-1].Distance // шар ровно до 30-го соседа включительно
+				within, err := tree.WithinRadiusSorted(target, r)
+				require.NoError(t, err)
+				require.Equal(t, bruteKNearest(points, target, len(within), m), within, "%T", m)
+				require.GreaterOrEqual(t, len(within), 30)
+				count, err := tree.CountWithinRadius(target, r)
+				require.NoError(t, err)
+				require.Equal(t, len(within), count)
+			}
+		}
+	}
+
+	a, b := Point{[]float64{1, 2, 3}}, Point{[]float64{4, 6, 3}}
+	require.Equal(t, 5.0, Euclidean{}.Distance(a, b))
+	require.Equal(t, 25.0, SquaredEuclidean{}.Distance(a, b))
+	require.Equal(t, 7.0, Manhattan{}.Distance(a, b))
+	require.Equal(t, 4.0, Chebyshev{}.Distance(a, b))
+	require.Equal(t, 7.0, Minkowski{P: 1}.Distance(a, b))
+	require.InDelta(t, 5.0, Minkowski{P: 2}.Distance(a, b), 1e-12)
+	require.Equal(t, 4.0, Minkowski{P: math.Inf(1)}.Distance(a, b))
+	require.Equal(t, math.Sqrt(9+4*16), WeightedEuclidean{Weights: []float64{1, 4, 9}}.Distance(a, b))
+
+	for _, m := range []SumMetric{Euclidean{}, SquaredEuclidean{}, Manhattan{}, WeightedEuclidean{Weights: []float64{1, 4, 9}}} {
+		var sum float64
+		for i := range a.Coordinates {
+			sum += m.AxisTerm(i, a.Coordinates[i]-b.Coordinates[i])
+		}
+		require.Equal(t, m.Distance(a, b), m.FromSum(sum), "%T", m) // вклады осей складываются в то же расстояние
+	}
+
+	for _, m := range []Metric{nil, WeightedEuclidean{Weights: []float64{1, 1}}, WeightedEuclidean{Weights: []float64{1, -1, 1}}, Minkowski{P: 0.5}, Minkowski{}} {
+		_, err := BuildWithOptions(points, BuildOptions{Metric: m, Dims: 3})
+		if m == nil { // nil в параметрах построения - метрика по умолчанию
+			require.NoError(t, err)
+			continue
+		}
+		require.ErrorIs(t, err, ErrInvalidMetric)
+		require.Panics(t, func() { NewWithMetric(3, m) })
+	}
+	require.Panics(t, func() { NewWithMetric(3, nil) })
+}
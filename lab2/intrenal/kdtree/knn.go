@@ -0,0 +1,109 @@
+package kdtree
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	ErrInvalidK = errors.New("k must not be negative")
+)
+
+// Neighbor - найденная точка и ее расстояние до цели
+type Neighbor struct {
+	Point    Point
+	Distance float64
+}
+
+// KNearest возвращает k ближайших к target точек, отсортированных по расстоянию.
+// Точки на одинаковом расстоянии упорядочиваются по координатам, поэтому результат не зависит от формы дерева.
+// Если точек в дереве меньше k, возвращаются все.
+func (t *Tree) KNearest(target Point, k int) ([]Neighbor, error) {
+	if err := t.checkDims(target); err != nil {
+		return nil, fmt.Errorf("kdtree - KNearest: %w", err)
+	}
+	if k < 0 {
+		return nil, fmt.Errorf("kdtree - KNearest: %w: %d", ErrInvalidK, k)
+	}
+
+	h := &neighborHeap{k: k, items: make([]Neighbor, 0, min(k, t.size))}
+	if k > 0 {
+		t.root.kNearest(target, t.metric, h)
+	}
+
+	result := h.items
+	sort.Slice(result, func(i, j int) bool { return closer(result[i], result[j]) })
+
+	return result, nil
+}
+
+// kNearest добавляет в h ближайшие к target по метрике m точки поддерева
+func (n *node) kNearest(target Point, m Metric, h *neighborHeap) {
+	if n == nil {
+		return
+	}
+
+	var next, other *node
+	if target.Coordinates[n.axis] < n.point.Coordinates[n.axis] {
+		next, other = n.left, n.right // Сначала спускаемся в поддерево, где лежит цель
+	} else {
+		next, other = n.right, n.left
+	}
+
+	next.kNearest(target, m, h)
+	h.offer(Neighbor{Point: n.point, Distance: m.Distance(n.point, target)})
+
+	// Другая ветка нужна, пока куча не заполнена или разделяющая плоскость не дальше худшего найденного.
+	// Точка на том же расстоянии, что и худшая, может вытеснить ее по координатам, поэтому равенство не отсекается.
+	if !h.full() || m.AxisBound(n.axis, n.point.Coordinates[n.axis]-target.Coordinates[n.axis]) <= h.worst().Distance {
+		other.kNearest(target, m, h)
+	}
+}
+
+// neighborHeap - куча не больше k соседей, на вершине самый дальний
+type neighborHeap struct {
+	k     int
+	items []Neighbor
+}
+
+// offer добавляет соседа, если куча не заполнена или он ближе самого дальнего
+func (h *neighborHeap) offer(nb Neighbor) {
+	if !h.full() {
+		heap.Push(h, nb)
+		return
+	}
+	if closer(nb, h.items[0]) {
+		h.items[0] = nb
+		heap.Fix(h, 0)
+	}
+}
+
+func (h *neighborHeap) full() bool      { return len(h.items) >= h.k }
+func (h *neighborHeap) worst() Neighbor { return h.items[0] }
+
+func (h *neighborHeap) Len() int           { return len(h.items) }
+func (h *neighborHeap) Less(i, j int) bool { return closer(h.items[j], h.items[i]) } // на вершине самый дальний
+func (h *neighborHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *neighborHeap) Push(x any)         { h.items = append(h.items, x.(Neighbor)) }
+
+func (h *neighborHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+// closer сравнивает соседей по расстоянию, а при равенстве - по координатам, что бы порядок был детерминированным
+func closer(a, b Neighbor) bool {
+	if a.Distance != b.Distance {
+		return a.Distance < b.Distance
+	}
+	for i := range a.Point.Coordinates {
+		if a.Point.Coordinates[i] != b.Point.Coordinates[i] {
+			return a.Point.Coordinates[i] < b.Point.Coordinates[i]
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,184 @@
+package kdtree
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	ErrInvalidMetric = errors.New("invalid metric")
+)
+
+// Metric - функция расстояния для поиска в дереве.
+// AxisBound(axis, diff) - нижняя оценка расстояния между точками, координаты которых по оси axis отличаются на diff.
+// По ней поиск отсекает поддеревья: все точки за разделяющей плоскостью не ближе AxisBound от цели.
+// Оценка должна быть не больше Distance для любых таких точек и не убывать с ростом |diff|,
+// иначе поиск пропустит точки.
+type Metric interface {
+	Distance(a, b Point) float64
+	AxisBound(axis int, diff float64) float64
+}
+
+// SumMetric - необязательное расширение Metric для метрик вида FromSum(sum AxisTerm(i, d[i])).
+// По нему поиск в шаре точно считает расстояние до ячейки поддерева из отступов по всем осям,
+// а не берет наибольшую из оценок AxisBound. AxisTerm(axis, 0) должен быть 0, FromSum - не убывать.
+type SumMetric interface {
+	Metric
+	AxisTerm(axis int, diff float64) float64
+	FromSum(sum float64) float64
+}
+
+// Euclidean - евклидово расстояние; метрика по умолчанию
+type Euclidean struct{}
+
+func (Euclidean) Distance(a, b Point) float64 {
+	return math.Sqrt(SquaredEuclidean{}.Distance(a, b)) // Возвращаем квадратный корень из суммы квадратов разностей
+}
+
+func (Euclidean) AxisBound(_ int, diff float64) float64 {
+	return math.Abs(diff)
+}
+
+func (Euclidean) AxisTerm(_ int, diff float64) float64 {
+	return diff * diff
+}
+
+func (Euclidean) FromSum(sum float64) float64 {
+	return math.Sqrt(sum)
+}
+
+// SquaredEuclidean - квадрат евклидова расстояния: тот же порядок соседей, что у Euclidean, но без корня.
+// Расстояния и радиус поиска тоже в квадратах.
+type SquaredEuclidean struct{}
+
+func (SquaredEuclidean) Distance(a, b Point) float64 {
+	var sum float64
+	for i := range a.Coordinates { // Проходим по всем координатам и считаем сумму квадратов разностей
+		diff := a.Coordinates[i] - b.Coordinates[i]
+		sum += diff * diff
+	}
+	return sum
+}
+
+func (SquaredEuclidean) AxisBound(_ int, diff float64) float64 {
+	return diff * diff
+}
+
+func (SquaredEuclidean) AxisTerm(_ int, diff float64) float64 {
+	return diff * diff
+}
+
+func (SquaredEuclidean) FromSum(sum float64) float64 {
+	return sum
+}
+
+// Manhattan - сумма модулей разностей координат
+type Manhattan struct{}
+
+func (Manhattan) Distance(a, b Point) float64 {
+	var sum float64
+	for i := range a.Coordinates {
+		sum += math.Abs(a.Coordinates[i] - b.Coordinates[i])
+	}
+	return sum
+}
+
+func (Manhattan) AxisBound(_ int, diff float64) float64 {
+	return math.Abs(diff)
+}
+
+func (Manhattan) AxisTerm(_ int, diff float64) float64 {
+	return math.Abs(diff)
+}
+
+func (Manhattan) FromSum(sum float64) float64 {
+	return sum
+}
+
+// Chebyshev - наибольший модуль разности координат
+type Chebyshev struct{}
+
+func (Chebyshev) Distance(a, b Point) float64 {
+	var dist float64
+	for i := range a.Coordinates {
+		dist = max(dist, math.Abs(a.Coordinates[i]-b.Coordinates[i]))
+	}
+	return dist
+}
+
+func (Chebyshev) AxisBound(_ int, diff float64) float64 {
+	return math.Abs(diff)
+}
+
+// WeightedEuclidean - евклидово расстояние с весом каждой оси: sqrt(sum(w[i] * d[i]^2)).
+// Весов должно быть столько же, сколько осей у дерева, и все они неотрицательные.
+type WeightedEuclidean struct {
+	Weights []float64
+}
+
+func (m WeightedEuclidean) Distance(a, b Point) float64 {
+	var sum float64
+	for i := range a.Coordinates {
+		diff := a.Coordinates[i] - b.Coordinates[i]
+		sum += m.Weights[i] * diff * diff
+	}
+	return math.Sqrt(sum)
+}
+
+func (m WeightedEuclidean) AxisBound(axis int, diff float64) float64 {
+	return math.Sqrt(m.Weights[axis]) * math.Abs(diff)
+}
+
+func (m WeightedEuclidean) AxisTerm(axis int, diff float64) float64 {
+	return m.Weights[axis] * diff * diff
+}
+
+func (WeightedEuclidean) FromSum(sum float64) float64 {
+	return math.Sqrt(sum)
+}
+
+// Minkowski - расстояние Минковского порядка P: (sum(|d[i]|^P))^(1/P).
+// P = 1 - Manhattan, P = 2 - Euclidean, P = +Inf - Chebyshev; P меньше 1 не задает метрику.
+type Minkowski struct {
+	P float64
+}
+
+func (m Minkowski) Distance(a, b Point) float64 {
+	if math.IsInf(m.P, 1) {
+		return Chebyshev{}.Distance(a, b)
+	}
+
+	var sum float64
+	for i := range a.Coordinates {
+		sum += math.Pow(math.Abs(a.Coordinates[i]-b.Coordinates[i]), m.P)
+	}
+	return math.Pow(sum, 1/m.P)
+}
+
+func (m Minkowski) AxisBound(_ int, diff float64) float64 {
+	return math.Abs(diff)
+}
+
+// checkMetric проверяет параметры встроенных метрик для дерева размерности dims
+func checkMetric(m Metric, dims int) error {
+	switch m := m.(type) {
+	case nil:
+		return fmt.Errorf("%w: nil", ErrInvalidMetric)
+	case WeightedEuclidean:
+		if len(m.Weights) != dims {
+			return fmt.Errorf("%w: %d weights for %d dimensions", ErrInvalidMetric, len(m.Weights), dims)
+		}
+		for i, w := range m.Weights {
+			if !(w >= 0) || math.IsInf(w, 1) {
+				return fmt.Errorf("%w: weight %d is %v", ErrInvalidMetric, i, w)
+			}
+		}
+	case Minkowski:
+		if !(m.P >= 1) {
+			return fmt.Errorf("%w: minkowski p = %v", ErrInvalidMetric, m.P)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package kdtree
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+var (
+	ErrInvalidRadius = errors.New("radius must be a non-negative number")
+)
+
+// WithinRadius возвращает все точки на расстоянии не больше r от center в порядке обхода дерева.
+// r задается в единицах метрики дерева, для SquaredEuclidean - в квадратах.
+func (t *Tree) WithinRadius(center Point, r float64) ([]Neighbor, error) {
+	result := []Neighbor{}
+	err := t.withinRadius(center, r, func(n *node, dist float64) {
+		result = append(result, Neighbor{Point: n.point, Distance: dist})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("kdtree - WithinRadius: %w", err)
+	}
+
+	return result, nil
+}
+
+// WithinRadiusSorted - WithinRadius с результатом, отсортированным по расстоянию так же, как у KNearest
+func (t *Tree) WithinRadiusSorted(center Point, r float64) ([]Neighbor, error) {
+	result, err := t.WithinRadius(center, r)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(result, func(i, j int) bool { return closer(result[i], result[j]) })
+
+	return result, nil
+}
+
+// CountWithinRadius возвращает количество точек на расстоянии не больше r от center, не собирая их
+func (t *Tree) CountWithinRadius(center Point, r float64) (int, error) {
+	count := 0
+	err := t.withinRadius(center, r, func(*node, float64) { count++ })
+	if err != nil {
+		return 0, fmt.Errorf("kdtree - CountWithinRadius: %w", err)
+	}
+
+	return count, nil
+}
+
+// withinRadius вызывает visit для каждого узла шара с его расстоянием до center
+func (t *Tree) withinRadius(center Point, r float64, visit func(n *node, dist float64)) error {
+	if err := t.checkDims(center); err != nil {
+		return err
+	}
+	if !(r >= 0) { // !(r >= 0) отсекает и NaN
+		return fmt.Errorf("%w: %v", ErrInvalidRadius, r)
+	}
+
+	s := radiusSearch{center: center, r: r, metric: t.metric, off: make([]float64, t.dims), visit: visit}
+	s.sum, _ = t.metric.(SumMetric)
+	s.walk(t.root, 0)
+
+	return nil
+}
+
+// radiusSearch - состояние поиска в шаре. Отсечение идет по расстоянию от центра до ячейки поддерева:
+// off[i] - насколько ячейка отстоит от центра по оси i. Для SumMetric расстояние до ячейки точное:
+// сумма вкладов осей обновляется при каждом шаге за разделяющую плоскость. Для остальных метрик
+// нижняя оценка - наибольшая из оценок AxisBound по осям; оценка по оси узла - расстояние до плоскости,
+// остальные оси отсекают ячейки, которые плоскость пересекают, но лежат в стороне от шара.
+type radiusSearch struct {
+	center Point
+	r      float64
+	metric Metric
+	sum    SumMetric // nil - метрика не раскладывается по осям
+	off    []float64
+	visit  func(n *node, dist float64)
+}
+
+// walk обходит поддерево n, cell - сумма вкладов осей в расстояние до его ячейки (только для SumMetric)
+func (s *radiusSearch) walk(n *node, cell float64) {
+	if n == nil {
+		return
+	}
+
+	if d := s.metric.Distance(n.point, s.center); d <= s.r {
+		s.visit(n, d)
+	}
+
+	diff := s.center.Coordinates[n.axis] - n.point.Coordinates[n.axis]
+	near, far := n.right, n.left
+	if diff < 0 {
+		near, far = n.left, n.right
+	}
+
+	s.walk(near, cell) // центр на стороне near, ее ячейка не дальше ячейки узла
+
+	// ячейка far начинается на разделяющей плоскости, по оси узла она отстоит от центра на |diff|
+	old := s.off[n.axis]
+	s.off[n.axis] = math.Abs(diff)
+	if farCell, farBound := s.cellBound(cell, n.axis, old); farBound <= s.r {
+		s.walk(far, farCell)
+	}
+	s.off[n.axis] = old
+}
+
+// cellBound возвращает нижнюю оценку расстояния от центра до текущей ячейки, у которой отступ
+// по оси axis только что сменился с old, и для SumMetric новую сумму вкладов осей
+func (s *radiusSearch) cellBound(cell float64, axis int, old float64) (float64, float64) {
+	if s.sum != nil {
+		cell = cell - s.sum.AxisTerm(axis, old) + s.sum.AxisTerm(axis, s.off[axis])
+		return cell, s.sum.FromSum(cell)
+	}
+
+	var bound float64
+	for axis, off := range s.off {
+		if off > 0 {
+			bound = max(bound, s.metric.AxisBound(axis, off))
+		}
+	}
+
+	return 0, bound
+}